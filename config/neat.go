@@ -13,6 +13,15 @@ type NEAT struct {
 
 	RandomInitialActivations bool
 	ConstantActivations      bool
+	ActivationFunctions      []string // Registered functions mutation can pick from, nil for all of them
+
+	// Allow cycles, so networks can have memory. RelaxationSteps is how many steps a recurrent network gets to settle
+	// when it is activated without a time dimension
+	Recurrent       bool
+	RelaxationSteps int
+
+	// Fix invalid genomes after crossover and mutation instead of letting them into the population
+	AutoRepair bool
 
 	MinWeight float64
 	MaxWeight float64
@@ -29,14 +38,22 @@ func NEATDefault() *NEAT {
 
 		RandomInitialActivations: false,
 		ConstantActivations:      true,
+		ActivationFunctions:      []string{neat.NEATSigmoidStr},
+
+		Recurrent:       false,
+		RelaxationSteps: 1,
+
+		AutoRepair: true,
 
 		MinWeight: -1,
 		MaxWeight: 1,
 
 		MutationRatios: map[ma.MutationType]float64{
-			neat.MutationAddConnection: 0.05,
-			neat.MutationAddNode:       0.03,
-			neat.MutationMutateWeights: 0.92,
+			neat.MutationAddConnection:     0.05,
+			neat.MutationAddNode:           0.03,
+			neat.MutationMutateWeights:     0.85,
+			neat.MutationMutateBias:        0.05,
+			neat.MutationChangeAggregation: 0.02,
 		},
 	}
 }
@@ -49,15 +66,23 @@ func CPPNDefault() *NEAT {
 		OutputNodes:              1,
 		RandomInitialActivations: true,
 		ConstantActivations:      false,
+		ActivationFunctions:      nil,
+
+		Recurrent:       false,
+		RelaxationSteps: 1,
+
+		AutoRepair: true,
 
 		MinWeight: -1,
 		MaxWeight: 1,
 
 		MutationRatios: map[ma.MutationType]float64{
-			neat.MutationAddConnection:   0.05,
-			neat.MutationAddNode:         0.03,
-			neat.MutationMutateWeights:   0.89,
-			neat.MutationChangeAFunction: 0.03,
+			neat.MutationAddConnection:     0.05,
+			neat.MutationAddNode:           0.03,
+			neat.MutationMutateWeights:     0.82,
+			neat.MutationChangeAFunction:   0.03,
+			neat.MutationMutateBias:        0.05,
+			neat.MutationChangeAggregation: 0.02,
 		},
 	}
 }
@@ -23,6 +23,10 @@ type Population struct {
 	DropoffAge               int
 	SharingFunctionConstants []float64
 
+	// Restart after this many epochs without improvement, keeping the RestartKeep most fit organisms. 0 to disable
+	RestartStagnation int
+	RestartKeep       int
+
 	*Epoch
 }
 
@@ -46,6 +50,9 @@ func PopulationDefault() *Population {
 		DropoffAge:               math.MaxInt,
 		SharingFunctionConstants: []float64{1, 1, 0.4, 0.1},
 
+		RestartStagnation: 0,
+		RestartKeep:       0,
+
 		Epoch: EpochDefault(),
 	}
 }
@@ -64,6 +71,11 @@ func (cfg *Population) Configure(seed ma.Organism, fitnessFunction ma.FitnessFun
 	p.Cs = make([]float64, len(cfg.SharingFunctionConstants))
 	copy(p.Cs, cfg.SharingFunctionConstants)
 
+	if cfg.RestartStagnation > 0 {
+		p.RestartCondition = ma.RestartOnStagnation(cfg.RestartStagnation, 0)
+		p.RestartKeep = cfg.RestartKeep
+	}
+
 	return p
 }
 
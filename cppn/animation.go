@@ -0,0 +1,242 @@
+package cppn
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"os"
+
+	"github.com/TylerLeite/neuro-q/log"
+	"github.com/TylerLeite/neuro-q/ma"
+	"github.com/TylerLeite/neuro-q/neat"
+)
+
+// Animated CPPNs: time is one more input after the coordinates (and any other inputs), and each frame is a render
+// at a different time
+
+type AnimationOptions struct {
+	Render *RenderOptions
+
+	Frames int
+
+	// Time of the first frame and the end of the animation. The last frame is one step before TimeEnd, so an
+	// animation that's periodic over the range loops without a repeated frame
+	TimeStart, TimeEnd float64
+
+	Delay    int  // Between frames, in hundredths of a second
+	Loops    int  // Times to play the animation, 0 for forever
+	PingPong bool // Play forward then backward
+
+	Palette color.Palette // Colors for the GIF, nil for the web-safe Plan 9 palette
+	Dither  bool          // Floyd-Steinberg dithering when reducing to the palette
+}
+
+func DefaultAnimationOptions(width, height, frames int) *AnimationOptions {
+	return &AnimationOptions{
+		Render: DefaultRenderOptions(width, height),
+
+		Frames:  frames,
+		TimeEnd: 1,
+
+		Delay: 4,
+	}
+}
+
+// Time input for ActivateNetwork's otherInputs, reads t whenever it's called
+func TimeInput(t *float64) NetworkInputFunction {
+	return func(...float64) float64 {
+		return *t
+	}
+}
+
+func (a *AnimationOptions) frameTime(frame int) float64 {
+	return a.TimeStart + (a.TimeEnd-a.TimeStart)*float64(frame)/float64(a.Frames)
+}
+
+// Render every frame, each one in parallel tiles like Render
+func RenderFrames(n *neat.Network, a *AnimationOptions) []*image.RGBA {
+	frames := make([]*image.RGBA, a.Frames)
+
+	t := 0.0
+	o := *a.Render
+	o.OtherInputs = append(append([]NetworkInputFunction{}, a.Render.OtherInputs...), TimeInput(&t))
+
+	for i := range frames {
+		// Workers only read t, and they're all done before the next frame
+		t = a.frameTime(i)
+		frames[i] = Render(n, &o)
+	}
+
+	return frames
+}
+
+// Write frames as an animated GIF
+func EncodeGIF(w io.Writer, frames []*image.RGBA, a *AnimationOptions) error {
+	colors := a.Palette
+	if colors == nil {
+		colors = palette.Plan9
+	}
+
+	order := make([]int, 0, 2*len(frames))
+	for i := range frames {
+		order = append(order, i)
+	}
+	if a.PingPong {
+		// Don't repeat the frames at either end
+		for i := len(frames) - 2; i > 0; i -= 1 {
+			order = append(order, i)
+		}
+	}
+
+	out := &gif.GIF{
+		Image: make([]*image.Paletted, 0, len(order)),
+		Delay: make([]int, 0, len(order)),
+	}
+
+	// GIF counts repeats after the first time through, and -1 means play once
+	switch {
+	case a.Loops <= 0:
+		out.LoopCount = 0
+	case a.Loops == 1:
+		out.LoopCount = -1
+	default:
+		out.LoopCount = a.Loops - 1
+	}
+
+	paletted := make([]*image.Paletted, len(frames))
+	for i, frame := range frames {
+		paletted[i] = image.NewPaletted(frame.Bounds(), colors)
+		if a.Dither {
+			draw.FloydSteinberg.Draw(paletted[i], frame.Bounds(), frame, image.Point{})
+		} else {
+			draw.Draw(paletted[i], frame.Bounds(), frame, image.Point{}, draw.Src)
+		}
+	}
+
+	for _, i := range order {
+		out.Image = append(out.Image, paletted[i])
+		out.Delay = append(out.Delay, a.Delay)
+	}
+
+	return gif.EncodeAll(w, out)
+}
+
+func WriteGIF(n *neat.Network, a *AnimationOptions, fName string) error {
+	f, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return EncodeGIF(f, RenderFrames(n, a), a)
+}
+
+// Raw network outputs for each frame through ActivateNetwork, time is the last input
+func AnimationOutputs(n *neat.Network, dimensions []int, otherInputs []NetworkInputFunction, frames int, timeStart, timeEnd float64) [][][]float64 {
+	out, nan := animationOutputs(n, dimensions, otherInputs, frames, timeStart, timeEnd)
+	if nan {
+		log.Book(n.String(), log.DEBUG)
+		panic("NaN network")
+	}
+
+	return out
+}
+
+// AnimationOutputs, but NaN outputs are reported instead of panicking
+func animationOutputs(n *neat.Network, dimensions []int, otherInputs []NetworkInputFunction, frames int, timeStart, timeEnd float64) ([][][]float64, bool) {
+	t := 0.0
+	inputs := append(append([]NetworkInputFunction{}, otherInputs...), TimeInput(&t))
+
+	out := make([][][]float64, frames)
+	nan := false
+	for i := range out {
+		t = timeStart + (timeEnd-timeStart)*float64(i)/float64(frames)
+
+		var frameNaN bool
+		out[i], frameNaN = activateGrid(n, dimensions, inputs)
+		nan = nan || frameNaN
+	}
+
+	return out, nan
+}
+
+// Mean squared change of the outputs between frames, and mean squared change of that change (jerkiness). With loop,
+// the last frame leads back into the first
+func FrameChanges(frames [][][]float64, loop bool) (float64, float64) {
+	n := len(frames)
+	if n < 2 {
+		return 0, 0
+	}
+
+	// Frame after i, wrapping around when looping
+	next := func(i int) int {
+		return (i + 1) % n
+	}
+
+	motionSteps, jerkSteps := n-1, n-2
+	if loop {
+		motionSteps, jerkSteps = n, n
+	}
+
+	motion, jerk := 0.0, 0.0
+	motionCount, jerkCount := 0.0, 0.0
+
+	for i := 0; i < motionSteps; i += 1 {
+		a, b, c := frames[i], frames[next(i)], frames[next(next(i))]
+
+		for p := range a {
+			for k := range a[p] {
+				d1 := b[p][k] - a[p][k]
+				motion += d1 * d1
+				motionCount += 1
+
+				if i < jerkSteps {
+					d2 := c[p][k] - b[p][k]
+					jerk += (d2 - d1) * (d2 - d1)
+					jerkCount += 1
+				}
+			}
+		}
+	}
+
+	if motionCount > 0 {
+		motion /= motionCount
+	}
+	if jerkCount > 0 {
+		jerk /= jerkCount
+	}
+
+	return motion, jerk
+}
+
+// Fitness for evolving animations that change smoothly. Jerky ones score lower, 1/(1 + jerk/motion), and ones that
+// barely move (mean squared change under minMotion) are scaled down so a still image isn't the easy way out.
+// Renders small with the same coordinates as ActivateNetwork, time runs over [0, 1). NaN outputs get 0
+func SmoothAnimationFitness(width, height, frames int, loop bool, minMotion float64) ma.FitnessFunction {
+	return func(o ma.Organism) float64 {
+		n := o.(*neat.Network)
+		n.Compile()
+
+		outputs, nan := animationOutputs(n, []int{width, height}, nil, frames, 0, 1)
+		if nan {
+			return 0
+		}
+
+		// Outputs can still blow up to infinity
+		motion, jerk := FrameChanges(outputs, loop)
+		if motion == 0 || math.IsNaN(motion) || math.IsInf(motion, 0) {
+			return 0
+		}
+
+		fitness := 1 / (1 + jerk/motion)
+		if motion < minMotion {
+			fitness *= motion / minMotion
+		}
+
+		return fitness
+	}
+}
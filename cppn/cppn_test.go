@@ -1,14 +1,18 @@
 package cppn
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
+	"image/gif"
 	"image/png"
 	"math"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/TylerLeite/neuro-q/ma"
 	"github.com/TylerLeite/neuro-q/neat"
 )
 
@@ -88,7 +92,7 @@ func TestKnown(t *testing.T) {
 		}
 	}
 
-	f, _ := os.Create("_known.png")
+	f, _ := os.Create(filepath.Join(t.TempDir(), "_known.png"))
 	png.Encode(f, img)
 }
 
@@ -148,7 +152,7 @@ func TestRandom(t *testing.T) {
 		}
 	}
 
-	f, _ := os.Create("_random.png")
+	f, _ := os.Create(filepath.Join(t.TempDir(), "_random.png"))
 	png.Encode(f, img)
 }
 
@@ -305,7 +309,7 @@ func TestGeneration(t *testing.T) {
 		}
 	}
 
-	f, _ := os.Create("_generated.png")
+	f, _ := os.Create(filepath.Join(t.TempDir(), "_generated.png"))
 	png.Encode(f, img)
 }
 
@@ -330,54 +334,12 @@ func TestMassive(t *testing.T) {
 		genome.AddConnection(false)
 	}
 
-	nodes := make(map[uint]bool)
-	for _, edgeGene := range genome.Connections {
-		nodes[edgeGene.InNode] = true
-		nodes[edgeGene.OutNode] = true
-	}
-
-	genome.ActivationFunctions = make(map[uint]string)
-	for nodeId := range nodes {
-		_, fnName := neat.RandomFunc()
-		genome.ActivationFunctions[nodeId] = fnName
+	for _, node := range genome.Nodes {
+		_, node.Activation = neat.RandomFunc()
 	}
 
 	n := neat.NewNetwork(genome, nil)
-	n.Draw("massive_network.bmp")
-
-	var (
-		bias *neat.Node
-		inX  *neat.Node
-		inY  *neat.Node
-	)
-
-	for _, nodeI := range n.DNA.SensorNodes {
-		node := n.Nodes[nodeI]
-		if node.Label == "0" {
-			bias = node
-		} else if node.Label == "1" {
-			inX = node
-		} else {
-			inY = node
-		}
-	}
-
-	var (
-		outR *neat.Node
-		outG *neat.Node
-		outB *neat.Node
-	)
-
-	for _, nodeI := range n.DNA.OutputNodes {
-		node := n.Nodes[nodeI]
-		if node.Label == "3" {
-			outR = node
-		} else if node.Label == "4" {
-			outG = node
-		} else {
-			outB = node
-		}
-	}
+	n.Draw(filepath.Join(t.TempDir(), "massive_network.bmp"))
 
 	// Output as an image
 	const (
@@ -392,19 +354,14 @@ func TestMassive(t *testing.T) {
 	for x := float64(0); x < w; x += 1 {
 		for y := float64(0); y < h; y += 1 {
 
-			err := n.Activate(
-				[]float64{1, scale * (x - w/2), scale * (y - h/2)},
-				[]*neat.Node{bias, inX, inY},
-				[]*neat.Node{outR, outG, outB},
-			)
-
+			outputs, err := n.Predict([]float64{scale * (x - w/2), scale * (y - h/2)})
 			if err != nil {
 				panic(err)
 			}
 
-			_r := outR.Value()
-			_g := outG.Value()
-			_b := outB.Value()
+			_r := outputs[0]
+			_g := outputs[1]
+			_b := outputs[2]
 			r := clamp(256 * neat.GaussianFunc(_r))
 			g := clamp(256 * neat.StepFunc(_g))
 			b := clamp(256 * neat.NEATSigmoidFunc(_b))
@@ -418,6 +375,360 @@ func TestMassive(t *testing.T) {
 		}
 	}
 
-	f, _ := os.Create("massive_generated.png")
+	f, _ := os.Create(filepath.Join(t.TempDir(), "massive_generated.png"))
 	png.Encode(f, img)
 }
+
+func TestSubstrate(t *testing.T) {
+	neat.ResetInnovationHistory()
+
+	// CPPN weight is just the x coordinate of the source node
+	g := neat.NewGenome(4, 1, false, -1, 1)
+	g.Node(4).Activation = neat.IdentityStr
+	g.Connections = []*neat.EdgeGene{neat.NewEdgeGene(0, 4, 1, neat.NoMutation)}
+	cppn := neat.NewNetwork(g, nil)
+	innovations := neat.NextInnovationNumber
+
+	inputs := []Point{{-1, -1}, {0.1, -1}, {1, -1}}
+	s := NewSubstrate(2, inputs, nil, Line(1, 1))
+	s.OutputActivation = neat.IdentityStr
+
+	phenotype, err := s.Phenotype(cppn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if neat.NextInnovationNumber != innovations {
+		t.Error("building a phenotype shouldn't add to the innovation history")
+	}
+
+	// The middle input is under the threshold
+	if len(phenotype.DNA.Connections) != 2 {
+		t.Fatalf("expected 2 connections, got %v", phenotype.DNA.Connections)
+	}
+
+	out, err := phenotype.Predict([]float64{1, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := -s.MaxWeight + 2*s.MaxWeight; math.Abs(out[0]-expected) > 1e-9 {
+		t.Errorf("phenotype output should be %g, got %g", expected, out[0])
+	}
+
+	// Hidden layers get connected in between
+	s.Hidden = [][]Point{Line(2, 0)}
+	phenotype, err = s.Phenotype(cppn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(phenotype.DNA.HiddenNodes); n != 2 {
+		t.Errorf("expected 2 hidden nodes, got %d", n)
+	}
+
+	fitness := HyperNEATFitness(s, func(o ma.Organism) float64 {
+		return float64(len(o.(*neat.Network).DNA.Connections))
+	})
+	if f := fitness(cppn); f != float64(len(phenotype.DNA.Connections)) {
+		t.Errorf("fitness should come from the phenotype, got %g", f)
+	}
+
+	s.Inputs = []Point{{0, 0, 0}}
+	if _, err := s.Phenotype(cppn); err == nil {
+		t.Error("points with the wrong number of coordinates should be an error")
+	}
+}
+
+func TestEvolvableSubstrate(t *testing.T) {
+	neat.ResetInnovationHistory()
+
+	// Weight is a ridge along x1 + x2 = 0, so hidden nodes show up across from the input
+	g := neat.NewGenome(4, 1, false, -1, 1)
+	g.Node(4).Activation = neat.GaussianStr
+	g.Connections = []*neat.EdgeGene{
+		neat.NewEdgeGene(0, 4, 2, neat.NoMutation),
+		neat.NewEdgeGene(2, 4, 2, neat.NoMutation),
+	}
+	cppn := neat.NewNetwork(g, nil)
+	innovations := neat.NextInnovationNumber
+
+	s := NewEvolvableSubstrate([]Point{{-0.5, -1}}, []Point{{-0.5, 1}})
+	phenotype, err := s.Phenotype(cppn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if neat.NextInnovationNumber != innovations {
+		t.Error("building a phenotype shouldn't add to the innovation history")
+	}
+
+	if len(phenotype.DNA.HiddenNodes) == 0 {
+		t.Fatal("search should have placed some hidden nodes")
+	}
+
+	if problems := phenotype.DNA.Validate(); problems != nil {
+		t.Errorf("phenotype should be a valid feed-forward network: %v", problems)
+	}
+
+	// Everything left over is on a path from the input to the output
+	in, out := make(map[uint]bool), make(map[uint]bool)
+	for _, edge := range phenotype.DNA.Connections {
+		out[edge.InNode] = true
+		in[edge.OutNode] = true
+	}
+	for _, id := range phenotype.DNA.HiddenNodes {
+		if !in[id] || !out[id] {
+			t.Errorf("hidden node %d is a dead end", id)
+		}
+	}
+
+	// Flat weights have no information, so no hidden nodes
+	g = neat.NewGenome(4, 1, false, -1, 1)
+	g.Connections = []*neat.EdgeGene{}
+	phenotype, err = s.Phenotype(neat.NewNetwork(g, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(phenotype.DNA.HiddenNodes); n != 0 {
+		t.Errorf("constant CPPN shouldn't place hidden nodes, got %d", n)
+	}
+
+	s.Dimensions = 3
+	if _, err := s.Phenotype(cppn); err == nil {
+		t.Error("3D evolvable substrates should be an error")
+	}
+}
+
+func TestImageFitness(t *testing.T) {
+	neat.ResetInnovationHistory()
+
+	// Horizontal gradient, and a CPPN that paints exactly that
+	const size = 32
+	target := NewBitmap(size, size, 1)
+	for i, pix := range target.Pixels {
+		pix[0] = float64(i%size) / size
+	}
+
+	g := neat.NewGenome(2, 1, false, -1, 1)
+	g.Node(2).Activation = neat.IdentityStr
+	g.Connections = []*neat.EdgeGene{neat.NewEdgeGene(0, 2, 1, neat.NoMutation)}
+	gradient := neat.NewNetwork(g, nil)
+
+	// Vertical gradient instead
+	g = g.Copy().(*neat.Genome)
+	g.Connections = []*neat.EdgeGene{neat.NewEdgeGene(1, 2, 1, neat.NoMutation)}
+	wrong := neat.NewNetwork(g, nil)
+
+	f := NewImageFitness(target)
+	f.Bipolar = true
+	f.Metrics = map[ImageMetric]float64{MSEMetric: 1, SSIMMetric: 1, EdgeMetric: 1}
+
+	if fitness := f.Fitness(gradient); math.Abs(fitness-1) > 1e-9 {
+		t.Errorf("exact match should have fitness 1, got %g", fitness)
+	}
+	if f.Fitness(wrong) >= f.Fitness(gradient) {
+		t.Error("wrong gradient should be less fit")
+	}
+
+	// NaN outputs are unfit, not a crash
+	g = g.Copy().(*neat.Genome)
+	g.Connections[0].Weight = math.NaN()
+	if fitness := f.Fitness(neat.NewNetwork(g, nil)); fitness != 0 {
+		t.Errorf("NaN network should have fitness 0, got %g", fitness)
+	}
+
+	// Coarse levels are still a match
+	f = NewImageFitness(target)
+	f.Bipolar = true
+	f.Levels = 2
+	f.LevelEpochs = 5
+	f.SetEpoch(0)
+	if w := f.target(int(f.level)).Width; w != size/4 {
+		t.Errorf("first epochs should be at 1/4 size, got width %d", w)
+	}
+	if fitness := f.Fitness(gradient); fitness < 0.99 {
+		t.Errorf("downscaled gradient should still match, got %g", fitness)
+	}
+	f.SetEpoch(100)
+	if w := f.target(int(f.level)).Width; w != size {
+		t.Errorf("should be at full size eventually, got width %d", w)
+	}
+
+	// Round trip through a file
+	fName := filepath.Join(t.TempDir(), "target.png")
+	if err := f.Draw(gradient, fName); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBitmap(fName, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loss := f.Loss(loaded, target); loss > 1e-4 {
+		t.Errorf("saved render should match the target, loss %g", loss)
+	}
+}
+
+func TestRender(t *testing.T) {
+	neat.ResetInnovationHistory()
+
+	// Step along x, with a bias so the edge isn't on a pixel boundary
+	g := neat.NewGenome(2, 1, true, -1, 1)
+	g.Node(3).Activation = neat.StepStr
+	g.Connections = []*neat.EdgeGene{
+		neat.NewEdgeGene(1, 3, 1, neat.NoMutation),
+		neat.NewEdgeGene(0, 3, 0.03, neat.NoMutation),
+	}
+	n := neat.NewNetwork(g, nil)
+
+	// Same pixels as ActivateNetwork at the same size
+	const size = 40
+	o := DefaultRenderOptions(size, size)
+	o.Color = GrayscaleColor
+	o.TileSize = 7
+	img := Render(n, o)
+
+	expected := ActivateNetwork(n, []int{size, size}, nil)
+	for y := 0; y < size; y += 1 {
+		for x := 0; x < size; x += 1 {
+			want := uint8(math.Round(255 * clamp01(expected[x+size*y][0])))
+			if got := img.RGBAAt(x, y).R; got != want {
+				t.Fatalf("pixel (%d, %d) is %d, ActivateNetwork gives %d", x, y, got, want)
+			}
+		}
+	}
+
+	// Smooth gradients show any offset between the two, not just where the step lands. Red is x and green is y
+	g = neat.NewGenome(2, 2, true, -1, 1)
+	g.Node(3).Activation = neat.IdentityStr
+	g.Node(4).Activation = neat.IdentityStr
+	g.Connections = []*neat.EdgeGene{
+		neat.NewEdgeGene(1, 3, 1, neat.NoMutation),
+		neat.NewEdgeGene(2, 4, 1, neat.NoMutation),
+	}
+	gradient := neat.NewNetwork(g, nil)
+
+	gradientOptions := DefaultRenderOptions(size, size)
+	gradientOptions.Bipolar = true
+	gradientImg := Render(gradient, gradientOptions)
+
+	expected = ActivateNetwork(gradient, []int{size, size}, nil)
+	for y := 0; y < size; y += 1 {
+		for x := 0; x < size; x += 1 {
+			pix := gradientImg.RGBAAt(x, y)
+			wantR := uint8(math.Round(255 * (expected[x+size*y][0] + 1) / 2))
+			wantG := uint8(math.Round(255 * (expected[x+size*y][1] + 1) / 2))
+			if pix.R != wantR || pix.G != wantG {
+				t.Fatalf("pixel (%d, %d) is (%d, %d), ActivateNetwork gives (%d, %d)", x, y, pix.R, pix.G, wantR, wantG)
+			}
+		}
+	}
+
+	// More workers, same picture
+	o.Workers = 1
+	single := Render(n, o)
+	for i := range img.Pix {
+		if img.Pix[i] != single.Pix[i] {
+			t.Fatal("parallel render doesn't match a single worker")
+		}
+	}
+
+	// Supersampling blurs the edge
+	o.Supersample = 4
+	blurred := Render(n, o)
+	gray := 0
+	for x := 0; x < size; x += 1 {
+		if v := blurred.RGBAAt(x, 0).R; v != 0 && v != 255 {
+			gray += 1
+		}
+	}
+	if gray == 0 {
+		t.Error("supersampling should antialias the edge")
+	}
+
+	// Wide image keeps square pixels, zooming in narrows the view
+	o = DefaultRenderOptions(200, 100)
+	if x, y := o.coordinates(0, 0, 0, 0); x != -2 || y != -1 {
+		t.Errorf("corner of a 2:1 image should be (-2, -1), got (%g, %g)", x, y)
+	}
+	o.Zoom = 2
+	o.CenterX = 1
+	if x, _ := o.coordinates(0, 0, 0, 0); x != 0 {
+		t.Errorf("zoomed corner should be at x = 0, got %g", x)
+	}
+
+	r, g2, b := DefaultRenderOptions(1, 1).colorOf([]float64{1, 0, 0.5})
+	if r != 1 || g2 != 0 || b != 0.5 {
+		t.Errorf("RGB mapping is wrong: %g %g %g", r, g2, b)
+	}
+	o.Color = HSVColor
+	if r, g2, b := o.colorOf([]float64{1.0 / 3, 1, 1}); r != 0 || g2 != 1 || b != 0 {
+		t.Errorf("hue 1/3 should be green, got %g %g %g", r, g2, b)
+	}
+}
+
+func TestAnimation(t *testing.T) {
+	neat.ResetInnovationHistory()
+
+	// Inputs are bias, x, y and time. Brightness ramps up with time
+	g := neat.NewGenome(3, 1, true, -1, 1)
+	g.Node(4).Activation = neat.IdentityStr
+	g.Connections = []*neat.EdgeGene{neat.NewEdgeGene(3, 4, 0.5, neat.NoMutation)}
+	ramp := neat.NewNetwork(g, nil)
+
+	// Jumps once, halfway through
+	g = g.Copy().(*neat.Genome)
+	g.Node(4).Activation = neat.SigmoidStr
+	g.Connections = []*neat.EdgeGene{
+		neat.NewEdgeGene(3, 4, 100, neat.NoMutation),
+		neat.NewEdgeGene(0, 4, -47.5, neat.NoMutation),
+	}
+	jump := neat.NewNetwork(g, nil)
+
+	fitness := SmoothAnimationFitness(4, 4, 10, false, 1e-4)
+	if f := fitness(ramp); math.Abs(f-1) > 1e-9 {
+		t.Errorf("steady ramp should be perfectly smooth, got %g", f)
+	}
+	if fitness(jump) >= fitness(ramp) {
+		t.Error("a sudden jump should be less smooth than a ramp")
+	}
+
+	g = g.Copy().(*neat.Genome)
+	g.Connections[0].Weight = math.NaN()
+	if f := fitness(neat.NewNetwork(g, nil)); f != 0 {
+		t.Errorf("NaN animation should have fitness 0, got %g", f)
+	}
+
+	// Looping back to the start is a jump too
+	if f := SmoothAnimationFitness(4, 4, 10, true, 1e-4)(ramp); f >= 1 {
+		t.Errorf("ramp shouldn't be smooth when looped, got %g", f)
+	}
+
+	a := DefaultAnimationOptions(8, 8, 5)
+	a.Render.Color = GrayscaleColor
+	a.PingPong = true
+	a.Loops = 3
+	a.Dither = true
+
+	frames := RenderFrames(ramp, a)
+	if frames[0].RGBAAt(0, 0).R >= frames[4].RGBAAt(0, 0).R {
+		t.Error("frames should get brighter over time")
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeGIF(&buf, frames, a); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(decoded.Image); n != 8 {
+		t.Errorf("5 frames ping-ponged should make 8, got %d", n)
+	}
+	if decoded.LoopCount != 2 {
+		t.Errorf("playing 3 times is 2 repeats, got loop count %d", decoded.LoopCount)
+	}
+	if decoded.Delay[0] != a.Delay {
+		t.Errorf("delay should be %d, got %d", a.Delay, decoded.Delay[0])
+	}
+}
@@ -0,0 +1,434 @@
+package cppn
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/TylerLeite/neuro-q/log"
+	"github.com/TylerLeite/neuro-q/neat"
+)
+
+// ES-HyperNEAT (Risi & Stanley 2012): only the inputs and outputs have fixed places, hidden nodes go wherever the
+// CPPN's weight pattern has the most information. Around each node, a quadtree over [-1, 1]x[-1, 1] is divided
+// where the weights vary a lot, and the leaves that stand out from their neighbors become connections to new nodes
+
+type EvolvableSubstrate struct {
+	Substrate // Hidden is ignored, WeightThreshold too since the search decides what gets expressed
+
+	InitialDepth int // Quadtree is always divided this deep
+	MaxDepth     int // and never deeper than this
+
+	DivisionThreshold float64 // Divide a quadtree node further if its weights vary more than this
+	VarianceThreshold float64 // Search inside a quadtree node for connections if its weights vary more than this
+	BandThreshold     float64 // Keep a point if its weight differs from its neighbors' on both sides by more than this
+
+	IterationLevel int // How many times to search again from the hidden nodes just found
+
+	// Keep connections between hidden nodes that make cycles, and mark the phenotype recurrent. Otherwise a
+	// connection to a hidden node is only kept if it was found after the source
+	Recurrent bool
+}
+
+func NewEvolvableSubstrate(inputs, outputs []Point) *EvolvableSubstrate {
+	s := &EvolvableSubstrate{
+		Substrate: *NewSubstrate(2, inputs, nil, outputs),
+
+		InitialDepth: 3,
+		MaxDepth:     5,
+
+		DivisionThreshold: 0.03,
+		VarianceThreshold: 0.03,
+		BandThreshold:     0.3,
+
+		IterationLevel: 1,
+	}
+
+	s.WeightThreshold = 0
+
+	return s
+}
+
+type quadPoint struct {
+	x, y   float64
+	width  float64 // Half the side of the square
+	level  int
+	weight float64
+
+	children []*quadPoint
+}
+
+// Weights of all the leaves under q
+func (q *quadPoint) leafWeights(weights []float64) []float64 {
+	if len(q.children) == 0 {
+		return append(weights, q.weight)
+	}
+
+	for _, child := range q.children {
+		weights = child.leafWeights(weights)
+	}
+
+	return weights
+}
+
+func (q *quadPoint) variance() float64 {
+	if len(q.children) == 0 {
+		return 0
+	}
+
+	weights := q.leafWeights(nil)
+
+	mean := 0.0
+	for _, w := range weights {
+		mean += w
+	}
+	mean /= float64(len(weights))
+
+	variance := 0.0
+	for _, w := range weights {
+		variance += (w - mean) * (w - mean)
+	}
+
+	return variance / float64(len(weights))
+}
+
+// CPPN weight of the connection between p and (x, y), from p if outgoing and into p otherwise
+func (s *EvolvableSubstrate) weight(cppn *neat.Network, inputs []float64, p Point, x, y float64, outgoing bool) (float64, error) {
+	var outputs []float64
+	var err error
+	if outgoing {
+		outputs, err = s.query(cppn, inputs, p, Point{x, y})
+	} else {
+		outputs, err = s.query(cppn, inputs, Point{x, y}, p)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if math.IsNaN(outputs[0]) {
+		return 0, nil
+	}
+
+	return outputs[0], nil
+}
+
+// Build the quadtree of weights around p, breadth first
+func (s *EvolvableSubstrate) divide(cppn *neat.Network, inputs []float64, p Point, outgoing bool) (*quadPoint, error) {
+	root := &quadPoint{width: 1, level: 1}
+
+	queue := []*quadPoint{root}
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+
+		for _, corner := range [][2]float64{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}} {
+			child := &quadPoint{
+				x:     q.x + corner[0]*q.width/2,
+				y:     q.y + corner[1]*q.width/2,
+				width: q.width / 2,
+				level: q.level + 1,
+			}
+
+			w, err := s.weight(cppn, inputs, p, child.x, child.y, outgoing)
+			if err != nil {
+				return nil, err
+			}
+			child.weight = w
+
+			q.children = append(q.children, child)
+		}
+
+		if q.level < s.InitialDepth || (q.level < s.MaxDepth && q.variance() > s.DivisionThreshold) {
+			queue = append(queue, q.children...)
+		}
+	}
+
+	return root, nil
+}
+
+// Walk the quadtree, calling found for every leaf in a band (its weight stands out from the neighbors on both sides
+// along x or along y)
+func (s *EvolvableSubstrate) prune(cppn *neat.Network, inputs []float64, p Point, q *quadPoint, outgoing bool, found func(x, y, weight float64)) error {
+	for _, child := range q.children {
+		if child.variance() >= s.VarianceThreshold {
+			if err := s.prune(cppn, inputs, p, child, outgoing, found); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var neighbors [4]float64
+		for i, offset := range [][2]float64{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+			w, err := s.weight(cppn, inputs, p, child.x+offset[0]*q.width, child.y+offset[1]*q.width, outgoing)
+			if err != nil {
+				return err
+			}
+			neighbors[i] = math.Abs(child.weight - w)
+		}
+
+		band := math.Max(math.Min(neighbors[0], neighbors[1]), math.Min(neighbors[2], neighbors[3]))
+		if band > s.BandThreshold {
+			found(child.x, child.y, child.weight)
+		}
+	}
+
+	return nil
+}
+
+// Points (and weights) of the connections the search finds around p
+type discovery struct {
+	point  Point
+	weight float64
+}
+
+func (s *EvolvableSubstrate) search(cppn *neat.Network, inputs []float64, p Point, outgoing bool) ([]discovery, error) {
+	tree, err := s.divide(cppn, inputs, p, outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]discovery, 0)
+	err = s.prune(cppn, inputs, p, tree, outgoing, func(x, y, weight float64) {
+		found = append(found, discovery{Point{x, y}, weight})
+	})
+
+	return found, err
+}
+
+func pointKey(p Point) string {
+	return fmt.Sprint([]float64(p))
+}
+
+func (s *EvolvableSubstrate) Validate() error {
+	if s.Dimensions != 2 {
+		return &SubstrateError{Msg: "evolvable substrates are searched with a quadtree, so they have to be 2D"}
+	}
+
+	if s.InitialDepth < 1 || s.MaxDepth < s.InitialDepth {
+		return &SubstrateError{Msg: fmt.Sprintf("bad quadtree depths %d to %d", s.InitialDepth, s.MaxDepth)}
+	}
+
+	return s.Substrate.Validate()
+}
+
+// Search for hidden nodes and build the network. Node ids are the inputs, then the outputs, then hidden nodes in the
+// order they were found. Hidden nodes that aren't on a path from an input to an output are left out
+func (s *EvolvableSubstrate) Phenotype(cppn *neat.Network) (*neat.Network, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	cppn.Compile()
+	if len(cppn.DNA.OutputNodes) == 0 {
+		return nil, &SubstrateError{Msg: "CPPN has no outputs"}
+	}
+
+	inputs := make([]float64, s.CPPNInputCount())
+
+	// Ids by position, inputs and outputs can't be hidden nodes
+	ids := make(map[string]uint)
+	fixed := make(map[string]bool)
+	points := make([]Point, 0, len(s.Inputs)+len(s.Outputs))
+	for _, p := range append(append([]Point{}, s.Inputs...), s.Outputs...) {
+		ids[pointKey(p)] = uint(len(points))
+		fixed[pointKey(p)] = true
+		points = append(points, p)
+	}
+	if len(ids) != len(points) {
+		return nil, &SubstrateError{Msg: "inputs and outputs need distinct coordinates"}
+	}
+
+	hidden := make([]Point, 0)
+	type connection struct {
+		from, to uint
+	}
+	weights := make(map[connection]float64)
+	order := make([]connection, 0)
+
+	connect := func(from, to uint, weight float64) {
+		c := connection{from, to}
+		if _, ok := weights[c]; ok || from == to {
+			return
+		}
+
+		if !s.Recurrent && from >= to && to >= uint(len(s.Inputs)+len(s.Outputs)) {
+			// Hidden to hidden, found in the wrong order
+			return
+		}
+
+		weights[c] = weight * s.MaxWeight
+		order = append(order, c)
+	}
+
+	// Hidden node at p, made if it's new (then the last return is true). Not ok for inputs and outputs
+	hiddenNode := func(p Point) (uint, bool, bool) {
+		key := pointKey(p)
+		if fixed[key] {
+			return 0, false, false
+		}
+
+		if id, ok := ids[key]; ok {
+			return id, true, false
+		}
+
+		id := uint(len(points))
+		ids[key] = id
+		points = append(points, p)
+		hidden = append(hidden, p)
+		return id, true, true
+	}
+
+	// Inputs to hidden nodes, then out from the new hidden nodes IterationLevel times
+	unexplored := make([]Point, 0)
+	for _, p := range s.Inputs {
+		found, err := s.search(cppn, inputs, p, true)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range found {
+			id, ok, isNew := hiddenNode(d.point)
+			if !ok {
+				continue
+			}
+			if isNew {
+				unexplored = append(unexplored, d.point)
+			}
+			connect(ids[pointKey(p)], id, d.weight)
+		}
+	}
+
+	for i := 0; i < s.IterationLevel; i += 1 {
+		next := make([]Point, 0)
+		for _, p := range unexplored {
+			found, err := s.search(cppn, inputs, p, true)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, d := range found {
+				id, ok, isNew := hiddenNode(d.point)
+				if !ok {
+					continue
+				}
+				if isNew {
+					next = append(next, d.point)
+				}
+				connect(ids[pointKey(p)], id, d.weight)
+			}
+		}
+		unexplored = next
+	}
+
+	// Hidden nodes into outputs, only ones that were already found
+	for _, p := range s.Outputs {
+		found, err := s.search(cppn, inputs, p, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range found {
+			id, ok := ids[pointKey(d.point)]
+			if !ok || fixed[pointKey(d.point)] {
+				continue
+			}
+			connect(id, ids[pointKey(p)], d.weight)
+		}
+	}
+
+	// Only keep hidden nodes that inputs reach and that reach outputs
+	forward := make(map[uint][]uint)
+	backward := make(map[uint][]uint)
+	for _, c := range order {
+		forward[c.from] = append(forward[c.from], c.to)
+		backward[c.to] = append(backward[c.to], c.from)
+	}
+
+	reachable := func(start []Point, edges map[uint][]uint) map[uint]bool {
+		seen := make(map[uint]bool)
+		queue := make([]uint, 0)
+		for _, p := range start {
+			queue = append(queue, ids[pointKey(p)])
+		}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			queue = append(queue, edges[id]...)
+		}
+		return seen
+	}
+
+	fromInputs := reachable(s.Inputs, forward)
+	toOutputs := reachable(s.Outputs, backward)
+	keep := func(id uint) bool {
+		return id < uint(len(s.Inputs)+len(s.Outputs)) || (fromInputs[id] && toOutputs[id])
+	}
+
+	genome := &neat.Genome{
+		Nodes:       make([]*neat.NodeGene, 0),
+		Connections: make([]*neat.EdgeGene, 0),
+
+		Recurrent:       s.Recurrent,
+		RelaxationSteps: len(hidden) + 1,
+
+		MinWeight: -s.MaxWeight,
+		MaxWeight: s.MaxWeight,
+	}
+
+	for _, p := range s.Inputs {
+		genome.AddNodeGene(neat.NewNodeGene(ids[pointKey(p)], neat.SensorNode, neat.IdentityStr))
+	}
+	for _, p := range s.Outputs {
+		genome.AddNodeGene(neat.NewNodeGene(ids[pointKey(p)], neat.OutputNode, s.OutputActivation))
+	}
+	for _, p := range hidden {
+		if id := ids[pointKey(p)]; keep(id) {
+			genome.AddNodeGene(neat.NewNodeGene(id, neat.HiddenNode, s.HiddenActivation))
+		}
+	}
+
+	for _, c := range order {
+		if !keep(c.from) || !keep(c.to) {
+			continue
+		}
+
+		// Same as in Substrate.Phenotype, no innovation history for phenotypes
+		genome.Connections = append(genome.Connections, &neat.EdgeGene{
+			InNode:  c.from,
+			OutNode: c.to,
+			Enabled: true,
+			Weight:  weights[c],
+
+			Origin:           neat.NoMutation,
+			InnovationNumber: uint(len(genome.Connections)),
+		})
+	}
+
+	// Node biases from the second CPPN output, same as fixed substrates
+	if len(cppn.DNA.OutputNodes) > 1 {
+		origin := Point{0, 0}
+		for _, node := range genome.Nodes {
+			if node.Type == neat.SensorNode {
+				continue
+			}
+
+			outputs, err := s.query(cppn, inputs, origin, points[node.ID])
+			if err != nil {
+				return nil, err
+			}
+
+			if bias, ok := s.scale(outputs[1]); ok {
+				node.Bias = bias
+			}
+		}
+	}
+
+	log.Book(fmt.Sprintf("Evolvable substrate phenotype with %d hidden nodes and %d connections\n", len(genome.Nodes)-len(s.Inputs)-len(s.Outputs), len(genome.Connections)), log.DEBUG, log.DEBUG_EXPERIMENT)
+
+	genome.PopulateNodeSlices()
+	return neat.NewNetwork(genome, nil), nil
+}
@@ -7,7 +7,6 @@ import (
 	"image/png"
 	"math"
 	"os"
-	"strconv"
 
 	"github.com/TylerLeite/neuro-q/config"
 	"github.com/TylerLeite/neuro-q/log"
@@ -22,38 +21,41 @@ func Evolution(
 	drawFn DrawFunction,
 	popCfg *config.Population,
 	neatCfg *config.NEAT,
+) {
+	EvolutionWithHook(fn, drawFn, popCfg, neatCfg, nil)
+}
+
+// Called with the epoch number right before each epoch, for fitness functions that change over the run (like
+// ImageFitness.SetEpoch)
+type EpochHook func(epoch int)
+
+// Same as Evolution, with a hook that runs before every epoch. nil for none
+func EvolutionWithHook(
+	fn ma.FitnessFunction,
+	drawFn DrawFunction,
+	popCfg *config.Population,
+	neatCfg *config.NEAT,
+	beforeEpoch EpochHook,
 ) {
 	neat.ResetInnovationHistory()
 
 	// TODO: NewGenomeFromConfig
-	seedGenome := neat.NewGenome(
+	seedGenome := neat.NewGenomeWithHiddenNodes(
 		neatCfg.SensorNodes,
+		neatCfg.HiddenNodes,
 		neatCfg.OutputNodes,
 		neatCfg.UsesBias,
 		neatCfg.MinWeight,
 		neatCfg.MaxWeight,
 	)
 
-	if !neatCfg.ConstantActivations {
-		i := 0
-		seedGenome.ActivationFunctions = make(map[uint]string)
+	// Seed genome starts out with the default activation functions, members get random ones after Generate
+	seedGenome.MutableActivations = !neatCfg.ConstantActivations
+	seedGenome.ActivationSet = neatCfg.ActivationFunctions
 
-		if neatCfg.UsesBias {
-			seedGenome.ActivationFunctions[0] = "Identity"
-			i += 1
-		}
-
-		for j := 0; j < neatCfg.SensorNodes; j += 1 {
-			seedGenome.ActivationFunctions[uint(i+j)] = neat.IdentityStr
-		}
-		i += neatCfg.SensorNodes
-
-		for j := 0; j < neatCfg.OutputNodes; j += 1 {
-			seedGenome.ActivationFunctions[uint(i+j)] = neat.NEATSigmoidStr
-		}
-
-		// TODO: allow for hidden nodes in seed genome
-	}
+	seedGenome.Recurrent = neatCfg.Recurrent
+	seedGenome.RelaxationSteps = neatCfg.RelaxationSteps
+	seedGenome.AutoRepair = neatCfg.AutoRepair
 
 	seedGenome.MutationRatios = neatCfg.MutationRatios // TODO: deep copy?
 
@@ -85,9 +87,12 @@ func Evolution(
 		network := o.(*neat.Network)
 		genome := network.DNA
 
-		for nodeId := range genome.ActivationFunctions {
-			_, newFnName := neat.RandomFunc()
-			genome.ActivationFunctions[nodeId] = newFnName
+		if !genome.MutableActivations {
+			continue
+		}
+
+		for _, node := range genome.Nodes {
+			node.Activation = genome.RandomActivation(node.Type)
 		}
 
 		network.ForceCompile()
@@ -97,6 +102,9 @@ func Evolution(
 	for i := 0; i < G; i += 1 {
 		fmt.Printf("New generation, %d/%d [%d species] dt=%.2g\n", i+1, G, len(p.Species), p.DistanceThreshold)
 
+		if beforeEpoch != nil {
+			beforeEpoch(i)
+		}
 		p.Epoch()
 
 		// TODO: should this be a binary search?
@@ -121,6 +129,18 @@ func Evolution(
 type NetworkInputFunction func(...float64) float64
 
 func ActivateNetwork(n *neat.Network, dimensions []int, otherInputs []NetworkInputFunction) [][]float64 {
+	outMatrix, nan := activateGrid(n, dimensions, otherInputs)
+	if nan {
+		log.Book(n.String(), log.DEBUG)
+		panic("NaN network")
+	}
+
+	return outMatrix
+}
+
+// Same as ActivateNetwork, but NaN outputs are left in and reported instead of panicking. Fitness functions should
+// use this, one genome that blows up shouldn't take the whole run down with it
+func activateGrid(n *neat.Network, dimensions []int, otherInputs []NetworkInputFunction) ([][]float64, bool) {
 	outSize := 1
 	for _, dimension := range dimensions {
 		outSize *= dimension
@@ -129,32 +149,21 @@ func ActivateNetwork(n *neat.Network, dimensions []int, otherInputs []NetworkInp
 	log.Book(fmt.Sprintf("Outmatrix shape %dx%d\n", outSize, len(n.DNA.OutputNodes)), log.DEBUG, log.DEBUG_EXPERIMENT)
 
 	outMatrix := make([][]float64, outSize)
+	nan := false
 
-	sensorNodes := make([]*neat.Node, len(n.DNA.SensorNodes))
-	for _, nodeI := range n.DNA.SensorNodes {
-		node := n.Nodes[nodeI]
-		label, err := strconv.Atoi(node.Label)
-		if err != nil {
-			panic(fmt.Sprintf("Node had non-integer label: %s\n", node.Label))
-		}
-		sensorNodes[label] = node
+	// Reuse the same input buffer for every pixel, the bias never changes
+	inputOffset := 0
+	if n.DNA.UsesBias {
+		inputOffset = 1
 	}
-
-	outputNodes := make([]*neat.Node, len(n.DNA.OutputNodes))
-	for _, nodeI := range n.DNA.OutputNodes {
-		node := n.Nodes[nodeI]
-		label, err := strconv.Atoi(node.Label)
-		if err != nil {
-			panic(fmt.Sprintf("Node had non-integer label: %s\n", node.Label))
-		}
-		outputNodes[label-len(sensorNodes)] = node
+	inputs := make([]float64, inputOffset+len(dimensions)+len(otherInputs))
+	if n.DNA.UsesBias {
+		inputs[0] = 1
 	}
+	dimensionalInputs := inputs[inputOffset : inputOffset+len(dimensions)]
 
 	indices := make([]int, len(dimensions))
 	for i := 0; i < outSize; i += 1 {
-		// Current values for each index
-		dimensionalInputs := make([]float64, len(dimensions))
-
 		// Can't have an arbitrarily-nested for loop, so unroll indices here
 		for j := 0; j < len(dimensions); j += 1 {
 			if j < len(dimensions)-1 {
@@ -169,32 +178,17 @@ func ActivateNetwork(n *neat.Network, dimensions []int, otherInputs []NetworkInp
 			dimensionalInputs[j] = 2*float64(indices[j])/float64(dimensions[j]) - 1.0
 		}
 
-		computedInputs := make([]float64, 0)
-		if otherInputs != nil {
-			computedInputs = make([]float64, len(otherInputs))
-
-			for j, fn := range otherInputs {
-				computedInputs[j] = fn(dimensionalInputs...)
-			}
-		}
-
-		inputs := append(dimensionalInputs, computedInputs...)
-		if n.DNA.UsesBias {
-			inputs = append([]float64{1}, inputs...)
+		for j, fn := range otherInputs {
+			inputs[inputOffset+len(dimensions)+j] = fn(dimensionalInputs...)
 		}
 
-		log.Book(fmt.Sprintf("%d: inputs:%v, #sensors: %d, #outputs: %d\n", i, inputs, len(sensorNodes), len(outputNodes)), log.DEBUG, log.DEBUG_EXPERIMENT)
-		n.Activate(inputs, sensorNodes, outputNodes)
-
-		outMatrixEntry := make([]float64, len(outputNodes))
+		log.Book(fmt.Sprintf("%d: inputs:%v, #sensors: %d, #outputs: %d\n", i, inputs, len(n.DNA.SensorNodes), len(n.DNA.OutputNodes)), log.DEBUG, log.DEBUG_EXPERIMENT)
+		outputs := n.ActivateFeedForward(inputs)
 
-		for j, outputNode := range outputNodes {
-			if math.IsNaN(outputNode.Value()) {
-				log.Book(n.String(), log.DEBUG)
-				panic("NaN network")
-			}
-
-			outMatrixEntry[j] = outputNode.Value()
+		outMatrixEntry := make([]float64, len(outputs))
+		for j, output := range outputs {
+			nan = nan || math.IsNaN(output)
+			outMatrixEntry[j] = output
 		}
 
 		outMatrix[i] = outMatrixEntry
@@ -202,7 +196,7 @@ func ActivateNetwork(n *neat.Network, dimensions []int, otherInputs []NetworkInp
 		indices[0] += 1
 	}
 
-	return outMatrix
+	return outMatrix, nan
 }
 
 func NoiseFitness(o ma.Organism) float64 {
@@ -283,13 +277,8 @@ func DrawNoiseImage(networkOutput [][]float64, fName string) error {
 
 func TestActivation() {
 	g := neat.NewGenome(2, 3, true, -1.0, 1.0)
-	g.ActivationFunctions = map[uint]string{
-		0: "Identity",
-		1: "Identity",
-		2: "Identity",
-		3: "Identity",
-		4: "Identity",
-		5: "Identity",
+	for _, node := range g.Nodes {
+		node.Activation = neat.IdentityStr
 	}
 	g.Connections = []*neat.EdgeGene{
 		neat.NewEdgeGene(1, 3, 1, neat.NoMutation),
@@ -323,10 +312,12 @@ func NoiseEvolution() {
 	cppnConfig.MinWeight = -1
 	cppnConfig.MaxWeight = 1
 	cppnConfig.MutationRatios = map[ma.MutationType]float64{
-		neat.MutationAddConnection:   0.2,
-		neat.MutationAddNode:         0.1,
-		neat.MutationMutateWeights:   0.6,
-		neat.MutationChangeAFunction: 0.1,
+		neat.MutationAddConnection:     0.2,
+		neat.MutationAddNode:           0.1,
+		neat.MutationMutateWeights:     0.53,
+		neat.MutationChangeAFunction:   0.1,
+		neat.MutationMutateBias:        0.05,
+		neat.MutationChangeAggregation: 0.02,
 	}
 
 	Evolution(NoiseFitness, DrawNoiseNetwork, popConfig, cppnConfig)
@@ -497,11 +488,48 @@ func MandelbrotEvolution() {
 	cppnConfig.MinWeight = -16
 	cppnConfig.MaxWeight = 16
 	cppnConfig.MutationRatios = map[ma.MutationType]float64{
-		neat.MutationAddConnection:   0.2,
-		neat.MutationAddNode:         0.1,
-		neat.MutationMutateWeights:   0.6,
-		neat.MutationChangeAFunction: 0.1,
+		neat.MutationAddConnection:     0.2,
+		neat.MutationAddNode:           0.1,
+		neat.MutationMutateWeights:     0.53,
+		neat.MutationChangeAFunction:   0.1,
+		neat.MutationMutateBias:        0.05,
+		neat.MutationChangeAggregation: 0.02,
 	}
 
 	Evolution(MandelbrotFitness, DrawMandelbrotNetwork, popConfig, cppnConfig)
 }
+
+// Evolve a CPPN that paints the image in fName (PNG or BMP). Starts at a quarter of the size and works up to full
+// size, the hook moves ImageFitness to the next resolution
+func TargetImageEvolution(fName string) error {
+	target, err := LoadBitmap(fName, false)
+	if err != nil {
+		return err
+	}
+
+	f := NewImageFitness(target)
+	f.Metrics = map[ImageMetric]float64{MSEMetric: 1, SSIMMetric: 0.5}
+	f.Levels = 2
+	f.LevelEpochs = 50
+
+	popConfig := config.PopulationDefault()
+	popConfig.Size = 128
+	popConfig.DistanceThreshold = 4
+	popConfig.DistanceThresholdEpsilon = 0.1
+	popConfig.TargetMinSpecies = 14
+	popConfig.TargetMaxSpecies = 26
+	popConfig.DropoffAge = 15
+
+	popConfig.RecombinationPercent = 0.75
+	popConfig.LocalSearchGenerations = 8
+	popConfig.SharingFunctionConstants = []float64{1, 2, 0.4, 1}
+
+	cppnConfig := config.CPPNDefault()
+	cppnConfig.SensorNodes = 2
+	cppnConfig.OutputNodes = target.Channels
+	cppnConfig.MinWeight = -8
+	cppnConfig.MaxWeight = 8
+
+	EvolutionWithHook(f.Fitness, f.Draw, popConfig, cppnConfig, f.SetEpoch)
+	return nil
+}
@@ -0,0 +1,261 @@
+package cppn
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/TylerLeite/neuro-q/config"
+	"github.com/TylerLeite/neuro-q/log"
+	"github.com/TylerLeite/neuro-q/ma"
+	"github.com/TylerLeite/neuro-q/neat"
+)
+
+// HyperNEAT (Stanley et al. 2009): instead of evolving a network directly, evolve a CPPN that paints the weights of
+// a network whose nodes sit at fixed coordinates (the substrate). The CPPN gets the coordinates of both ends of a
+// possible connection and outputs its weight
+
+type SubstrateError struct {
+	Msg string
+}
+
+func (e *SubstrateError) Error() string {
+	return e.Msg
+}
+
+// Coordinates of a substrate node, 2D or 3D
+type Point []float64
+
+type Substrate struct {
+	Dimensions int
+
+	Inputs  []Point
+	Hidden  [][]Point // Layers of hidden nodes. Each layer is connected to the next, inputs to the first, the last to outputs
+	Outputs []Point
+
+	// CPPN outputs with a smaller magnitude than this don't make a connection, bigger ones are scaled from
+	// (WeightThreshold, 1] to (0, MaxWeight]
+	WeightThreshold float64
+	MaxWeight       float64
+
+	// Give the CPPN the distance between the two points as an extra input
+	Distance bool
+
+	HiddenActivation string
+	OutputActivation string
+}
+
+func NewSubstrate(dimensions int, inputs []Point, hidden [][]Point, outputs []Point) *Substrate {
+	return &Substrate{
+		Dimensions: dimensions,
+
+		Inputs:  inputs,
+		Hidden:  hidden,
+		Outputs: outputs,
+
+		WeightThreshold: 0.2,
+		MaxWeight:       3,
+
+		HiddenActivation: neat.BipolarSigmoidStr,
+		OutputActivation: neat.NEATSigmoidStr,
+	}
+}
+
+// n points evenly spread over [-1, 1] on the x axis, at the given y (and z, for 3D substrates)
+func Line(n int, rest ...float64) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		x := 0.0
+		if n > 1 {
+			x = 2*float64(i)/float64(n-1) - 1
+		}
+		points[i] = append(Point{x}, rest...)
+	}
+
+	return points
+}
+
+// width*height points evenly spread over [-1, 1]x[-1, 1], row by row, at the given z (if any)
+func Grid(width, height int, rest ...float64) []Point {
+	points := make([]Point, 0, width*height)
+	for _, row := range Line(height) {
+		for _, column := range Line(width) {
+			points = append(points, append(Point{column[0], row[0]}, rest...))
+		}
+	}
+
+	return points
+}
+
+// Sensor nodes a CPPN for this substrate needs (not counting the bias)
+func (s *Substrate) CPPNInputCount() int {
+	if s.Distance {
+		return 2*s.Dimensions + 1
+	}
+	return 2 * s.Dimensions
+}
+
+// CPPN config for this substrate. One output is the connection weight, with two outputs the second one is the bias
+// of each node, queried with the source at the origin
+func (s *Substrate) CPPNConfig(outputs int) *config.NEAT {
+	cfg := config.CPPNDefault()
+	cfg.SensorNodes = s.CPPNInputCount()
+	cfg.OutputNodes = outputs
+
+	return cfg
+}
+
+func (s *Substrate) layers() [][]Point {
+	layers := [][]Point{s.Inputs}
+	layers = append(layers, s.Hidden...)
+	layers = append(layers, s.Outputs)
+
+	return layers
+}
+
+func (s *Substrate) Validate() error {
+	if s.Dimensions < 1 {
+		return &SubstrateError{Msg: "substrate needs at least one dimension"}
+	}
+
+	if len(s.Inputs) == 0 || len(s.Outputs) == 0 {
+		return &SubstrateError{Msg: "substrate needs input and output nodes"}
+	}
+
+	for _, layer := range s.layers() {
+		for _, p := range layer {
+			if len(p) != s.Dimensions {
+				return &SubstrateError{Msg: fmt.Sprintf("point %v should have %d coordinates", p, s.Dimensions)}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Scale a CPPN output into a weight, false if it's under the threshold
+func (s *Substrate) scale(w float64) (float64, bool) {
+	if math.IsNaN(w) || math.Abs(w) <= s.WeightThreshold || s.WeightThreshold >= 1 {
+		return 0, false
+	}
+
+	magnitude := (math.Min(math.Abs(w), 1) - s.WeightThreshold) / (1 - s.WeightThreshold)
+	return math.Copysign(magnitude*s.MaxWeight, w), true
+}
+
+func (s *Substrate) query(cppn *neat.Network, inputs []float64, from, to Point) ([]float64, error) {
+	copy(inputs, from)
+	copy(inputs[s.Dimensions:], to)
+
+	if s.Distance {
+		d := 0.0
+		for i := range from {
+			d += (to[i] - from[i]) * (to[i] - from[i])
+		}
+		inputs[2*s.Dimensions] = math.Sqrt(d)
+	}
+
+	return cppn.Predict(inputs)
+}
+
+// Build the network the CPPN describes. Node ids are the inputs, then outputs, then hidden nodes layer by layer, all
+// in the order they appear in the substrate. The phenotype has no bias node, biases (if the CPPN has a second
+// output) are stored on the nodes
+func (s *Substrate) Phenotype(cppn *neat.Network) (*neat.Network, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	cppn.Compile()
+	if len(cppn.DNA.OutputNodes) == 0 {
+		return nil, &SubstrateError{Msg: "CPPN has no outputs"}
+	}
+
+	genome := &neat.Genome{
+		Nodes:       make([]*neat.NodeGene, 0),
+		Connections: make([]*neat.EdgeGene, 0),
+
+		MinWeight: -s.MaxWeight,
+		MaxWeight: s.MaxWeight,
+	}
+
+	// Ids for each layer
+	layers := s.layers()
+	ids := make([][]uint, len(layers))
+	nextId := uint(0)
+	addLayer := func(i int, typ neat.NodeType, activation string) {
+		ids[i] = make([]uint, len(layers[i]))
+		for j := range layers[i] {
+			ids[i][j] = nextId
+			genome.AddNodeGene(neat.NewNodeGene(nextId, typ, activation))
+			nextId += 1
+		}
+	}
+
+	addLayer(0, neat.SensorNode, neat.IdentityStr)
+	addLayer(len(layers)-1, neat.OutputNode, s.OutputActivation)
+	for i := 1; i < len(layers)-1; i += 1 {
+		addLayer(i, neat.HiddenNode, s.HiddenActivation)
+	}
+
+	inputs := make([]float64, s.CPPNInputCount())
+	origin := make(Point, s.Dimensions)
+
+	for i := 1; i < len(layers); i += 1 {
+		for j, to := range layers[i] {
+			for k, from := range layers[i-1] {
+				outputs, err := s.query(cppn, inputs, from, to)
+				if err != nil {
+					return nil, err
+				}
+
+				if weight, ok := s.scale(outputs[0]); ok {
+					// Phenotypes are built during fitness evaluation, so keep them out of the innovation history
+					genome.Connections = append(genome.Connections, &neat.EdgeGene{
+						InNode:  ids[i-1][k],
+						OutNode: ids[i][j],
+						Enabled: true,
+						Weight:  weight,
+
+						Origin:           neat.NoMutation,
+						InnovationNumber: uint(len(genome.Connections)),
+					})
+				}
+			}
+
+			if len(cppn.DNA.OutputNodes) > 1 {
+				outputs, err := s.query(cppn, inputs, origin, to)
+				if err != nil {
+					return nil, err
+				}
+
+				if bias, ok := s.scale(outputs[1]); ok {
+					genome.Node(ids[i][j]).Bias = bias
+				}
+			}
+		}
+	}
+
+	log.Book(fmt.Sprintf("Substrate phenotype with %d connections\n", len(genome.Connections)), log.DEBUG, log.DEBUG_EXPERIMENT)
+
+	genome.PopulateNodeSlices()
+	return neat.NewNetwork(genome, nil), nil
+}
+
+// Anything that turns a CPPN into a network: Substrate, or EvolvableSubstrate which also places the hidden nodes
+type SubstrateBuilder interface {
+	Phenotype(cppn *neat.Network) (*neat.Network, error)
+}
+
+// Fitness of a CPPN is the task fitness of the network it builds on the substrate. CPPNs that can't build one get
+// -Inf
+func HyperNEATFitness(s SubstrateBuilder, taskFitness ma.FitnessFunction) ma.FitnessFunction {
+	return func(o ma.Organism) float64 {
+		phenotype, err := s.Phenotype(o.(*neat.Network))
+		if err != nil {
+			log.Book(fmt.Sprintf("Couldn't build a phenotype: %s\n", err), log.DEBUG, log.DEBUG_EXPERIMENT)
+			return math.Inf(-1)
+		}
+
+		return taskFitness(phenotype)
+	}
+}
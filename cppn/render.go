@@ -0,0 +1,253 @@
+package cppn
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/TylerLeite/neuro-q/neat"
+)
+
+// Rendering CPPNs at any size. Coordinates work like ActivateNetwork's: pixel x of w is sampled at 2x/w - 1, the
+// corner of the pixel rather than its middle. So a square render with the default viewport and no supersampling at
+// the size the CPPN was evolved at is exactly what its fitness function saw, and bigger ones fill in between
+
+type ColorMode uint8
+
+const (
+	GrayscaleColor ColorMode = iota // First output is the brightness
+	RGBColor                        // First three outputs are red, green and blue. Missing ones repeat the first
+	HSVColor                        // First three outputs are hue (wraps around), saturation and value
+	PaletteColor                    // First output picks a color along Palette, blending between neighbors
+)
+
+type RenderOptions struct {
+	Width, Height int
+
+	// Point at the middle of the image, and how far in to zoom. At zoom 1 the shorter side spans [-1, 1] and the
+	// longer one keeps the aspect ratio, so pixels are square
+	CenterX, CenterY float64
+	Zoom             float64
+
+	Supersample int // Samples per pixel along each axis, averaged
+
+	Color   ColorMode
+	Palette []color.Color
+	Bipolar bool // Outputs are in [-1, 1] instead of [0, 1]
+
+	OtherInputs []NetworkInputFunction
+
+	TileSize int // Side of the square tiles the image is split into for the workers
+	Workers  int // 0 for one per CPU
+}
+
+func DefaultRenderOptions(width, height int) *RenderOptions {
+	return &RenderOptions{
+		Width:  width,
+		Height: height,
+
+		Zoom:        1,
+		Supersample: 1,
+
+		Color: RGBColor,
+
+		TileSize: 64,
+	}
+}
+
+// Network coordinates of pixel (x, y) plus a subpixel offset in [-0.5, 0.5). No offset is ActivateNetwork's point
+func (o *RenderOptions) coordinates(x, y, dx, dy float64) (float64, float64) {
+	side := math.Min(float64(o.Width), float64(o.Height))
+	zoom := o.Zoom
+	if zoom == 0 {
+		zoom = 1
+	}
+
+	scale := 2 / side / zoom
+	return o.CenterX + (x+dx-float64(o.Width)/2)*scale, o.CenterY + (y+dy-float64(o.Height)/2)*scale
+}
+
+func clamp01(v float64) float64 {
+	return math.Min(math.Max(v, 0), 1)
+}
+
+func hsvToRGB(h, s, v float64) (float64, float64, float64) {
+	h = 6 * (h - math.Floor(h))
+	i := math.Floor(h)
+	f := h - i
+
+	p := v * (1 - s)
+	q := v * (1 - s*f)
+	t := v * (1 - s*(1-f))
+
+	switch int(i) % 6 {
+	case 0:
+		return v, t, p
+	case 1:
+		return q, v, p
+	case 2:
+		return p, v, t
+	case 3:
+		return p, q, v
+	case 4:
+		return t, p, v
+	default:
+		return v, p, q
+	}
+}
+
+// Linear RGB in [0, 1] for one network output
+func (o *RenderOptions) colorOf(outputs []float64) (float64, float64, float64) {
+	channel := func(i int) float64 {
+		v := outputs[0]
+		if i < len(outputs) {
+			v = outputs[i]
+		}
+		if math.IsNaN(v) {
+			v = 0
+		}
+		if o.Bipolar {
+			v = (v + 1) / 2
+		}
+		return v
+	}
+
+	switch o.Color {
+	case GrayscaleColor:
+		v := clamp01(channel(0))
+		return v, v, v
+	case HSVColor:
+		return hsvToRGB(channel(0), clamp01(channel(1)), clamp01(channel(2)))
+	case PaletteColor:
+		if len(o.Palette) == 0 {
+			v := clamp01(channel(0))
+			return v, v, v
+		}
+
+		position := clamp01(channel(0)) * float64(len(o.Palette)-1)
+		i := int(math.Floor(position))
+		j := int(math.Min(float64(i+1), float64(len(o.Palette)-1)))
+		t := position - float64(i)
+
+		r1, g1, b1, _ := o.Palette[i].RGBA()
+		r2, g2, b2, _ := o.Palette[j].RGBA()
+		blend := func(a, b uint32) float64 {
+			return ((1-t)*float64(a) + t*float64(b)) / 0xffff
+		}
+		return blend(r1, r2), blend(g1, g2), blend(b1, b2)
+	default:
+		return clamp01(channel(0)), clamp01(channel(1)), clamp01(channel(2))
+	}
+}
+
+type tile struct {
+	x0, y0, x1, y1 int
+}
+
+// Render the network into an image, tiles in parallel. Each worker gets its own copy of the network since activating
+// one reuses its buffers
+func Render(n *neat.Network, o *RenderOptions) *image.RGBA {
+	n.Compile()
+
+	img := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{o.Width, o.Height}})
+
+	tileSize := o.TileSize
+	if tileSize < 1 {
+		tileSize = 64
+	}
+
+	tiles := make(chan tile)
+	go func() {
+		for y := 0; y < o.Height; y += tileSize {
+			for x := 0; x < o.Width; x += tileSize {
+				tiles <- tile{x, y, int(math.Min(float64(x+tileSize), float64(o.Width))), int(math.Min(float64(y+tileSize), float64(o.Height)))}
+			}
+		}
+		close(tiles)
+	}()
+
+	workers := o.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w += 1 {
+		go func() {
+			network := n.Copy().(*neat.Network)
+			renderTiles(network, o, img, tiles)
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+	return img
+}
+
+func renderTiles(n *neat.Network, o *RenderOptions, img *image.RGBA, tiles <-chan tile) {
+	samples := o.Supersample
+	if samples < 1 {
+		samples = 1
+	}
+
+	// Same input layout as ActivateNetwork: bias, coordinates, then the other inputs
+	inputOffset := 0
+	if n.DNA.UsesBias {
+		inputOffset = 1
+	}
+	inputs := make([]float64, inputOffset+2+len(o.OtherInputs))
+	if n.DNA.UsesBias {
+		inputs[0] = 1
+	}
+	coordinates := inputs[inputOffset : inputOffset+2]
+
+	for t := range tiles {
+		for y := t.y0; y < t.y1; y += 1 {
+			for x := t.x0; x < t.x1; x += 1 {
+				var r, g, b float64
+
+				for sy := 0; sy < samples; sy += 1 {
+					for sx := 0; sx < samples; sx += 1 {
+						// Samples are spread evenly around the point ActivateNetwork would use for this pixel, a single
+						// sample lands right on it
+						dx := (float64(sx)+0.5)/float64(samples) - 0.5
+						dy := (float64(sy)+0.5)/float64(samples) - 0.5
+						coordinates[0], coordinates[1] = o.coordinates(float64(x), float64(y), dx, dy)
+
+						for j, fn := range o.OtherInputs {
+							inputs[inputOffset+2+j] = fn(coordinates...)
+						}
+
+						sr, sg, sb := o.colorOf(n.ActivateFeedForward(inputs))
+						r += sr
+						g += sg
+						b += sb
+					}
+				}
+
+				count := float64(samples * samples)
+				img.SetRGBA(x, y, color.RGBA{
+					uint8(math.Round(255 * r / count)),
+					uint8(math.Round(255 * g / count)),
+					uint8(math.Round(255 * b / count)),
+					0xff,
+				})
+			}
+		}
+	}
+}
+
+func RenderPNG(n *neat.Network, o *RenderOptions, fName string) error {
+	f, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, Render(n, o))
+}
@@ -0,0 +1,396 @@
+package cppn
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	_ "golang.org/x/image/bmp" // Register BMP with image.Decode, PNG comes from image/png
+
+	"github.com/TylerLeite/neuro-q/ma"
+	"github.com/TylerLeite/neuro-q/neat"
+)
+
+// Fitness for CPPNs that should paint a given picture. The CPPN is rendered with ActivateNetwork at the target's
+// resolution and compared with a weighted mix of metrics
+
+// Image with channel values in [0, 1]. Pixels are in the same order as ActivateNetwork's output, x + Width*y
+type Bitmap struct {
+	Width, Height int
+	Channels      int // 1 for grayscale, 3 for RGB
+
+	Pixels [][]float64
+}
+
+func NewBitmap(width, height, channels int) *Bitmap {
+	b := &Bitmap{
+		Width:    width,
+		Height:   height,
+		Channels: channels,
+		Pixels:   make([][]float64, width*height),
+	}
+
+	for i := range b.Pixels {
+		b.Pixels[i] = make([]float64, channels)
+	}
+
+	return b
+}
+
+func BitmapFromImage(img image.Image, grayscale bool) *Bitmap {
+	bounds := img.Bounds()
+
+	channels := 3
+	if grayscale {
+		channels = 1
+	}
+	b := NewBitmap(bounds.Dx(), bounds.Dy(), channels)
+
+	for y := 0; y < b.Height; y += 1 {
+		for x := 0; x < b.Width; x += 1 {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			pix := b.Pixels[x+b.Width*y]
+
+			if grayscale {
+				pix[0] = float64(color.Gray16Model.Convert(c).(color.Gray16).Y) / 0xffff
+				continue
+			}
+
+			r, g, bl, _ := c.RGBA()
+			pix[0] = float64(r) / 0xffff
+			pix[1] = float64(g) / 0xffff
+			pix[2] = float64(bl) / 0xffff
+		}
+	}
+
+	return b
+}
+
+// Load a PNG or BMP
+func LoadBitmap(fName string, grayscale bool) (*Bitmap, error) {
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return BitmapFromImage(img, grayscale), nil
+}
+
+func (b *Bitmap) Image() image.Image {
+	img := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{b.Width, b.Height}})
+
+	toByte := func(v float64) uint8 {
+		return uint8(math.Round(255 * math.Min(math.Max(v, 0), 1)))
+	}
+
+	for y := 0; y < b.Height; y += 1 {
+		for x := 0; x < b.Width; x += 1 {
+			pix := b.Pixels[x+b.Width*y]
+			if b.Channels < 3 {
+				v := toByte(pix[0])
+				img.Set(x, y, color.RGBA{v, v, v, 0xff})
+			} else {
+				img.Set(x, y, color.RGBA{toByte(pix[0]), toByte(pix[1]), toByte(pix[2]), 0xff})
+			}
+		}
+	}
+
+	return img
+}
+
+func (b *Bitmap) SavePNG(fName string) error {
+	f, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, b.Image())
+}
+
+// Scale down by averaging the block of pixels that lands on each new pixel
+func (b *Bitmap) Resize(width, height int) *Bitmap {
+	out := NewBitmap(width, height, b.Channels)
+	counts := make([]float64, width*height)
+
+	for y := 0; y < b.Height; y += 1 {
+		for x := 0; x < b.Width; x += 1 {
+			i := x*width/b.Width + width*(y*height/b.Height)
+			for c, v := range b.Pixels[x+b.Width*y] {
+				out.Pixels[i][c] += v
+			}
+			counts[i] += 1
+		}
+	}
+
+	for i, pix := range out.Pixels {
+		if counts[i] == 0 {
+			// Scaling up, take the nearest pixel
+			x, y := i%width, i/width
+			copy(pix, b.Pixels[x*b.Width/width+b.Width*(y*b.Height/height)])
+			continue
+		}
+
+		for c := range pix {
+			pix[c] /= counts[i]
+		}
+	}
+
+	return out
+}
+
+func (b *Bitmap) at(x, y, c int) float64 {
+	// Clamp to the edges
+	x = int(math.Min(math.Max(float64(x), 0), float64(b.Width-1)))
+	y = int(math.Min(math.Max(float64(y), 0), float64(b.Height-1)))
+
+	return b.Pixels[x+b.Width*y][c]
+}
+
+type ImageMetric uint8
+
+const (
+	MSEMetric  ImageMetric = iota // Mean squared error of the pixels
+	SSIMMetric                    // Structural similarity (Wang et al. 2004) over 8x8 blocks, as a loss (1 - SSIM) / 2
+	EdgeMetric                    // Mean squared error of the Sobel gradient magnitudes, cares about where edges are
+)
+
+// Per-channel losses for a metric
+func (m ImageMetric) loss(got, target *Bitmap) []float64 {
+	switch m {
+	case SSIMMetric:
+		return ssimLoss(got, target)
+	case EdgeMetric:
+		return mse(sobel(got), sobel(target))
+	default:
+		return mse(got, target)
+	}
+}
+
+func mse(got, target *Bitmap) []float64 {
+	losses := make([]float64, target.Channels)
+	for i, pix := range target.Pixels {
+		for c, v := range pix {
+			diff := got.Pixels[i][c] - v
+			losses[c] += diff * diff
+		}
+	}
+
+	for c := range losses {
+		losses[c] /= float64(len(target.Pixels))
+	}
+
+	return losses
+}
+
+func sobel(b *Bitmap) *Bitmap {
+	out := NewBitmap(b.Width, b.Height, b.Channels)
+
+	for y := 0; y < b.Height; y += 1 {
+		for x := 0; x < b.Width; x += 1 {
+			for c := 0; c < b.Channels; c += 1 {
+				gx := b.at(x+1, y-1, c) + 2*b.at(x+1, y, c) + b.at(x+1, y+1, c) -
+					b.at(x-1, y-1, c) - 2*b.at(x-1, y, c) - b.at(x-1, y+1, c)
+				gy := b.at(x-1, y+1, c) + 2*b.at(x, y+1, c) + b.at(x+1, y+1, c) -
+					b.at(x-1, y-1, c) - 2*b.at(x, y-1, c) - b.at(x+1, y-1, c)
+
+				// Biggest possible magnitude is 4√2
+				out.Pixels[x+b.Width*y][c] = math.Sqrt(gx*gx+gy*gy) / (4 * math.Sqrt2)
+			}
+		}
+	}
+
+	return out
+}
+
+const ssimBlock = 8
+
+func ssimLoss(got, target *Bitmap) []float64 {
+	const (
+		c1 = 0.01 * 0.01
+		c2 = 0.03 * 0.03
+	)
+
+	losses := make([]float64, target.Channels)
+	for c := 0; c < target.Channels; c += 1 {
+		total, blocks := 0.0, 0.0
+
+		for by := 0; by < target.Height; by += ssimBlock {
+			for bx := 0; bx < target.Width; bx += ssimBlock {
+				var meanA, meanB, varA, varB, cov, n float64
+				for y := by; y < by+ssimBlock && y < target.Height; y += 1 {
+					for x := bx; x < bx+ssimBlock && x < target.Width; x += 1 {
+						meanA += got.Pixels[x+target.Width*y][c]
+						meanB += target.Pixels[x+target.Width*y][c]
+						n += 1
+					}
+				}
+				meanA /= n
+				meanB /= n
+
+				for y := by; y < by+ssimBlock && y < target.Height; y += 1 {
+					for x := bx; x < bx+ssimBlock && x < target.Width; x += 1 {
+						a := got.Pixels[x+target.Width*y][c] - meanA
+						b := target.Pixels[x+target.Width*y][c] - meanB
+						varA += a * a
+						varB += b * b
+						cov += a * b
+					}
+				}
+				varA /= n
+				varB /= n
+				cov /= n
+
+				total += (2*meanA*meanB + c1) * (2*cov + c2) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+				blocks += 1
+			}
+		}
+
+		losses[c] = (1 - total/blocks) / 2
+	}
+
+	return losses
+}
+
+type ImageFitness struct {
+	Target *Bitmap
+
+	// How much each metric counts, and each channel within a metric. Channel weights default to equal
+	Metrics        map[ImageMetric]float64
+	ChannelWeights []float64
+
+	// Network outputs are in [-1, 1] instead of [0, 1]
+	Bipolar bool
+
+	// Extra network inputs besides the pixel coordinates, passed to ActivateNetwork
+	OtherInputs []NetworkInputFunction
+
+	// Multi-resolution: start at 1/2^Levels of the target's size, and halve the downscaling every LevelEpochs epochs
+	// (see SetEpoch) until it's at full size. Cheap coarse renders are enough to get the rough shapes right
+	Levels      int
+	LevelEpochs int
+
+	level       int32
+	targets     []*Bitmap // Target at each level, 0 is full size
+	targetsOnce sync.Once
+}
+
+func NewImageFitness(target *Bitmap) *ImageFitness {
+	return &ImageFitness{
+		Target:  target,
+		Metrics: map[ImageMetric]float64{MSEMetric: 1},
+	}
+}
+
+func (f *ImageFitness) target(level int) *Bitmap {
+	// Fitness is called from several goroutines at once
+	f.targetsOnce.Do(func() {
+		f.targets = []*Bitmap{f.Target}
+		for l := 1; l <= f.Levels; l += 1 {
+			w := int(math.Max(1, float64(f.Target.Width>>l)))
+			h := int(math.Max(1, float64(f.Target.Height>>l)))
+			f.targets = append(f.targets, f.Target.Resize(w, h))
+		}
+	})
+
+	return f.targets[level]
+}
+
+// Pick the resolution for this epoch. Nothing calls it on its own: pass it as the hook to EvolutionWithHook (see
+// TargetImageEvolution), or call it before each epoch when running the population yourself. Until the first call
+// it's full size, and Levels can't change after it
+func (f *ImageFitness) SetEpoch(epoch int) {
+	level := f.Levels
+	if f.LevelEpochs > 0 {
+		level -= epoch / f.LevelEpochs
+	}
+	if level < 0 {
+		level = 0
+	}
+
+	atomic.StoreInt32(&f.level, int32(level))
+}
+
+// Render a network to a bitmap of the given size, with the target's number of channels. A network with fewer
+// outputs than channels repeats its first output, and NaN outputs are black
+func (f *ImageFitness) Render(n *neat.Network, width, height int) *Bitmap {
+	b, _ := f.render(n, width, height)
+	return b
+}
+
+// Render, and whether any of the outputs were NaN
+func (f *ImageFitness) render(n *neat.Network, width, height int) (*Bitmap, bool) {
+	n.Compile()
+
+	out, nan := activateGrid(n, []int{width, height}, f.OtherInputs)
+	b := NewBitmap(width, height, f.Target.Channels)
+
+	for i, pix := range b.Pixels {
+		for c := range pix {
+			v := out[i][0]
+			if c < len(out[i]) {
+				v = out[i][c]
+			}
+
+			if math.IsNaN(v) {
+				v = 0
+			} else if f.Bipolar {
+				v = (v + 1) / 2
+			}
+			pix[c] = math.Min(math.Max(v, 0), 1)
+		}
+	}
+
+	return b, nan
+}
+
+// Weighted loss of a render against a target of the same size
+func (f *ImageFitness) Loss(got, target *Bitmap) float64 {
+	channelWeights := f.ChannelWeights
+	if len(channelWeights) != target.Channels {
+		channelWeights = make([]float64, target.Channels)
+		for c := range channelWeights {
+			channelWeights[c] = 1
+		}
+	}
+
+	totalWeight := 0.0
+	for _, w := range channelWeights {
+		totalWeight += w
+	}
+
+	loss := 0.0
+	for metric, weight := range f.Metrics {
+		for c, channelLoss := range metric.loss(got, target) {
+			loss += weight * channelWeights[c] * channelLoss / totalWeight
+		}
+	}
+
+	return loss
+}
+
+// Higher is better, 1/(1 + loss) at the current resolution. Networks with NaN outputs get 0
+func (f *ImageFitness) Fitness(o ma.Organism) float64 {
+	target := f.target(int(atomic.LoadInt32(&f.level)))
+	got, nan := f.render(o.(*neat.Network), target.Width, target.Height)
+	if nan {
+		return 0
+	}
+
+	return 1 / (1 + f.Loss(got, target))
+}
+
+// DrawFunction for Evolution, renders at full size
+func (f *ImageFitness) Draw(o ma.Organism, fName string) error {
+	return f.Render(o.(*neat.Network), f.Target.Width, f.Target.Height).SavePNG(fName)
+}
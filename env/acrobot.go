@@ -0,0 +1,110 @@
+package env
+
+import (
+	"math"
+)
+
+// Acrobot (Sutton 1996): a two-link pendulum hanging down, with a motor only at the joint between the links. The
+// goal is to swing the tip above a line one link length over the shoulder. Reward is -1 per step until it gets there.
+// Dynamics are from Sutton & Barto's book, same as Gym's
+
+type Acrobot struct {
+	episode
+
+	// θ1, θ2, θ1', θ2'
+	State [4]float64
+}
+
+const (
+	acrobotDt        = 0.2
+	acrobotLength    = 1.0 // Both links
+	acrobotMass      = 1.0
+	acrobotCOM       = 0.5 // Distance to each link's center of mass
+	acrobotInertia   = 1.0
+	acrobotMaxSpeed1 = 4 * math.Pi
+	acrobotMaxSpeed2 = 9 * math.Pi
+)
+
+// Three actions: torque -1, 0 or 1 on the joint. See discreteAction for how outputs map to them
+func NewAcrobot() *Acrobot {
+	return &Acrobot{
+		episode: newEpisode(500),
+	}
+}
+
+func (a *Acrobot) ObservationSize() int {
+	return 6
+}
+
+func (a *Acrobot) ActionSize() int {
+	return 3
+}
+
+func (a *Acrobot) Reset() []float64 {
+	a.steps = 0
+
+	for i := range a.State {
+		a.State[i] = a.uniform(-0.1, 0.1)
+	}
+
+	return a.observe()
+}
+
+// Angles as cos and sin so they don't wrap around, velocities scaled to [-1, 1]
+func (a *Acrobot) observe() []float64 {
+	s := a.State
+	return []float64{
+		math.Cos(s[0]), math.Sin(s[0]),
+		math.Cos(s[1]), math.Sin(s[1]),
+		s[2] / acrobotMaxSpeed1, s[3] / acrobotMaxSpeed2,
+	}
+}
+
+func acrobotDerivatives(torque float64) func(s []float64) []float64 {
+	m, l, lc, inertia := acrobotMass, acrobotLength, acrobotCOM, acrobotInertia
+	g := gravity
+
+	return func(s []float64) []float64 {
+		theta1, theta2, dtheta1, dtheta2 := s[0], s[1], s[2], s[3]
+
+		d1 := m*lc*lc + m*(l*l+lc*lc+2*l*lc*math.Cos(theta2)) + 2*inertia
+		d2 := m*(lc*lc+l*lc*math.Cos(theta2)) + inertia
+		phi2 := m * lc * g * math.Cos(theta1+theta2-math.Pi/2)
+		phi1 := -m*l*lc*dtheta2*dtheta2*math.Sin(theta2) -
+			2*m*l*lc*dtheta2*dtheta1*math.Sin(theta2) +
+			(m*lc+m*l)*g*math.Cos(theta1-math.Pi/2) + phi2
+
+		ddtheta2 := (torque + d2/d1*phi1 - m*l*lc*dtheta1*dtheta1*math.Sin(theta2) - phi2) /
+			(m*lc*lc + inertia - d2*d2/d1)
+		ddtheta1 := -(d2*ddtheta2 + phi1) / d1
+
+		return []float64{dtheta1, dtheta2, ddtheta1, ddtheta2}
+	}
+}
+
+// Wrap an angle into [-π, π)
+func wrapAngle(x float64) float64 {
+	x = math.Mod(x+math.Pi, 2*math.Pi)
+	if x < 0 {
+		x += 2 * math.Pi
+	}
+	return x - math.Pi
+}
+
+func (a *Acrobot) Step(action []float64) ([]float64, float64, bool) {
+	torque := float64(discreteAction(action, 3) - 1)
+
+	next := rk4(a.State[:], acrobotDt, acrobotDerivatives(torque))
+	a.State[0] = wrapAngle(next[0])
+	a.State[1] = wrapAngle(next[1])
+	a.State[2] = math.Min(math.Max(next[2], -acrobotMaxSpeed1), acrobotMaxSpeed1)
+	a.State[3] = math.Min(math.Max(next[3], -acrobotMaxSpeed2), acrobotMaxSpeed2)
+
+	// Height of the tip above the shoulder, in link lengths
+	height := -math.Cos(a.State[0]) - math.Cos(a.State[0]+a.State[1])
+	if height > 1 {
+		return a.observe(), 0, true
+	}
+
+	return a.observe(), -1, a.tick()
+}
@@ -0,0 +1,214 @@
+package env
+
+import (
+	"math"
+)
+
+// Pole balancing: keep poles hinged on a cart upright by pushing the cart left and right, without running off the
+// track. Reward is 1 for every step the poles stay up
+
+const (
+	gravity    = 9.8
+	trackLimit = 2.4
+)
+
+// Single pole (Barto, Sutton & Anderson 1983). One output, bang-bang: above 0.5 pushes right, otherwise left
+type CartPole struct {
+	episode
+
+	Velocities bool // Observe the cart and pole velocities. Without them the controller needs memory
+
+	X, XDot         float64
+	Theta, ThetaDot float64
+}
+
+const (
+	cartMass       = 1.0
+	poleMass       = 0.1
+	poleHalfLength = 0.5
+	cartForce      = 10.0
+	cartTau        = 0.02
+	cartPoleFail   = 12 * math.Pi / 180
+)
+
+// Episodes end after 1000 steps (20 simulated seconds), set MaxSteps for longer ones
+func NewCartPole(velocities bool) *CartPole {
+	return &CartPole{
+		episode:    newEpisode(1000),
+		Velocities: velocities,
+	}
+}
+
+func (c *CartPole) ObservationSize() int {
+	if c.Velocities {
+		return 4
+	}
+	return 2
+}
+
+func (c *CartPole) ActionSize() int {
+	return 1
+}
+
+func (c *CartPole) Reset() []float64 {
+	c.steps = 0
+
+	c.X = c.uniform(-0.05, 0.05)
+	c.XDot = c.uniform(-0.05, 0.05)
+	c.Theta = c.uniform(-0.05, 0.05)
+	c.ThetaDot = c.uniform(-0.05, 0.05)
+
+	return c.observe()
+}
+
+// Scaled to roughly [-1, 1]
+func (c *CartPole) observe() []float64 {
+	if c.Velocities {
+		return []float64{c.X / trackLimit, c.XDot / 2, c.Theta / cartPoleFail, c.ThetaDot / 2}
+	}
+	return []float64{c.X / trackLimit, c.Theta / cartPoleFail}
+}
+
+func (c *CartPole) Step(action []float64) ([]float64, float64, bool) {
+	force := -cartForce
+	if action[0] > 0.5 {
+		force = cartForce
+	}
+
+	totalMass := cartMass + poleMass
+	poleMassLength := poleMass * poleHalfLength
+
+	cos, sin := math.Cos(c.Theta), math.Sin(c.Theta)
+	temp := (force + poleMassLength*c.ThetaDot*c.ThetaDot*sin) / totalMass
+	thetaAcc := (gravity*sin - cos*temp) / (poleHalfLength * (4.0/3.0 - poleMass*cos*cos/totalMass))
+	xAcc := temp - poleMassLength*thetaAcc*cos/totalMass
+
+	// Euler, like the original
+	c.X += cartTau * c.XDot
+	c.XDot += cartTau * xAcc
+	c.Theta += cartTau * c.ThetaDot
+	c.ThetaDot += cartTau * thetaAcc
+
+	failed := math.Abs(c.X) > trackLimit || math.Abs(c.Theta) > cartPoleFail
+	if failed {
+		return c.observe(), 0, true
+	}
+
+	return c.observe(), 1, c.tick()
+}
+
+// Two poles of different lengths on the same cart (Wieland 1991, the version used in the NEAT paper). One output, the
+// force is continuous: 0 pushes full left, 1 full right
+type DoublePole struct {
+	episode
+
+	Velocities bool
+
+	// x, x', θ1, θ1', θ2, θ2'
+	State [6]float64
+}
+
+const (
+	doublePoleTau      = 0.01
+	doublePoleSubsteps = 2
+	doublePoleForce    = 10.0
+	doublePoleFriction = 0.000002 // Between the poles and the cart
+	doublePoleFail     = 36 * math.Pi / 180
+	doublePoleStart    = 4.0156 * math.Pi / 180 // The long pole starts off at about 4°
+)
+
+var (
+	doublePoleMasses  = [2]float64{0.1, 0.01}
+	doublePoleLengths = [2]float64{0.5, 0.05} // Half lengths
+)
+
+// Episodes end after 1000 steps. The NEAT paper counted the task solved at 100000 steps, set MaxSteps to match
+func NewDoublePole(velocities bool) *DoublePole {
+	return &DoublePole{
+		episode:    newEpisode(1000),
+		Velocities: velocities,
+	}
+}
+
+func (d *DoublePole) ObservationSize() int {
+	if d.Velocities {
+		return 6
+	}
+	return 3
+}
+
+func (d *DoublePole) ActionSize() int {
+	return 1
+}
+
+// The classic task starts from the same state every time, seeding only adds a little noise to the long pole
+func (d *DoublePole) Reset() []float64 {
+	d.steps = 0
+
+	d.State = [6]float64{}
+	d.State[2] = doublePoleStart + d.uniform(-0.001, 0.001)
+
+	return d.observe()
+}
+
+func (d *DoublePole) observe() []float64 {
+	s := d.State
+	if d.Velocities {
+		return []float64{s[0] / trackLimit, s[1] / 2, s[2] / doublePoleFail, s[3] / 2, s[4] / doublePoleFail, s[5] / 2}
+	}
+	return []float64{s[0] / trackLimit, s[2] / doublePoleFail, s[4] / doublePoleFail}
+}
+
+func (d *DoublePole) derivatives(force float64) func(s []float64) []float64 {
+	return func(s []float64) []float64 {
+		ds := make([]float64, 6)
+
+		totalForce := force
+		totalMass := cartMass
+		for i := 0; i < 2; i += 1 {
+			theta, thetaDot := s[2+2*i], s[3+2*i]
+			mass, length := doublePoleMasses[i], doublePoleLengths[i]
+			cos, sin := math.Cos(theta), math.Sin(theta)
+
+			// Effective force and mass of each pole on the cart
+			friction := doublePoleFriction * thetaDot / (mass * length)
+			totalForce += mass*length*thetaDot*thetaDot*sin + 0.75*mass*cos*(friction-gravity*sin)
+			totalMass += mass * (1 - 0.75*cos*cos)
+		}
+
+		xAcc := totalForce / totalMass
+		ds[0] = s[1]
+		ds[1] = xAcc
+
+		for i := 0; i < 2; i += 1 {
+			theta, thetaDot := s[2+2*i], s[3+2*i]
+			mass, length := doublePoleMasses[i], doublePoleLengths[i]
+			cos, sin := math.Cos(theta), math.Sin(theta)
+
+			ds[2+2*i] = thetaDot
+			ds[3+2*i] = -0.75 * (xAcc*cos - gravity*sin + doublePoleFriction*thetaDot/(mass*length)) / length
+		}
+
+		return ds
+	}
+}
+
+func (d *DoublePole) Step(action []float64) ([]float64, float64, bool) {
+	a := math.Min(math.Max(action[0], 0), 1)
+	force := (a - 0.5) * 2 * doublePoleForce
+
+	f := d.derivatives(force)
+	for i := 0; i < doublePoleSubsteps; i += 1 {
+		next := rk4(d.State[:], doublePoleTau, f)
+		copy(d.State[:], next)
+	}
+
+	failed := math.Abs(d.State[0]) > trackLimit ||
+		math.Abs(d.State[2]) > doublePoleFail ||
+		math.Abs(d.State[4]) > doublePoleFail
+	if failed {
+		return d.observe(), 0, true
+	}
+
+	return d.observe(), 1, d.tick()
+}
@@ -0,0 +1,97 @@
+package env
+
+import (
+	"math"
+	"testing"
+
+	"github.com/TylerLeite/neuro-q/neat"
+)
+
+// Run a hand written controller, returns the total reward and how many steps it took
+func runPolicy(e Environment, policy func(observation []float64) []float64) (float64, int) {
+	observation := e.Reset()
+	total := 0.0
+	for steps := 1; ; steps += 1 {
+		var reward float64
+		var done bool
+		observation, reward, done = e.Step(policy(observation))
+		total += reward
+
+		if done {
+			return total, steps
+		}
+	}
+}
+
+func TestPoleBalancing(t *testing.T) {
+	// Doing nothing useful, the pole falls over
+	cartPole := NewCartPole(true)
+	if reward, _ := runPolicy(cartPole, func([]float64) []float64 { return []float64{1} }); reward >= 100 {
+		t.Errorf("pushing right the whole time shouldn't balance the pole, got %g", reward)
+	}
+
+	// Pushing the cart under the pole keeps it up for a good while
+	cartPole = NewCartPole(true)
+	reward, _ := runPolicy(cartPole, func(o []float64) []float64 {
+		if o[2]+0.5*o[3] > 0 {
+			return []float64{1}
+		}
+		return []float64{0}
+	})
+	if reward < 100 {
+		t.Errorf("a simple controller should balance the pole for 100 steps, got %g", reward)
+	}
+
+	doublePole := NewDoublePole(true)
+	if reward, steps := runPolicy(doublePole, func([]float64) []float64 { return []float64{0.5} }); reward >= 1000 || steps > 500 {
+		t.Errorf("with no force the long pole should fall, got %g reward after %d steps", reward, steps)
+	}
+	if len(doublePole.observe()) != 6 || len(NewDoublePole(false).Reset()) != 3 {
+		t.Error("observation sizes are wrong")
+	}
+}
+
+func TestMountainCarAndAcrobot(t *testing.T) {
+	car := NewMountainCar()
+	car.Seed(1)
+	if _, steps := runPolicy(car, func([]float64) []float64 { return []float64{0, 0, 1} }); steps < 200 {
+		t.Errorf("the car isn't strong enough to drive straight up the hill, made it in %d steps", steps)
+	}
+
+	// Push in whatever direction it's already going
+	car.Seed(1)
+	_, steps := runPolicy(car, func(o []float64) []float64 {
+		if o[1] < 0 {
+			return []float64{1, 0, 0}
+		}
+		return []float64{0, 0, 1}
+	})
+	if steps >= 200 {
+		t.Error("pumping energy into the car should get it up the hill")
+	}
+
+	acrobot := NewAcrobot()
+	if reward, _ := runPolicy(acrobot, func([]float64) []float64 { return []float64{0.5} }); reward != -500 {
+		t.Errorf("with no torque the acrobot should just hang there, got %g", reward)
+	}
+}
+
+func TestFitness(t *testing.T) {
+	genome := neat.NewGenome(4, 1, true, -1, 1)
+	network := neat.NewNetwork(genome, nil)
+
+	fitness := Fitness(func() Environment { return NewCartPole(true) }, 5, 42)
+	first := fitness(network)
+	if first < 0 || first > 1000 {
+		t.Errorf("cart-pole fitness should be between 0 and 1000 steps, got %g", first)
+	}
+	if again := fitness(network); again != first {
+		t.Errorf("seeded episodes should give the same fitness, got %g then %g", first, again)
+	}
+
+	// Wrong number of inputs for the environment
+	fitness = Fitness(func() Environment { return NewAcrobot() }, 1, 0)
+	if f := fitness(network); !math.IsInf(f, -1) {
+		t.Errorf("mismatched network should get -Inf fitness, got %g", f)
+	}
+}
@@ -0,0 +1,152 @@
+package env
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/TylerLeite/neuro-q/ma"
+	"github.com/TylerLeite/neuro-q/neat"
+)
+
+// Control tasks for evolving controllers. An environment is a simulation that a controller (usually a neat.Network)
+// interacts with one step at a time: it sees an observation, picks an action, and gets a reward
+
+type Environment interface {
+	// Start a new episode, returns the first observation
+	Reset() []float64
+
+	// Apply an action, returns the next observation, the reward for this step and whether the episode is over
+	Step(action []float64) ([]float64, float64, bool)
+
+	ObservationSize() int
+	ActionSize() int
+}
+
+// Environments with random starting states. Fitness seeds them so every organism sees the same episodes
+type Seeder interface {
+	Seed(seed int64)
+}
+
+// Base for the environments here: a random number generator and a step limit
+type episode struct {
+	rng *rand.Rand
+
+	MaxSteps int
+	steps    int
+}
+
+func newEpisode(maxSteps int) episode {
+	return episode{
+		rng:      rand.New(rand.NewSource(0)),
+		MaxSteps: maxSteps,
+	}
+}
+
+func (e *episode) Seed(seed int64) {
+	e.rng = rand.New(rand.NewSource(seed))
+}
+
+func (e *episode) uniform(low, high float64) float64 {
+	return low + e.rng.Float64()*(high-low)
+}
+
+// Count a step, true once the episode has run out of steps
+func (e *episode) tick() bool {
+	e.steps += 1
+	return e.MaxSteps > 0 && e.steps >= e.MaxSteps
+}
+
+// Pick one of n discrete actions. A single output is split into n equal ranges over (0, 1), more outputs pick the
+// largest
+func discreteAction(action []float64, n int) int {
+	if len(action) == 1 {
+		i := int(math.Floor(action[0] * float64(n)))
+		if i < 0 {
+			return 0
+		} else if i >= n {
+			return n - 1
+		}
+		return i
+	}
+
+	best := 0
+	for i := 1; i < len(action) && i < n; i += 1 {
+		if action[i] > action[best] {
+			best = i
+		}
+	}
+
+	return best
+}
+
+// One step of fourth order Runge-Kutta for ds/dt = f(s)
+func rk4(s []float64, dt float64, f func(s []float64) []float64) []float64 {
+	add := func(s, ds []float64, h float64) []float64 {
+		out := make([]float64, len(s))
+		for i := range s {
+			out[i] = s[i] + h*ds[i]
+		}
+		return out
+	}
+
+	k1 := f(s)
+	k2 := f(add(s, k1, dt/2))
+	k3 := f(add(s, k2, dt/2))
+	k4 := f(add(s, k3, dt))
+
+	out := make([]float64, len(s))
+	for i := range s {
+		out[i] = s[i] + dt/6*(k1[i]+2*k2[i]+2*k3[i]+k4[i])
+	}
+
+	return out
+}
+
+// Total reward of one episode with the network as the controller. Recurrent networks are reset first, then keep
+// their state for the whole episode
+func RunEpisode(n *neat.Network, e Environment) (float64, error) {
+	n.Reset()
+
+	observation := e.Reset()
+	total := 0.0
+	for {
+		action, err := n.PredictStep(observation)
+		if err != nil {
+			return total, err
+		}
+
+		var reward float64
+		var done bool
+		observation, reward, done = e.Step(action)
+		total += reward
+
+		if done {
+			return total, nil
+		}
+	}
+}
+
+// Fitness function that averages the returns of a network over some episodes. newEnvironment is called once per
+// episode, and environments that implement Seeder get seed, seed+1, ... so every organism is scored on the same
+// starting states
+func Fitness(newEnvironment func() Environment, episodes int, seed int64) ma.FitnessFunction {
+	return func(o ma.Organism) float64 {
+		n := o.(*neat.Network)
+
+		total := 0.0
+		for i := 0; i < episodes; i += 1 {
+			e := newEnvironment()
+			if seeder, ok := e.(Seeder); ok {
+				seeder.Seed(seed + int64(i))
+			}
+
+			episodeReturn, err := RunEpisode(n, e)
+			if err != nil {
+				return math.Inf(-1)
+			}
+			total += episodeReturn
+		}
+
+		return total / float64(episodes)
+	}
+}
@@ -0,0 +1,76 @@
+package env
+
+import (
+	"math"
+)
+
+// Mountain car (Moore 1990): an underpowered car at the bottom of a valley has to rock back and forth to build up
+// enough momentum to reach the flag on the right hill. Reward is -1 per step until it gets there
+
+type MountainCar struct {
+	episode
+
+	Position, Velocity float64
+}
+
+const (
+	mountainCarMinPosition = -1.2
+	mountainCarMaxPosition = 0.6
+	mountainCarMaxSpeed    = 0.07
+	mountainCarGoal        = 0.5
+	mountainCarPower       = 0.001
+	mountainCarGravity     = 0.0025
+)
+
+// Three actions: push left, coast, push right. See discreteAction for how outputs map to them
+func NewMountainCar() *MountainCar {
+	return &MountainCar{
+		episode: newEpisode(200),
+	}
+}
+
+func (m *MountainCar) ObservationSize() int {
+	return 2
+}
+
+func (m *MountainCar) ActionSize() int {
+	return 3
+}
+
+func (m *MountainCar) Reset() []float64 {
+	m.steps = 0
+
+	m.Position = m.uniform(-0.6, -0.4)
+	m.Velocity = 0
+
+	return m.observe()
+}
+
+// Scaled to [-1, 1]
+func (m *MountainCar) observe() []float64 {
+	mid := (mountainCarMaxPosition + mountainCarMinPosition) / 2
+	halfWidth := (mountainCarMaxPosition - mountainCarMinPosition) / 2
+
+	return []float64{(m.Position - mid) / halfWidth, m.Velocity / mountainCarMaxSpeed}
+}
+
+func (m *MountainCar) Step(action []float64) ([]float64, float64, bool) {
+	push := float64(discreteAction(action, 3) - 1)
+
+	m.Velocity += push*mountainCarPower - mountainCarGravity*math.Cos(3*m.Position)
+	m.Velocity = math.Min(math.Max(m.Velocity, -mountainCarMaxSpeed), mountainCarMaxSpeed)
+
+	m.Position += m.Velocity
+	m.Position = math.Min(math.Max(m.Position, mountainCarMinPosition), mountainCarMaxPosition)
+
+	// Inelastic wall on the left
+	if m.Position == mountainCarMinPosition && m.Velocity < 0 {
+		m.Velocity = 0
+	}
+
+	if m.Position >= mountainCarGoal {
+		return m.observe(), -1, true
+	}
+
+	return m.observe(), -1, m.tick()
+}
@@ -99,6 +99,9 @@ func SmallValueApproximationEvolution() {
 	fmt.Println("Generating...")
 	p.Generate()
 
+	// Seed the population with a known-good program
+	p.Inject(manualProgram)
+
 	G := popCfg.MaxEpochs
 	maxFitness := math.Inf(-1)
 	for i := 0; i < G; i += 1 {
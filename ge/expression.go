@@ -0,0 +1,195 @@
+package ge
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/TylerLeite/neuro-q/neat"
+)
+
+// Converting closed-form network expressions (see neat/expression.go) into syntax trees, so evolved CPPNs can be
+// inspected or used as a starting point with the same tools as evolved programs
+
+type ExpressionError struct {
+	Msg string
+}
+
+func (e *ExpressionError) Error() string {
+	return e.Msg
+}
+
+var expressionOperators = map[neat.ExpressionKind]string{
+	neat.SumExpression:     "+",
+	neat.ProductExpression: "*",
+	neat.MaxExpression:     "max",
+	neat.MinExpression:     "min",
+	neat.MeanExpression:    "mean",
+}
+
+// Builds the grammar while the tree is being converted. Every operator and leaf gets its own symbol, K is the
+// (unexpressed) symbol that can turn into any of them
+type expressionGrammar struct {
+	rules   Rules
+	symbols map[string]Symbol
+	arities map[Symbol]map[int]bool
+	next    Symbol
+}
+
+const expressionK = 0x02
+
+func newExpressionGrammar() *expressionGrammar {
+	g := &expressionGrammar{
+		rules:   make(Rules),
+		symbols: map[string]Symbol{"!": 0x01, "K": expressionK, "_": 0xFE, "~": 0xFF},
+		arities: make(map[Symbol]map[int]bool),
+		next:    0x03,
+	}
+
+	g.rules[0x01] = []Rule{{expressionK}}
+	g.rules[0xFF] = []Rule{{expressionK}}
+
+	return g
+}
+
+func (g *expressionGrammar) symbol(name string, arity int) (Symbol, error) {
+	s, ok := g.symbols[name]
+	if !ok {
+		if g.next >= 0xFE {
+			return 0, &ExpressionError{Msg: "expression has too many distinct symbols for a grammar"}
+		}
+
+		s = g.next
+		g.next += 1
+		g.symbols[name] = s
+		g.rules[expressionK] = append(g.rules[expressionK], Rule{s})
+		g.arities[s] = make(map[int]bool)
+	}
+
+	if !g.arities[s][arity] {
+		g.arities[s][arity] = true
+
+		rule := Rule{0xFE}
+		if arity > 0 {
+			rule = make(Rule, arity)
+			for i := range rule {
+				rule[i] = expressionK
+			}
+		}
+		g.rules[s] = append(g.rules[s], rule)
+	}
+
+	return s, nil
+}
+
+func (g *expressionGrammar) symbolNames() SymbolNames {
+	names := make(SymbolNames)
+	for name, s := range g.symbols {
+		names[s] = name
+	}
+
+	return names
+}
+
+func expressionName(e *neat.Expression) string {
+	switch e.Kind {
+	case neat.ConstantExpression:
+		return strconv.FormatFloat(e.Value, 'g', -1, 64)
+	case neat.VariableExpression:
+		return e.Name
+	case neat.FunctionExpression:
+		if a, ok := neat.ActivationByName(e.Name); ok {
+			return a.Symbol
+		}
+		return e.Name
+	default:
+		return expressionOperators[e.Kind]
+	}
+}
+
+// Derivation tree (root is K) for an expression
+func (g *expressionGrammar) derive(e *neat.Expression) (*DerivationTree, error) {
+	s, err := g.symbol(expressionName(e), len(e.Children))
+	if err != nil {
+		return nil, err
+	}
+
+	k := &DerivationTree{Value: expressionK, Rules: g.rules}
+	node := &DerivationTree{Value: s, Rules: g.rules}
+	k.AppendChild(node)
+
+	for _, child := range e.Children {
+		derived, err := g.derive(child)
+		if err != nil {
+			return nil, err
+		}
+		node.AppendChild(derived)
+	}
+
+	return k, nil
+}
+
+// Syntax tree for an expression. Node names are the operators (+, *, max, min, mean), activation function symbols
+// (sin, gauss, ...), variable names and constants. Every node references a grammar that can derive the tree
+func FromExpression(e *neat.Expression) (*DerivationTree, error) {
+	g := newExpressionGrammar()
+
+	derived, err := g.derive(e)
+	if err != nil {
+		return nil, err
+	}
+
+	// The grammar is only complete once the whole tree is derived
+	names := g.symbolNames()
+	var finish func(t *DerivationTree)
+	finish = func(t *DerivationTree) {
+		t.Rules = g.rules
+		t.SymbolNames = names
+		for _, child := range t.Children {
+			finish(child)
+		}
+	}
+	finish(derived)
+
+	return derived.ToSyntaxTree(), nil
+}
+
+// Value of a syntax tree from FromExpression. Unknown variables are 0
+func ExpressionValue(t *DerivationTree, variables map[string]float64) float64 {
+	name := t.SymbolNames[t.Value]
+
+	values := make([]float64, len(t.Children))
+	for i, child := range t.Children {
+		values[i] = ExpressionValue(child, variables)
+	}
+
+	switch name {
+	case "+":
+		return neat.SumAggregation.Aggregate(values)
+	case "*":
+		return neat.ProductAggregation.Aggregate(values)
+	case "max":
+		return neat.MaxAggregation.Aggregate(values)
+	case "min":
+		return neat.MinAggregation.Aggregate(values)
+	case "mean":
+		return neat.MeanAggregation.Aggregate(values)
+	}
+
+	if a, ok := neat.ActivationBySymbol(name); ok && len(values) == 1 {
+		return a.Fn(values[0])
+	}
+
+	if x, err := strconv.ParseFloat(name, 64); err == nil {
+		return x
+	}
+
+	if x, ok := variables[name]; ok {
+		return x
+	}
+
+	if len(values) > 0 {
+		panic(fmt.Sprintf("unknown operator %q in expression tree", name))
+	}
+
+	return 0
+}
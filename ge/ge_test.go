@@ -2,7 +2,10 @@ package ge
 
 import (
 	"fmt"
+	"math"
 	"testing"
+
+	"github.com/TylerLeite/neuro-q/neat"
 )
 
 func TestGrammar(t *testing.T) {
@@ -81,3 +84,38 @@ func TestGrammar(t *testing.T) {
 	s := a.ToSyntaxTree()
 	fmt.Println(s)
 }
+
+func TestFromExpression(t *testing.T) {
+	x, y := neat.Variable("x"), neat.Variable("y")
+
+	// sin(0.3*x + gauss(-1.2*y)) * max(x, y, 0.5)
+	e := &neat.Expression{Kind: neat.ProductExpression, Children: []*neat.Expression{
+		{Kind: neat.FunctionExpression, Name: neat.SinStr, Children: []*neat.Expression{
+			{Kind: neat.SumExpression, Children: []*neat.Expression{
+				{Kind: neat.ProductExpression, Children: []*neat.Expression{neat.Constant(0.3), x}},
+				{Kind: neat.FunctionExpression, Name: neat.GaussianStr, Children: []*neat.Expression{
+					{Kind: neat.ProductExpression, Children: []*neat.Expression{neat.Constant(-1.2), y}},
+				}},
+			}},
+		}},
+		{Kind: neat.MaxExpression, Children: []*neat.Expression{x, y, neat.Constant(0.5)}},
+	}}
+
+	tree, err := FromExpression(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := tree.SymbolNames[tree.Value]; name != "*" {
+		t.Errorf("root should be *, got %s", name)
+	}
+
+	for _, point := range [][2]float64{{0, 0}, {0.5, -1}, {-2, 3}} {
+		variables := map[string]float64{"x": point[0], "y": point[1]}
+		expected := e.Evaluate(variables)
+		got := ExpressionValue(tree, variables)
+		if math.Abs(expected-got) > 1e-12 {
+			t.Errorf("tree gives %g at %v, expression gives %g", got, point, expected)
+		}
+	}
+}
@@ -10,4 +10,6 @@ const (
 	DEBUG_MUTATION        = false
 	DEBUG_GET_ACTIVATION  = false
 	DEBUG_GENOME_DISTANCE = false
+	DEBUG_WEIGHT_SEARCH   = false
+	DEBUG_BACKPROP        = false
 )
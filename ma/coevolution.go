@@ -0,0 +1,235 @@
+package ma
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Plays two organisms from opposing populations against each other, returning the score each one earned.
+// Competitive setups (predator/prey, generator/discriminator) usually have scores that trade off against each other,
+// cooperative setups usually give both organisms the same score. It's called with copies, so it can activate or
+// change them freely
+type InteractionFunction func(Organism, Organism) (float64, float64)
+
+// How opponents are picked from the other population when calculating relative fitness
+type OpponentSampling uint8
+
+const (
+	SampleBest       OpponentSampling = iota // The K most fit members of the other population
+	SampleRandom                             // K random members of the other population
+	SampleHallOfFame                         // The other population's latest champion + K-1 random past champions
+)
+
+// Two populations whose fitness depends on each other. Each population's FitnessOf is the average score its members
+// earn against a fixed set of opponents sampled from the other population at the start of every epoch
+type Coevolution struct {
+	Populations [2]*Population
+
+	Interact InteractionFunction
+
+	Sampling       OpponentSampling
+	SampleSize     int // K, how many opponents each organism is evaluated against
+	HallOfFameSize int // How many past champions to remember per population, 0 for no limit
+	CacheSize      int // The interaction cache is cleared once it holds this many pairs, 0 for no limit
+
+	// Champion of each population at the end of every epoch
+	HallOfFame [2][]Organism
+
+	CacheHits   int
+	CacheMisses int
+
+	opponents [2][]Organism
+
+	cache      map[string][2]float64
+	cacheMutex sync.Mutex
+}
+
+func NewCoevolution(seed0, seed1 Organism, interact InteractionFunction) *Coevolution {
+	c := &Coevolution{
+		Interact: interact,
+
+		// Default config values
+		Sampling:       SampleRandom,
+		SampleSize:     5,
+		HallOfFameSize: 0,
+		CacheSize:      1 << 16,
+
+		cache: make(map[string][2]float64),
+	}
+
+	c.Populations[0] = NewPopulation(seed0, c.relativeFitness(0))
+	c.Populations[1] = NewPopulation(seed1, c.relativeFitness(1))
+
+	return c
+}
+
+// Fitness of an organism from population `side` against the current opponents
+func (c *Coevolution) relativeFitness(side int) FitnessFunction {
+	return func(o Organism) float64 {
+		opponents := c.opponents[side]
+		if len(opponents) == 0 {
+			return 0
+		}
+
+		total := float64(0)
+		for _, opponent := range opponents {
+			if side == 0 {
+				total += c.Score(o, opponent)[0]
+			} else {
+				total += c.Score(opponent, o)[1]
+			}
+		}
+
+		return total / float64(len(opponents))
+	}
+}
+
+// Scores of an interaction between a member of population 0 and a member of population 1, cached by genetic code
+func (c *Coevolution) Score(o0, o1 Organism) [2]float64 {
+	key := o0.GeneticCode().String() + "|" + o1.GeneticCode().String()
+
+	c.cacheMutex.Lock()
+	if scores, ok := c.cache[key]; ok {
+		c.CacheHits += 1
+		c.cacheMutex.Unlock()
+		return scores
+	}
+	c.CacheMisses += 1
+	c.cacheMutex.Unlock()
+
+	// Don't hold the lock while interacting, this is the expensive part. Species are evaluated in parallel and they all
+	// play the same opponents, so Interact gets copies: organisms like neat networks reuse buffers when activated
+	s0, s1 := c.Interact(o0.Copy(), o1.Copy())
+	scores := [2]float64{s0, s1}
+
+	c.cacheMutex.Lock()
+	if c.CacheSize > 0 && len(c.cache) >= c.CacheSize {
+		c.cache = make(map[string][2]float64)
+	}
+	c.cache[key] = scores
+	c.cacheMutex.Unlock()
+
+	return scores
+}
+
+func (c *Coevolution) ClearCache() {
+	c.cacheMutex.Lock()
+	c.cache = make(map[string][2]float64)
+	c.cacheMutex.Unlock()
+}
+
+// Current opponents of population `side`
+func (c *Coevolution) Opponents(side int) []Organism {
+	return c.opponents[side]
+}
+
+// Generate both initial populations, opponents start out random
+func (c *Coevolution) Generate() {
+	c.Populations[0].Generate()
+	c.Populations[1].Generate()
+
+	c.opponents[0] = c.sampleRandom(c.Populations[1].Members(), c.SampleSize)
+	c.opponents[1] = c.sampleRandom(c.Populations[0].Members(), c.SampleSize)
+}
+
+// Pick opponents for population `side` out of the other population
+func (c *Coevolution) SampleOpponents(side int) {
+	other := c.Populations[1-side]
+	members := other.Members()
+
+	switch c.Sampling {
+	case SampleBest:
+		so := SortableOrganisms{
+			organisms: members,
+			ff:        other.FitnessOf,
+		}
+		sort.Sort(so)
+
+		k := c.SampleSize
+		if k > len(members) {
+			k = len(members)
+		}
+		c.opponents[side] = members[:k]
+	case SampleHallOfFame:
+		hallOfFame := c.HallOfFame[1-side]
+		if len(hallOfFame) == 0 {
+			c.opponents[side] = c.sampleRandom(members, c.SampleSize)
+			break
+		}
+
+		// Always play against the most recent champion
+		latest := hallOfFame[len(hallOfFame)-1]
+		c.opponents[side] = append([]Organism{latest}, c.sampleRandom(hallOfFame[:len(hallOfFame)-1], c.SampleSize-1)...)
+	default:
+		c.opponents[side] = c.sampleRandom(members, c.SampleSize)
+	}
+}
+
+func (c *Coevolution) sampleRandom(from []Organism, k int) []Organism {
+	if k <= 0 || len(from) == 0 {
+		return []Organism{}
+	}
+
+	if k >= len(from) {
+		out := make([]Organism, len(from))
+		copy(out, from)
+		return out
+	}
+
+	out := make([]Organism, k)
+	for i, j := range rand.Perm(len(from))[:k] {
+		out[i] = from[j]
+	}
+
+	return out
+}
+
+func (c *Coevolution) updateHallOfFame(side int) {
+	p := c.Populations[side]
+	if len(p.Species) == 0 {
+		return
+	}
+
+	champion := p.Species[0].Champion()
+	for _, species := range p.Species[1:] {
+		candidate := species.Champion()
+		if p.FitnessOf(candidate) > p.FitnessOf(champion) {
+			champion = candidate
+		}
+	}
+
+	c.HallOfFame[side] = append(c.HallOfFame[side], champion.Copy())
+	if c.HallOfFameSize > 0 && len(c.HallOfFame[side]) > c.HallOfFameSize {
+		c.HallOfFame[side] = c.HallOfFame[side][1:]
+	}
+}
+
+// Run one epoch of each population. Opponents are fixed for the duration of a population's epoch so that
+// fitness stays consistent while sorting, selecting and recombining
+func (c *Coevolution) Epoch() ([2][]GeneticCode, [2][]float64, error) {
+	var (
+		champions [2][]GeneticCode
+		fitnesses [2][]float64
+	)
+
+	for side := 0; side < 2; side += 1 {
+		c.SampleOpponents(side)
+
+		var err error
+		champions[side], fitnesses[side], err = c.Populations[side].Epoch()
+		if err != nil {
+			return champions, fitnesses, err
+		}
+	}
+
+	if len(c.Populations[0].Species) == 0 || len(c.Populations[1].Species) == 0 {
+		return champions, fitnesses, errors.New("one of the coevolving populations went extinct")
+	}
+
+	c.updateHallOfFame(0)
+	c.updateHallOfFame(1)
+
+	return champions, fitnesses, nil
+}
@@ -0,0 +1,27 @@
+package ma
+
+// Improve an organism, returning the improved organism (or the original if no improvement was found).
+// Used for Lamarckian learning in Species.LocalSearch
+type LocalSearchStrategy func(*Population, Organism) Organism
+
+// Random hill climb: try LocalSearchGenerations random neighbors and keep the most fit one
+func HillClimb(p *Population, organism Organism) Organism {
+	currentFitness := p.FitnessOf(organism)
+	if p.Surrogate != nil {
+		p.Surrogate.Observe(organism, currentFitness)
+	}
+	mostFitNeighbor := organism
+
+	for j := 0; j < p.LocalSearchGenerations; j += 1 {
+		neighbor := organism.RandomNeighbor()
+
+		// Neighbors the surrogate doesn't think can beat the current fitness are skipped
+		neighborFitness, evaluated := p.EvaluateIfPromising(neighbor, currentFitness)
+		if evaluated && neighborFitness > currentFitness {
+			mostFitNeighbor = neighbor
+			currentFitness = neighborFitness
+		}
+	}
+
+	return mostFitNeighbor
+}
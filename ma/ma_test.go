@@ -2,6 +2,7 @@ package ma
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"strings"
 	"testing"
@@ -230,3 +231,241 @@ func TestEvolution(t *testing.T) {
 
 	fmt.Println(championGenome, championFitness, "(", averageFitness/float64(len(p1.Species[0].Members)), ")")
 }
+
+// Longer strings beat shorter ones, ties go to whoever has more late letters
+func StringDuel(o0, o1 Organism) (float64, float64) {
+	s0 := o0.GeneticCode().String()
+	s1 := o1.GeneticCode().String()
+
+	score := float64(len(s0) - len(s1))
+	for i := 0; i < len(s0) && i < len(s1); i += 1 {
+		if s0[i] > s1[i] {
+			score += 0.1
+		} else if s0[i] < s1[i] {
+			score -= 0.1
+		}
+	}
+
+	return score, -score
+}
+
+func TestCoevolution(t *testing.T) {
+	seed0 := &StringOrganism{Genome: &EvolvingString{Code: "abc"}}
+	seed1 := &StringOrganism{Genome: &EvolvingString{Code: "xyz"}}
+
+	c := NewCoevolution(seed0, seed1, StringDuel)
+	c.Sampling = SampleHallOfFame
+	c.SampleSize = 3
+	for _, p := range c.Populations {
+		p.Size = 20
+		p.LocalSearchGenerations = 2
+	}
+
+	c.Generate()
+	if len(c.Opponents(0)) != 3 || len(c.Opponents(1)) != 3 {
+		t.Fatalf("expected 3 opponents each, got %d and %d", len(c.Opponents(0)), len(c.Opponents(1)))
+	}
+
+	for i := 0; i < 10; i += 1 {
+		_, _, err := c.Epoch()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(c.HallOfFame[0]) != 10 || len(c.HallOfFame[1]) != 10 {
+		t.Errorf("expected a champion per epoch in each hall of fame, got %d and %d", len(c.HallOfFame[0]), len(c.HallOfFame[1]))
+	}
+
+	if c.CacheHits == 0 {
+		t.Errorf("expected repeated interactions to hit the cache")
+	}
+}
+
+func TestOpponentSampling(t *testing.T) {
+	seed0 := &StringOrganism{Genome: &EvolvingString{Code: "abc"}}
+	seed1 := &StringOrganism{Genome: &EvolvingString{Code: "xyz"}}
+
+	interactions := 0
+	var c *Coevolution
+	c = NewCoevolution(seed0, seed1, func(o0, o1 Organism) (float64, float64) {
+		interactions += 1
+
+		// Interact should only ever see copies, never the opponents themselves
+		for _, opponent := range c.Opponents(0) {
+			if o1 == opponent {
+				t.Errorf("interaction got an opponent instead of a copy")
+			}
+		}
+
+		return StringDuel(o0, o1)
+	})
+	c.SampleSize = 3
+	c.Populations[0].Size = 10
+	c.Populations[1].Size = 10
+	c.Generate()
+
+	members := c.Populations[1].Members()
+	isMember := func(o Organism) bool {
+		for _, m := range members {
+			if o == m {
+				return true
+			}
+		}
+		return false
+	}
+
+	distinct := func(opponents []Organism) bool {
+		for i := range opponents {
+			for j := i + 1; j < len(opponents); j += 1 {
+				if opponents[i] == opponents[j] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	c.Sampling = SampleRandom
+	c.SampleOpponents(0)
+	opponents := c.Opponents(0)
+	if len(opponents) != 3 || !distinct(opponents) {
+		t.Fatalf("expected 3 distinct random opponents, got %d", len(opponents))
+	}
+	for _, o := range opponents {
+		if !isMember(o) {
+			t.Errorf("random opponent isn't from the other population")
+		}
+	}
+
+	c.Sampling = SampleBest
+	c.SampleOpponents(0)
+	opponents = c.Opponents(0)
+	if len(opponents) != 3 || !distinct(opponents) {
+		t.Fatalf("expected 3 distinct best opponents, got %d", len(opponents))
+	}
+
+	fitnessOf := c.Populations[1].FitnessOf
+	worstOpponent := fitnessOf(opponents[0])
+	for _, o := range opponents {
+		worstOpponent = math.Min(worstOpponent, fitnessOf(o))
+	}
+	for _, m := range members {
+		chosen := false
+		for _, o := range opponents {
+			chosen = chosen || o == m
+		}
+		if !chosen && fitnessOf(m) > worstOpponent {
+			t.Errorf("member with fitness %g was passed over for one with %g", fitnessOf(m), worstOpponent)
+		}
+	}
+
+	c.Sampling = SampleHallOfFame
+	for i := 0; i < 4; i += 1 {
+		c.HallOfFame[1] = append(c.HallOfFame[1], members[i].Copy())
+	}
+	c.SampleOpponents(0)
+	opponents = c.Opponents(0)
+	if len(opponents) != 3 || !distinct(opponents) {
+		t.Fatalf("expected 3 distinct hall of fame opponents, got %d", len(opponents))
+	}
+	if opponents[0] != c.HallOfFame[1][3] {
+		t.Errorf("latest champion should always be an opponent")
+	}
+	for _, o := range opponents[1:] {
+		found := false
+		for _, champion := range c.HallOfFame[1][:3] {
+			found = found || o == champion
+		}
+		if !found {
+			t.Errorf("hall of fame opponent isn't a past champion")
+		}
+	}
+
+	// Same pair again is a cache hit and doesn't interact
+	c.ClearCache()
+	hits, before := c.CacheHits, interactions
+	first := c.Score(c.Populations[0].Members()[0], opponents[0])
+	second := c.Score(c.Populations[0].Members()[0], opponents[0])
+	if interactions != before+1 || c.CacheHits != hits+1 {
+		t.Errorf("expected 1 interaction and 1 cache hit, got %d and %d", interactions-before, c.CacheHits-hits)
+	}
+	if first != second {
+		t.Errorf("cached scores changed: %v then %v", first, second)
+	}
+}
+
+func TestInjectAndRestart(t *testing.T) {
+	seed := &StringOrganism{Genome: &EvolvingString{Code: "abcdef"}}
+
+	p := NewPopulation(seed, StringOrganismFitness)
+	p.Size = 20
+	p.Generate()
+
+	known := &StringOrganism{Genome: &EvolvingString{Code: "zyxwvuts"}}
+	p.Inject(known)
+
+	if p.CountMembers() != 21 {
+		t.Fatalf("expected 21 members after injecting, got %d", p.CountMembers())
+	}
+
+	knownFitness := StringOrganismFitness(known)
+
+	p.Restart(1)
+	if p.CountMembers() != p.Size {
+		t.Fatalf("expected %d members after restarting, got %d", p.Size, p.CountMembers())
+	}
+
+	// The injected organism was the most fit, so it should survive the restart
+	found := false
+	for _, o := range p.Members() {
+		if StringOrganismFitness(o) >= knownFitness {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("most fit organism did not survive the restart")
+	}
+
+	p.RestartCondition = RestartEvery(2)
+	p.RestartKeep = 5
+	for i := 0; i < 4; i += 1 {
+		if _, _, err := p.Epoch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if p.Restarts != 3 {
+		t.Errorf("expected 3 restarts (1 manual + 2 scheduled), got %d", p.Restarts)
+	}
+}
+
+func TestSurrogate(t *testing.T) {
+	seed := &StringOrganism{Genome: &EvolvingString{Code: "abcdef"}}
+
+	p := NewPopulation(seed, StringOrganismFitness)
+	p.Size = 30
+	p.Surrogate = NewKNNSurrogate(3, 100, p.Cs...)
+	p.SurrogateOversample = 3
+	p.SurrogateAuditRate = 0.5
+	p.Generate()
+
+	for i := 0; i < 5; i += 1 {
+		if _, _, err := p.Epoch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := p.SurrogateStats
+	if stats.Predictions == 0 {
+		t.Fatalf("surrogate never made a prediction")
+	}
+
+	if stats.Screened == 0 {
+		t.Errorf("surrogate never screened out a candidate")
+	}
+
+	if stats.Audits > 0 && (stats.DecisionAccuracy() < 0 || stats.DecisionAccuracy() > 1) {
+		t.Errorf("decision accuracy out of range: %s", stats.String())
+	}
+}
@@ -28,8 +28,26 @@ type Population struct {
 	DropoffAge             int
 	DistanceThreshold      float64
 
+	// How organisms are improved during local search, nil for HillClimb
+	LocalSearchStrategy LocalSearchStrategy
+
 	// Constants for distance function
 	Cs []float64
+
+	// Checked at the end of every epoch, if it returns true the population is restarted keeping RestartKeep members
+	RestartCondition RestartCondition
+	RestartKeep      int
+
+	// Optional fitness model used to pre-screen offspring during local search and recombination
+	Surrogate           Surrogate
+	SurrogateOversample int     // Candidates generated per child during recombination, only the most promising is kept
+	SurrogateAuditRate  float64 // Chance that a screened-out candidate is evaluated anyway, to measure false negatives
+	SurrogateStats      *SurrogateStats
+
+	// Max champion fitness at the end of each epoch
+	FitnessHistory []float64
+	Restarts       int
+	lastRestart    int // Index into FitnessHistory of the last restart
 }
 
 func NewPopulation(seed Organism, fitnessFunction FitnessFunction) *Population {
@@ -47,6 +65,13 @@ func NewPopulation(seed Organism, fitnessFunction FitnessFunction) *Population {
 		DropoffAge:             math.MaxInt, // Speciation off by default
 		DistanceThreshold:      math.MaxFloat64,
 		Cs:                     []float64{1, 1, 0.4, 0.1},
+		RestartCondition:       nil, // Restarts off by default
+		RestartKeep:            0,
+		Surrogate:              nil, // No surrogate by default
+		SurrogateOversample:    1,
+		SurrogateAuditRate:     0.05,
+		SurrogateStats:         &SurrogateStats{},
+		FitnessHistory:         make([]float64, 0),
 	}
 
 	return &p
@@ -60,6 +85,11 @@ func (p *Population) Copy() *Population {
 		newPopulation.Species[i] = v.Copy(newPopulation)
 	}
 
+	newPopulation.FitnessHistory = make([]float64, len(p.FitnessHistory))
+	copy(newPopulation.FitnessHistory, p.FitnessHistory)
+	newPopulation.Restarts = p.Restarts
+	newPopulation.lastRestart = p.lastRestart
+
 	return newPopulation
 }
 
@@ -75,9 +105,18 @@ func (p *Population) CopyConfig() *Population {
 		RecombinationPercent:   p.RecombinationPercent,
 		MinimumEntropy:         p.MinimumEntropy,
 		LocalSearchGenerations: p.LocalSearchGenerations,
+		LocalSearchStrategy:    p.LocalSearchStrategy,
 		DropoffAge:             p.DropoffAge,
 		DistanceThreshold:      p.DistanceThreshold,
 		Cs:                     make([]float64, len(p.Cs)),
+		RestartCondition:       p.RestartCondition,
+		RestartKeep:            p.RestartKeep,
+
+		// Surrogate and its stats are shared, they should keep learning across generations
+		Surrogate:           p.Surrogate,
+		SurrogateOversample: p.SurrogateOversample,
+		SurrogateAuditRate:  p.SurrogateAuditRate,
+		SurrogateStats:      p.SurrogateStats,
 	}
 
 	copy(newPopulation.Cs, p.Cs)
@@ -112,16 +151,85 @@ func (p *Population) Generate() {
 	p.Species[0] = NewSpecies(p)
 	for i := 0; i < p.Size; i += 1 {
 		log.Book(fmt.Sprintf("Generating %d/%d:\n", i, p.Size), log.DEBUG, log.DEBUG_GENERATE)
+		p.Species[0].Members = append(p.Species[0].Members, p.randomOrganism())
+	}
+
+	p.SeparateIntoSpecies()
+}
+
+func (p *Population) randomOrganism() Organism {
+	newOrganism := p.Seed.Copy()
+	newOrganism.GeneticCode().Randomize()
+
+	log.Book(fmt.Sprintf("\t%s\n", newOrganism.GeneticCode().String()), log.DEBUG, log.DEBUG_GENERATE)
+
+	return newOrganism
+}
+
+// Add known organisms (e.g. hand-written or loaded from disk) to the population. Each one joins the first species
+// it is close enough to, or starts a new species otherwise
+func (p *Population) Inject(organisms ...Organism) {
+	for _, o := range organisms {
+		foundASpecies := false
+
+		for _, species := range p.Species {
+			if len(species.Members) == 0 {
+				continue
+			}
+
+			representative := species.RandomOrganism()
+			d := o.GeneticCode().DistanceFrom(representative.GeneticCode(), p.Cs...)
+			if d < p.DistanceThreshold {
+				species.Members = append(species.Members, o.Copy())
+				foundASpecies = true
+				break
+			}
+		}
+
+		if !foundASpecies {
+			newSpecies := NewSpecies(p)
+			newSpecies.Members = append(newSpecies.Members, o.Copy())
+			p.Species = append(p.Species, newSpecies)
+		}
+
+		log.Book(fmt.Sprintf("Injected %s\n", o.GeneticCode().String()), log.DEBUG, log.DEBUG_GENERATE)
+	}
+}
+
+// Keep the `keep` most fit organisms and regenerate the rest of the population from the seed
+func (p *Population) Restart(keep int) {
+	members := p.Members()
+	so := SortableOrganisms{
+		organisms: members,
+		ff:        p.FitnessOf,
+	}
+	sort.Sort(so)
+
+	if keep > len(members) {
+		keep = len(members)
+	}
 
-		newOrganism := p.Seed.Copy()
-		newOrganism.GeneticCode().Randomize()
+	log.Book(fmt.Sprintf("Restarting population, keeping %d/%d\n", keep, len(members)), log.DEBUG, log.DEBUG_EPOCH)
 
-		log.Book(fmt.Sprintf("\t%s\n", newOrganism.GeneticCode().String()), log.DEBUG, log.DEBUG_GENERATE)
+	p.Species = make([]*Species, 1)
+	p.Species[0] = NewSpecies(p)
+	for _, o := range members[:keep] {
+		p.Species[0].Members = append(p.Species[0].Members, o.Copy())
+	}
 
-		p.Species[0].Members = append(p.Species[0].Members, newOrganism)
+	for i := keep; i < p.Size; i += 1 {
+		p.Species[0].Members = append(p.Species[0].Members, p.randomOrganism())
 	}
 
 	p.SeparateIntoSpecies()
+
+	p.Restarts += 1
+	p.lastRestart = len(p.FitnessHistory)
+}
+
+// Max champion fitness of each epoch since the last restart
+func (p *Population) HistorySinceRestart() []float64 {
+	return p.FitnessHistory[p.lastRestart:]
 }
 
 // Output a new, speciated population
@@ -276,6 +384,16 @@ func (p *Population) Epoch() ([]GeneticCode, []float64, error) {
 
 	log.Break(log.NL, log.DEBUG, log.DEBUG_EPOCH)
 
+	maxFitness := math.Inf(-1)
+	for _, fitness := range fitnesses {
+		maxFitness = math.Max(maxFitness, fitness)
+	}
+	p.FitnessHistory = append(p.FitnessHistory, maxFitness)
+
+	if p.RestartCondition != nil && p.RestartCondition(p) {
+		p.Restart(p.RestartKeep)
+	}
+
 	return champions, fitnesses, nil
 }
 
@@ -0,0 +1,47 @@
+package ma
+
+// Decides whether a population should be restarted, checked at the end of every epoch
+type RestartCondition func(*Population) bool
+
+// Restart when the max fitness hasn't improved by more than epsilon over the last `generations` epochs
+func RestartOnStagnation(generations int, epsilon float64) RestartCondition {
+	return func(p *Population) bool {
+		history := p.HistorySinceRestart()
+		if len(history) <= generations {
+			return false
+		}
+
+		best := history[len(history)-generations-1]
+		for _, fitness := range history[len(history)-generations:] {
+			if fitness > best+epsilon {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Restart once every species has converged (see Species.HasConverged)
+func RestartOnConvergence() RestartCondition {
+	return func(p *Population) bool {
+		if len(p.Species) == 0 {
+			return false
+		}
+
+		for _, species := range p.Species {
+			if !species.HasConverged() {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Restart on a fixed schedule, every `generations` epochs
+func RestartEvery(generations int) RestartCondition {
+	return func(p *Population) bool {
+		return len(p.HistorySinceRestart()) >= generations
+	}
+}
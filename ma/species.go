@@ -110,21 +110,14 @@ func (s *Species) AverageFitness() float64 {
 
 // Local search
 func (s *Species) LocalSearch() {
-	for i, organism := range s.Members {
-		currentFitness := s.Population.FitnessOf(organism)
-		mostFitNeighbor := organism
-
-		for j := 0; j < s.Population.LocalSearchGenerations; j += 1 {
-			neighbor := organism.RandomNeighbor()
-			neighborFitness := s.Population.FitnessOf(neighbor)
-			if neighborFitness > currentFitness {
-				mostFitNeighbor = neighbor
-				currentFitness = neighborFitness
-			}
-		}
+	strategy := s.Population.LocalSearchStrategy
+	if strategy == nil {
+		strategy = HillClimb
+	}
 
+	for i, organism := range s.Members {
 		// Lamarckian learning: the new organism replaces the old one
-		s.Members[i] = mostFitNeighbor
+		s.Members[i] = strategy(s.Population, organism)
 	}
 }
 
@@ -170,13 +163,14 @@ func (s *Species) Recombination(culledPopulationCount float64) {
 
 	children := make([]Organism, numberToRecombine)
 	for i := 0; i < numberToRecombine; i += 1 {
-		var child Organism
-		r1 := rand.Intn(len(s.Members))
+		children[i] = s.Population.mostPromising(func() Organism {
+			r1 := rand.Intn(len(s.Members))
+
+			if len(s.Members) < 2 {
+				// Can't do crossover, so reproduce asexually
+				return s.Members[r1].RandomNeighbor()
+			}
 
-		if len(s.Members) < 2 {
-			// Can't do crossover, so reproduce asexually
-			child = s.Members[r1].RandomNeighbor()
-		} else {
 			// Select another parent at random
 			// TODO: sexual selection?
 			r2 := r1
@@ -185,17 +179,16 @@ func (s *Species) Recombination(culledPopulationCount float64) {
 			}
 
 			// Baby make
-			child = s.Members[r1].Crossover([]Organism{s.Members[r2]})
-		}
-
-		children[i] = child
+			return s.Members[r1].Crossover([]Organism{s.Members[r2]})
+		})
 	}
 
 	clones := make([]Organism, numberToMutate)
 	for i := 0; i < numberToMutate; i += 1 {
-		r := rand.Intn(len(s.Members))
-		clone := s.Members[r].RandomNeighbor()
-		clones[i] = clone
+		clones[i] = s.Population.mostPromising(func() Organism {
+			r := rand.Intn(len(s.Members))
+			return s.Members[r].RandomNeighbor()
+		})
 	}
 
 	champion := s.Champion()
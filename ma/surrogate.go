@@ -0,0 +1,296 @@
+package ma
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// A cheap model of the fitness function, used to pre-screen offspring before spending a real fitness evaluation
+type Surrogate interface {
+	Predict(Organism) (float64, bool) // Predicted fitness, false if the surrogate can't make a prediction yet
+	Observe(Organism, float64)        // Learn from a real fitness evaluation
+}
+
+type surrogateSample struct {
+	code    GeneticCode
+	fitness float64
+}
+
+// k-nearest-neighbors regression over GeneticCode.DistanceFrom, predictions are inverse-distance weighted
+type KNNSurrogate struct {
+	K          int
+	Capacity   int // Oldest samples are forgotten once the archive is full
+	MinSamples int // Don't predict until at least this many samples have been observed
+
+	// Constants for distance function, should match the population's
+	Cs []float64
+
+	samples []surrogateSample
+	known   map[string]int // Index into samples by genetic code string
+	next    int
+
+	// DistanceFrom isn't guaranteed to be read-only (neat genomes sort themselves), so lock for predictions too
+	mutex sync.Mutex
+}
+
+func NewKNNSurrogate(k, capacity int, cs ...float64) *KNNSurrogate {
+	s := &KNNSurrogate{
+		K:          k,
+		Capacity:   capacity,
+		MinSamples: k,
+		Cs:         make([]float64, len(cs)),
+
+		samples: make([]surrogateSample, 0),
+		known:   make(map[string]int),
+	}
+
+	copy(s.Cs, cs)
+
+	return s
+}
+
+func (s *KNNSurrogate) Observe(o Organism, fitness float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := o.GeneticCode().String()
+	if i, ok := s.known[key]; ok {
+		s.samples[i].fitness = fitness
+		return
+	}
+
+	sample := surrogateSample{
+		code:    o.GeneticCode().Copy(),
+		fitness: fitness,
+	}
+
+	if s.Capacity <= 0 || len(s.samples) < s.Capacity {
+		s.known[key] = len(s.samples)
+		s.samples = append(s.samples, sample)
+		return
+	}
+
+	// Archive is full, overwrite the oldest sample
+	delete(s.known, s.samples[s.next].code.String())
+	s.samples[s.next] = sample
+	s.known[key] = s.next
+	s.next = (s.next + 1) % s.Capacity
+}
+
+func (s *KNNSurrogate) Predict(o Organism) (float64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.samples) == 0 || len(s.samples) < s.MinSamples {
+		return 0, false
+	}
+
+	if i, ok := s.known[o.GeneticCode().String()]; ok {
+		return s.samples[i].fitness, true
+	}
+
+	type neighbor struct {
+		distance float64
+		fitness  float64
+	}
+
+	neighbors := make([]neighbor, len(s.samples))
+	for i, sample := range s.samples {
+		neighbors[i] = neighbor{
+			distance: o.GeneticCode().DistanceFrom(sample.code, s.Cs...),
+			fitness:  sample.fitness,
+		}
+	}
+
+	sort.Slice(neighbors, func(i, j int) bool {
+		return neighbors[i].distance < neighbors[j].distance
+	})
+
+	k := s.K
+	if k <= 0 || k > len(neighbors) {
+		k = len(neighbors)
+	}
+
+	var (
+		weightedSum float64
+		totalWeight float64
+	)
+
+	for _, n := range neighbors[:k] {
+		if n.distance == 0 {
+			return n.fitness, true
+		}
+
+		// Infinite fitness (e.g. a solved XOR) would swamp the average, so skip it
+		if math.IsInf(n.fitness, 0) || math.IsNaN(n.fitness) {
+			continue
+		}
+
+		weight := 1 / n.distance
+		weightedSum += weight * n.fitness
+		totalWeight += weight
+	}
+
+	if totalWeight == 0 {
+		return 0, false
+	}
+
+	return weightedSum / totalWeight, true
+}
+
+// How well the surrogate has been doing
+type SurrogateStats struct {
+	Predictions int // Candidates the surrogate made a prediction for
+	Screened    int // Candidates that never reached the real fitness function
+	Evaluated   int // Predicted candidates that were also evaluated for real
+
+	AbsoluteError float64 // Summed over Evaluated
+	SquaredError  float64
+
+	CorrectDecisions int // Evaluated candidates where the surrogate was right about beating the threshold
+	FalseNegatives   int // Screened-out candidates that were audited and turned out to be improvements
+	Audits           int // Screened-out candidates that were evaluated anyway, to measure false negatives
+
+	mutex sync.Mutex
+}
+
+func (s *SurrogateStats) MeanAbsoluteError() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Evaluated == 0 {
+		return 0
+	}
+	return s.AbsoluteError / float64(s.Evaluated)
+}
+
+func (s *SurrogateStats) RootMeanSquaredError() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Evaluated == 0 {
+		return 0
+	}
+	return math.Sqrt(s.SquaredError / float64(s.Evaluated))
+}
+
+// Percent of screening decisions (promising or not) that the real fitness function agreed with
+func (s *SurrogateStats) DecisionAccuracy() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	total := s.Evaluated + s.Audits
+	if total == 0 {
+		return 0
+	}
+	return float64(s.CorrectDecisions+s.Audits-s.FalseNegatives) / float64(total)
+}
+
+func (s *SurrogateStats) String() string {
+	mae := s.MeanAbsoluteError()
+	rmse := s.RootMeanSquaredError()
+	accuracy := s.DecisionAccuracy()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return fmt.Sprintf("predictions=%d, screened=%d, evaluated=%d, mae=%.4g, rmse=%.4g, accuracy=%.2f, false negatives=%d/%d",
+		s.Predictions, s.Screened, s.Evaluated, mae, rmse, accuracy, s.FalseNegatives, s.Audits)
+}
+
+// Evaluate o with the real fitness function unless the surrogate is confident it can't beat threshold.
+// Returns false (and the predicted fitness) if o was screened out. For local search strategies outside this package
+func (p *Population) EvaluateIfPromising(o Organism, threshold float64) (float64, bool) {
+	if p.Surrogate == nil {
+		return p.FitnessOf(o), true
+	}
+
+	predicted, ok := p.Surrogate.Predict(o)
+	if !ok {
+		fitness := p.FitnessOf(o)
+		p.Surrogate.Observe(o, fitness)
+		return fitness, true
+	}
+
+	stats := p.SurrogateStats
+	stats.mutex.Lock()
+	stats.Predictions += 1
+	stats.mutex.Unlock()
+
+	if predicted <= threshold {
+		if rand.Float64() >= p.SurrogateAuditRate {
+			stats.mutex.Lock()
+			stats.Screened += 1
+			stats.mutex.Unlock()
+			return predicted, false
+		}
+
+		// Audit the rejection to keep track of false negatives
+		fitness := p.FitnessOf(o)
+		p.Surrogate.Observe(o, fitness)
+
+		stats.mutex.Lock()
+		stats.Audits += 1
+		if fitness > threshold {
+			stats.FalseNegatives += 1
+		}
+		stats.mutex.Unlock()
+
+		return fitness, true
+	}
+
+	fitness := p.FitnessOf(o)
+	p.Surrogate.Observe(o, fitness)
+
+	stats.mutex.Lock()
+	stats.Evaluated += 1
+	if !math.IsInf(fitness, 0) {
+		stats.AbsoluteError += math.Abs(fitness - predicted)
+		stats.SquaredError += (fitness - predicted) * (fitness - predicted)
+	}
+	if fitness > threshold {
+		stats.CorrectDecisions += 1
+	}
+	stats.mutex.Unlock()
+
+	return fitness, true
+}
+
+// Generate SurrogateOversample candidates and keep the one with the highest predicted fitness
+func (p *Population) mostPromising(generate func() Organism) Organism {
+	best := generate()
+	if p.Surrogate == nil || p.SurrogateOversample <= 1 {
+		return best
+	}
+
+	bestPrediction, ok := p.Surrogate.Predict(best)
+	if !ok {
+		return best
+	}
+
+	// Only count candidates the surrogate actually predicted, every one of them but the winner got screened out
+	predictions := 1
+	for i := 1; i < p.SurrogateOversample; i += 1 {
+		candidate := generate()
+		prediction, ok := p.Surrogate.Predict(candidate)
+		if !ok {
+			continue
+		}
+
+		predictions += 1
+		if prediction > bestPrediction {
+			best = candidate
+			bestPrediction = prediction
+		}
+	}
+
+	p.SurrogateStats.mutex.Lock()
+	p.SurrogateStats.Predictions += predictions
+	p.SurrogateStats.Screened += predictions - 1
+	p.SurrogateStats.mutex.Unlock()
+
+	return best
+}
@@ -12,6 +12,7 @@ import (
 func main() {
 
 	var experiment = flag.String("test", "ge", "name of the test to run")
+	var target = flag.String("target", "", "image to paint for the target test")
 	flag.Parse()
 
 	fmt.Println(*experiment)
@@ -27,6 +28,10 @@ func main() {
 		cppn.NoiseEvolution()
 	case "mandelbrot":
 		cppn.MandelbrotEvolution()
+	case "target":
+		if err := cppn.TargetImageEvolution(*target); err != nil {
+			fmt.Println(err)
+		}
 	default:
 		fmt.Println("bye.")
 	}
@@ -0,0 +1,217 @@
+package neat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry of named activation functions. The built in functions from funcs.go are registered on startup, and users
+// can add their own with RegisterActivation before building genomes that use them
+
+type Activation struct {
+	Name string
+	Rep  string // Short code used in Genome.String, assigned automatically if empty
+
+	Fn         ActivationFunction
+	Derivative ActivationFunction // nil if the function isn't usefully differentiable, which rules out backprop
+
+	NodeTypes []NodeType // Node types that can be given this function by mutation, empty for any
+	Weight    float64    // Relative odds of being picked by RandomFunc
+
+	// Body of the function as Go source, with x as the argument. Only needed for WriteGo, can use the math package
+	Source string
+
+	// How the function is written in expressions, see expression.go. Symbol defaults to the name in lower case with
+	// underscores, LaTeX is a format string where %[1]s is the argument and defaults to \operatorname{Symbol}
+	Symbol string
+	LaTeX  string
+}
+
+func (a *Activation) AllowedFor(typ NodeType) bool {
+	if len(a.NodeTypes) == 0 {
+		return true
+	}
+
+	for _, allowed := range a.NodeTypes {
+		if allowed == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+type ActivationRegistryError struct {
+	Msg string
+}
+
+func (e *ActivationRegistryError) Error() string {
+	return e.Msg
+}
+
+var (
+	activations       = make(map[string]*Activation)
+	activationOrder   = make([]string, 0) // Registration order, so random draws are reproducible
+	activationReps    = make(map[string]string)
+	activationSymbols = make(map[string]string)
+	activationMutex   sync.RWMutex
+)
+
+// Functions RandomFunc draws from when no set is given. Nil means every registered function
+var DefaultActivationSet []string = nil
+
+func init() {
+	builtins := []Activation{
+		{Name: SinStr, Rep: SinRep, Fn: SinFunc, Derivative: SinDerivative, Source: "return math.Sin(x)", Symbol: "sin", LaTeX: `\sin\left(%[1]s\right)`},
+		{Name: Sin2Str, Rep: Sin2Rep, Fn: Sin2Func, Derivative: Sin2Derivative, Source: "return math.Sin(2 * x)", Symbol: "sin2", LaTeX: `\sin\left(2\left(%[1]s\right)\right)`},
+		{Name: AbsStr, Rep: AbsRep, Fn: AbsFunc, Derivative: AbsDerivative, Source: "return math.Abs(x)", Symbol: "abs", LaTeX: `\left|%[1]s\right|`},
+		{Name: NullStr, Rep: NullRep, Fn: NullFunc, Derivative: NullFunc, Source: "return 0.0", Symbol: "null", LaTeX: `0`},
+		{Name: GaussianStr, Rep: GaussianRep, Fn: GaussianFunc, Derivative: GaussianDerivative, Source: "return 2.0*math.Exp(-math.Pow(2.5*x, 2)) - 1.0", Symbol: "gauss", LaTeX: `\operatorname{gauss}\left(%[1]s\right)`},
+		{Name: SigmoidStr, Rep: SigmoidRep, Fn: SigmoidFunc, Derivative: SigmoidDerivative, Source: "return 1.0 / (1.0 + math.Exp(-x))", Symbol: "sigmoid", LaTeX: `\sigma\left(%[1]s\right)`},
+		{Name: NEATSigmoidStr, Rep: NEATSigmoidRep, Fn: NEATSigmoidFunc, Derivative: NEATSigmoidDerivative, Weight: 5, Source: "return 1 / (1 + math.Exp(-4.9*x))", Symbol: "nsigmoid", LaTeX: `\sigma\left(4.9\left(%[1]s\right)\right)`},
+		{Name: BipolarSigmoidStr, Rep: BipolarSigmoidRep, Fn: BipolarSigmoidFunc, Derivative: BipolarSigmoidDerivative, Source: "return 2.0/(1.0+math.Exp(-4.9*x)) - 1.0", Symbol: "bsigmoid", LaTeX: `\operatorname{bsigmoid}\left(%[1]s\right)`},
+		{Name: QuadraticStr, Rep: QuadraticRep, Fn: QuadraticFunc, Derivative: QuadraticDerivative, Source: "return x * x", Symbol: "sq", LaTeX: `\left(%[1]s\right)^2`},
+		{Name: StepStr, Rep: StepRep, Fn: StepFunc, Source: "return math.Floor(x*10) / 10", Symbol: "step", LaTeX: `\frac{\lfloor 10\left(%[1]s\right) \rfloor}{10}`}, // Flat almost everywhere
+		{Name: InversionStr, Rep: InversionRep, Fn: InversionFunc, Derivative: InversionDerivative, Source: "return -x", Symbol: "neg", LaTeX: `-\left(%[1]s\right)`},
+		{Name: ExponentiationStr, Rep: ExponentiationRep, Fn: ExponentiationFunc, Derivative: ExponentiationFunc, Source: "return math.Exp(x - 1)", Symbol: "exp1", LaTeX: `e^{%[1]s - 1}`},
+		{Name: TetrationStr, Rep: TetrationRep, Fn: TetrationFunc, Source: "return math.Pow(math.Abs(x), x)", Symbol: "tet", LaTeX: `\left|%[1]s\right|^{%[1]s}`}, // Blows up at 0
+		{Name: SawStr, Rep: SawRep, Fn: SawFunc, Derivative: IdentityDerivative, Source: "return math.Mod(x, 1)", Symbol: "saw", LaTeX: `\left(%[1]s\right) \bmod 1`},
+		{Name: IdentityStr, Rep: IdentityRep, Fn: IdentityFunc, Derivative: IdentityDerivative, Source: "return x", Symbol: "id", LaTeX: `%[1]s`},
+	}
+
+	for _, a := range builtins {
+		if err := RegisterActivation(a); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Add a function to the registry. Names and reps have to be unique, and a weight of 0 is treated as 1
+func RegisterActivation(a Activation) error {
+	activationMutex.Lock()
+	defer activationMutex.Unlock()
+
+	if a.Name == "" || a.Fn == nil {
+		return &ActivationRegistryError{Msg: "activation functions need a name and a function"}
+	}
+
+	if _, ok := activations[a.Name]; ok {
+		return &ActivationRegistryError{Msg: fmt.Sprintf("activation function %q is already registered", a.Name)}
+	}
+
+	if a.Rep == "" {
+		// Next free base 64 digit
+		for _, digit := range b64 {
+			if _, ok := activationReps[digit]; !ok {
+				a.Rep = digit
+				break
+			}
+		}
+
+		if a.Rep == "" {
+			return &ActivationRegistryError{Msg: "ran out of reps, give the function one explicitly"}
+		}
+	} else if other, ok := activationReps[a.Rep]; ok {
+		return &ActivationRegistryError{Msg: fmt.Sprintf("rep %q is already used by %q", a.Rep, other)}
+	}
+
+	if a.Weight <= 0 {
+		a.Weight = 1
+	}
+
+	if a.Symbol == "" {
+		a.Symbol = strings.ReplaceAll(strings.ToLower(a.Name), " ", "_")
+	}
+	if other, ok := activationSymbols[a.Symbol]; ok {
+		return &ActivationRegistryError{Msg: fmt.Sprintf("symbol %q is already used by %q", a.Symbol, other)}
+	}
+
+	if a.LaTeX == "" {
+		a.LaTeX = `\operatorname{` + strings.ReplaceAll(a.Symbol, "_", `\_`) + `}\left(%[1]s\right)`
+	}
+
+	activations[a.Name] = &a
+	activationOrder = append(activationOrder, a.Name)
+	activationReps[a.Rep] = a.Name
+	activationSymbols[a.Symbol] = a.Name
+
+	return nil
+}
+
+func ActivationByName(name string) (*Activation, bool) {
+	activationMutex.RLock()
+	defer activationMutex.RUnlock()
+
+	a, ok := activations[name]
+	return a, ok
+}
+
+// Look up a function by the symbol it's written as in expressions
+func ActivationBySymbol(symbol string) (*Activation, bool) {
+	activationMutex.RLock()
+	name, ok := activationSymbols[symbol]
+	activationMutex.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	return ActivationByName(name)
+}
+
+// Names of every registered function, in registration order
+func RegisteredActivations() []string {
+	activationMutex.RLock()
+	defer activationMutex.RUnlock()
+
+	names := make([]string, len(activationOrder))
+	copy(names, activationOrder)
+	return names
+}
+
+// Draw a function from set (or DefaultActivationSet if set is empty) that is allowed for the node type, with odds
+// proportional to the functions' weights. Uses the package's own seeded generator, see Seed
+func RandomActivation(typ NodeType, set []string) (ActivationFunction, string) {
+	if len(set) == 0 {
+		set = DefaultActivationSet
+	}
+	if len(set) == 0 {
+		set = RegisteredActivations()
+	}
+
+	candidates := make([]*Activation, 0, len(set))
+	total := 0.0
+	for _, name := range set {
+		if a, ok := ActivationByName(name); ok && a.AllowedFor(typ) {
+			candidates = append(candidates, a)
+			total += a.Weight
+		}
+	}
+
+	if len(candidates) == 0 {
+		return IdentityFunc, IdentityStr
+	}
+
+	// Ties are broken by name so the set's order doesn't matter
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	r := float64(randi()) / float64(randModulus) * total
+	for _, a := range candidates {
+		r -= a.Weight
+		if r < 0 {
+			return a.Fn, a.Name
+		}
+	}
+
+	last := candidates[len(candidates)-1]
+	return last.Fn, last.Name
+}
+
+// Any function from the default set that hidden nodes can use, functions restricted to other node types are left out
+func RandomFunc() (ActivationFunction, string) {
+	return RandomActivation(HiddenNode, nil)
+}
@@ -0,0 +1,137 @@
+package neat
+
+import (
+	"math"
+	"math/rand"
+)
+
+// How a node combines its weighted inputs before adding its bias and applying its activation function
+
+type Aggregation uint8
+
+const (
+	SumAggregation Aggregation = iota
+	ProductAggregation
+	MaxAggregation
+	MinAggregation
+	MeanAggregation
+)
+
+const (
+	SumAggregationStr     = "sum"
+	ProductAggregationStr = "product"
+	MaxAggregationStr     = "max"
+	MinAggregationStr     = "min"
+	MeanAggregationStr    = "mean"
+)
+
+var aggregationNames = []string{SumAggregationStr, ProductAggregationStr, MaxAggregationStr, MinAggregationStr, MeanAggregationStr}
+
+// Unknown names (including "") are sums
+func AggregationByName(name string) Aggregation {
+	switch name {
+	case ProductAggregationStr:
+		return ProductAggregation
+	case MaxAggregationStr:
+		return MaxAggregation
+	case MinAggregationStr:
+		return MinAggregation
+	case MeanAggregationStr:
+		return MeanAggregation
+	default:
+		return SumAggregation
+	}
+}
+
+func (a Aggregation) String() string {
+	return aggregationNames[a]
+}
+
+func RandomAggregation() string {
+	return aggregationNames[rand.Intn(len(aggregationNames))]
+}
+
+// Starting value for incremental aggregation
+func (a Aggregation) identity() float64 {
+	switch a {
+	case ProductAggregation:
+		return 1
+	case MaxAggregation:
+		return math.Inf(-1)
+	case MinAggregation:
+		return math.Inf(1)
+	default:
+		return 0
+	}
+}
+
+// Fold one more value into acc
+func (a Aggregation) combine(acc, x float64) float64 {
+	switch a {
+	case ProductAggregation:
+		return acc * x
+	case MaxAggregation:
+		return math.Max(acc, x)
+	case MinAggregation:
+		return math.Min(acc, x)
+	default:
+		return acc + x
+	}
+}
+
+// Result after count values have been combined. No inputs aggregate to 0
+func (a Aggregation) finish(acc float64, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+
+	if a == MeanAggregation {
+		return acc / float64(count)
+	}
+
+	return acc
+}
+
+func (a Aggregation) Aggregate(xs []float64) float64 {
+	acc := a.identity()
+	for _, x := range xs {
+		acc = a.combine(acc, x)
+	}
+
+	return a.finish(acc, len(xs))
+}
+
+// Partial derivatives of Aggregate(xs) with respect to each x, written into partials
+func (a Aggregation) partials(xs []float64, partials []float64) {
+	switch a {
+	case ProductAggregation:
+		// Product of everything else, without dividing so zeros are fine
+		for j := range xs {
+			partials[j] = 1
+			for k, x := range xs {
+				if k != j {
+					partials[j] *= x
+				}
+			}
+		}
+	case MaxAggregation, MinAggregation:
+		// Gradient only flows through the winner
+		best := a.Aggregate(xs)
+		found := false
+		for j, x := range xs {
+			partials[j] = 0
+			if x == best && !found {
+				partials[j] = 1
+				found = true
+			}
+		}
+	case MeanAggregation:
+		for j := range xs {
+			partials[j] = 1 / float64(len(xs))
+		}
+	default:
+		for j := range xs {
+			partials[j] = 1
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package neat
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/TylerLeite/neuro-q/log"
+	"github.com/TylerLeite/neuro-q/ma"
+)
+
+// Gradient-based weight refinement for feed-forward networks. Evolution picks the topology, backprop tunes the weights
+
+type Optimizer uint8
+
+const (
+	SGD Optimizer = iota
+	Adam
+)
+
+type TrainingArgs struct {
+	Optimizer    Optimizer
+	LearningRate float64
+	Epochs       int
+	BatchSize    int // 0 for full-batch training
+
+	// Adam parameters
+	Beta1   float64
+	Beta2   float64
+	Epsilon float64
+
+	// Lamarckian: learned weights are written back into the genome's EdgeGenes.
+	// Baldwinian: learned weights only live in the compiled network and are only used for fitness
+	Lamarckian bool
+}
+
+func DefaultTrainingArgs() TrainingArgs {
+	return TrainingArgs{
+		Optimizer:    Adam,
+		LearningRate: 0.05,
+		Epochs:       100,
+		BatchSize:    0,
+
+		Beta1:   0.9,
+		Beta2:   0.999,
+		Epsilon: 1e-8,
+
+		Lamarckian: true,
+	}
+}
+
+type NotDifferentiableError struct {
+	Msg string
+}
+
+func (e *NotDifferentiableError) Error() string {
+	return e.Msg
+}
+
+// Values cached during the forward pass, needed for the backward pass
+type backpropTrace struct {
+	pre   []float64 // Node input before the activation function, aggregate + bias
+	value []float64 // Node value after the activation function
+}
+
+// Set sensor values from inputs, which has one value per non-bias sensor ordered by node id
+func (n *Network) loadInputs(inputs []float64, values []float64, sensors []int) error {
+	j := 0
+	for _, i := range sensors {
+		if n.Nodes[i].Type == BiasNode {
+			values[i] = 1
+			continue
+		}
+
+		if j >= len(inputs) {
+			return fmt.Errorf("expected more than %d inputs", len(inputs))
+		}
+		values[i] = inputs[j]
+		j += 1
+	}
+
+	if j != len(inputs) {
+		return fmt.Errorf("expected %d inputs but got %d", j, len(inputs))
+	}
+
+	return nil
+}
+
+// Mirrors Node.ForwardPropogate: sensors are fn(input + bias), other nodes are fn(aggregate + bias)
+func (n *Network) tracedForward(inputs []float64, order []int, sensors []int, trace *backpropTrace) error {
+	for i := range trace.pre {
+		trace.pre[i] = 0
+		trace.value[i] = 0
+	}
+
+	if err := n.loadInputs(inputs, trace.pre, sensors); err != nil {
+		return err
+	}
+
+	for _, i := range order {
+		node := n.Nodes[i]
+		if len(node.In) == 0 {
+			trace.pre[i] += node.Bias
+			trace.value[i] = node.fn(trace.pre[i])
+			continue
+		}
+
+		agg := node.Aggregation.identity()
+		count := 0
+		bias := node.Bias
+		for _, edge := range node.In {
+			j := n.nodeIndex[edge.In]
+			if edge.In.Type == BiasNode {
+				bias += trace.value[j] * edge.Weight
+			} else {
+				agg = node.Aggregation.combine(agg, trace.value[j]*edge.Weight)
+				count += 1
+			}
+		}
+
+		trace.pre[i] = node.Aggregation.finish(agg, count) + bias
+		trace.value[i] = node.fn(trace.pre[i])
+	}
+
+	return nil
+}
+
+// Train the network's weights and node biases to minimize mean squared error over the samples. Inputs have one value per non-bias
+// sensor and targets one value per output, both ordered by node id. Returns the mean squared error after training
+func (n *Network) Train(inputs, targets [][]float64, args TrainingArgs) (float64, error) {
+	n.Compile()
+
+	if len(inputs) != len(targets) {
+		return math.NaN(), errors.New("need the same number of inputs and targets")
+	}
+	if len(inputs) == 0 {
+		return math.NaN(), errors.New("need at least one sample to train on")
+	}
+
+	order, err := n.evaluationOrder()
+	if err != nil {
+		return math.NaN(), err
+	}
+
+	sensors := n.sensorIndices()
+	outputs := n.outputIndices()
+
+	// Every node that gradients flow through needs a derivative
+	derivatives := make([]ActivationFunction, len(n.Nodes))
+	for i, node := range n.Nodes {
+		if len(node.In) == 0 {
+			continue
+		}
+
+		fnName := n.DNA.ActivationFunctionNameOf(node.ID)
+		derivative, ok := DerivativeByName(fnName)
+		if !ok {
+			return math.NaN(), &NotDifferentiableError{Msg: fmt.Sprintf("node %s uses %s, which is not differentiable", node.Label, fnName)}
+		}
+		derivatives[i] = derivative
+	}
+
+	edgeIndex := make(map[*Edge]int)
+	for i, edge := range n.Edges {
+		edgeIndex[edge] = i
+	}
+
+	trace := &backpropTrace{
+		pre:   make([]float64, len(n.Nodes)),
+		value: make([]float64, len(n.Nodes)),
+	}
+	dValue := make([]float64, len(n.Nodes))
+
+	// Parameters are every edge weight followed by every node bias
+	nParams := len(n.Edges) + len(n.Nodes)
+	gradients := make([]float64, nParams)
+
+	// Weighted inputs of a node and the aggregation's partial derivatives for them
+	maxIn := 0
+	for _, node := range n.Nodes {
+		if len(node.In) > maxIn {
+			maxIn = len(node.In)
+		}
+	}
+	xs := make([]float64, maxIn)
+	partials := make([]float64, maxIn)
+
+	// Adam moments
+	m := make([]float64, nParams)
+	v := make([]float64, nParams)
+	step := 0
+
+	batchSize := args.BatchSize
+	if batchSize <= 0 || batchSize > len(inputs) {
+		batchSize = len(inputs)
+	}
+
+	for epoch := 0; epoch < args.Epochs; epoch += 1 {
+		for start := 0; start < len(inputs); start += batchSize {
+			end := start + batchSize
+			if end > len(inputs) {
+				end = len(inputs)
+			}
+
+			for i := range gradients {
+				gradients[i] = 0
+			}
+
+			for s := start; s < end; s += 1 {
+				if err := n.tracedForward(inputs[s], order, sensors, trace); err != nil {
+					return math.NaN(), err
+				}
+
+				for i := range dValue {
+					dValue[i] = 0
+				}
+
+				// d/dy of mean squared error, averaged over the batch
+				scale := 2 / float64(len(outputs)*(end-start))
+				for k, i := range outputs {
+					dValue[i] += scale * (trace.value[i] - targets[s][k])
+				}
+
+				for o := len(order) - 1; o >= 0; o -= 1 {
+					i := order[o]
+					node := n.Nodes[i]
+					if len(node.In) == 0 || dValue[i] == 0 {
+						continue
+					}
+
+					dPre := dValue[i] * derivatives[i](trace.pre[i])
+					gradients[len(n.Edges)+i] += dPre
+
+					nx := 0
+					for _, edge := range node.In {
+						if edge.In.Type != BiasNode {
+							xs[nx] = trace.value[n.nodeIndex[edge.In]] * edge.Weight
+							nx += 1
+						}
+					}
+					node.Aggregation.partials(xs[:nx], partials[:nx])
+
+					nx = 0
+					for _, edge := range node.In {
+						j := n.nodeIndex[edge.In]
+						if edge.In.Type == BiasNode {
+							gradients[edgeIndex[edge]] += dPre * trace.value[j]
+							dValue[j] += dPre * edge.Weight
+						} else {
+							gradients[edgeIndex[edge]] += dPre * partials[nx] * trace.value[j]
+							dValue[j] += dPre * partials[nx] * edge.Weight
+							nx += 1
+						}
+					}
+				}
+			}
+
+			step += 1
+			for i := 0; i < nParams; i += 1 {
+				g := gradients[i]
+				if math.IsNaN(g) || math.IsInf(g, 0) {
+					continue
+				}
+
+				// Sensors don't have biases
+				if i >= len(n.Edges) && len(n.Nodes[i-len(n.Edges)].In) == 0 {
+					continue
+				}
+
+				delta := args.LearningRate * g
+				if args.Optimizer == Adam {
+					m[i] = args.Beta1*m[i] + (1-args.Beta1)*g
+					v[i] = args.Beta2*v[i] + (1-args.Beta2)*g*g
+					mHat := m[i] / (1 - math.Pow(args.Beta1, float64(step)))
+					vHat := v[i] / (1 - math.Pow(args.Beta2, float64(step)))
+					delta = args.LearningRate * mHat / (math.Sqrt(vHat) + args.Epsilon)
+				}
+
+				if i < len(n.Edges) {
+					n.Edges[i].Weight -= delta
+				} else {
+					n.Nodes[i-len(n.Edges)].Bias -= delta
+				}
+			}
+		}
+	}
+
+	// Plan has a copy of the old weights
+	n.plan = n.buildPlan()
+
+	if args.Lamarckian {
+		for _, edge := range n.Edges {
+			if edge.Gene != nil {
+				edge.Gene.Weight = edge.Weight
+			}
+		}
+
+		for _, node := range n.Nodes {
+			if nodeGene := n.DNA.Node(node.ID); nodeGene != nil {
+				nodeGene.Bias = node.Bias
+			}
+		}
+	}
+
+	mse := 0.0
+	for s := range inputs {
+		if err := n.tracedForward(inputs[s], order, sensors, trace); err != nil {
+			return math.NaN(), err
+		}
+
+		for k, i := range outputs {
+			mse += (trace.value[i] - targets[s][k]) * (trace.value[i] - targets[s][k])
+		}
+	}
+	mse /= float64(len(inputs) * len(outputs))
+
+	log.Book(fmt.Sprintf("Trained for %d epochs, mse=%.4g\n", args.Epochs, mse), log.DEBUG, log.DEBUG_BACKPROP)
+
+	return mse, nil
+}
+
+// Lamarckian local search: train a copy of the network and keep it if it is more fit
+func BackpropSearch(inputs, targets [][]float64, args TrainingArgs) ma.LocalSearchStrategy {
+	args.Lamarckian = true
+
+	return func(p *ma.Population, o ma.Organism) ma.Organism {
+		trained := o.Copy().(*Network)
+		if _, err := trained.Train(inputs, targets, args); err != nil {
+			log.Book(fmt.Sprintf("Skipping backprop: %s\n", err.Error()), log.DEBUG, log.DEBUG_BACKPROP)
+			return o
+		}
+
+		if p.FitnessOf(trained) > p.FitnessOf(o) {
+			return trained
+		}
+
+		return o
+	}
+}
+
+// Baldwinian learning: fitness is measured after training a copy of the network, but the genome is left untouched.
+// Networks that can't be trained (recurrent or non-differentiable) are evaluated as-is
+func BaldwinianFitness(fitness ma.FitnessFunction, inputs, targets [][]float64, args TrainingArgs) ma.FitnessFunction {
+	args.Lamarckian = false
+
+	return func(o ma.Organism) float64 {
+		trained := o.Copy().(*Network)
+		if _, err := trained.Train(inputs, targets, args); err != nil {
+			return fitness(o)
+		}
+
+		return fitness(trained)
+	}
+}
@@ -0,0 +1,366 @@
+package neat
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/TylerLeite/neuro-q/log"
+	"github.com/TylerLeite/neuro-q/ma"
+)
+
+// Harder problems than XOR for checking that changes to neat and ma still find solutions. Each benchmark is a fixed
+// set of cases with a success criterion, and gets a fitness function and verifier in the style of XorFitness and
+// XorVerify
+
+type Benchmark struct {
+	Name string
+
+	Inputs  [][]float64
+	Targets [][]float64
+
+	// Binary targets pass when the output is on the right side of 0.5, otherwise an output has to be within
+	// Tolerance of the target
+	Binary    bool
+	Tolerance float64
+
+	// How many cases have to pass for the benchmark to count as solved
+	Required int
+}
+
+func (b *Benchmark) InputCount() int {
+	return len(b.Inputs[0])
+}
+
+func (b *Benchmark) OutputCount() int {
+	return len(b.Targets[0])
+}
+
+func (b *Benchmark) passes(output, target []float64) bool {
+	if len(output) != len(target) {
+		return false
+	}
+
+	for i, out := range output {
+		if math.IsNaN(out) {
+			return false
+		}
+
+		if b.Binary {
+			if (out >= 0.5) != (target[i] >= 0.5) {
+				return false
+			}
+		} else if math.Abs(out-target[i]) > b.Tolerance {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Number of cases the network gets right
+func (b *Benchmark) Verify(o ma.Organism) int {
+	n := o.(*Network)
+
+	results, err := n.PredictBatch(b.Inputs)
+	if err != nil {
+		return 0
+	}
+
+	testsPassed := 0
+	for i, result := range results {
+		if b.passes(result, b.Targets[i]) {
+			testsPassed += 1
+		}
+		log.Book(fmt.Sprintf("Inputs were: %v and output was: %v\n", b.Inputs[i], result), log.DEBUG, log.DEBUG_PROPAGATION)
+	}
+
+	return testsPassed
+}
+
+func (b *Benchmark) Solved(o ma.Organism) bool {
+	return b.Verify(o) >= b.Required
+}
+
+// Number of cases minus the summed squared error, or +Inf once the benchmark is solved (same as XorFitness)
+func (b *Benchmark) Fitness(o ma.Organism) float64 {
+	n := o.(*Network)
+
+	results, err := n.PredictBatch(b.Inputs)
+	if err != nil {
+		return math.Inf(-1)
+	}
+
+	fitness := float64(len(b.Inputs))
+	testsPassed := 0
+	for i, result := range results {
+		if b.passes(result, b.Targets[i]) {
+			testsPassed += 1
+		}
+
+		for j, out := range result {
+			diff := out - b.Targets[i][j]
+			if math.IsNaN(diff) {
+				diff = 1
+			}
+			fitness -= diff * diff / float64(len(result))
+		}
+	}
+
+	if testsPassed >= b.Required {
+		return math.Inf(1)
+	}
+
+	return fitness
+}
+
+func bit(x, i int) float64 {
+	return float64((x >> i) & 1)
+}
+
+// Output 1 when an odd number of the n inputs are 1. Parity(2) is XOR
+func Parity(n int) *Benchmark {
+	b := &Benchmark{Name: fmt.Sprintf("%d-bit parity", n), Binary: true}
+
+	for x := 0; x < 1<<n; x += 1 {
+		in := make([]float64, n)
+		ones := 0
+		for i := 0; i < n; i += 1 {
+			in[i] = bit(x, i)
+			ones += int(in[i])
+		}
+
+		b.Inputs = append(b.Inputs, in)
+		b.Targets = append(b.Targets, []float64{float64(ones % 2)})
+	}
+
+	b.Required = len(b.Inputs)
+	return b
+}
+
+// The first addressBits inputs pick which of the 2^addressBits data inputs to output. Multiplexer(2) is the
+// 6-multiplexer, Multiplexer(3) the 11-multiplexer
+func Multiplexer(addressBits int) *Benchmark {
+	dataBits := 1 << addressBits
+	n := addressBits + dataBits
+	b := &Benchmark{Name: fmt.Sprintf("%d-multiplexer", n), Binary: true}
+
+	for x := 0; x < 1<<n; x += 1 {
+		in := make([]float64, n)
+		for i := 0; i < n; i += 1 {
+			in[i] = bit(x, i)
+		}
+
+		address := x & (dataBits - 1)
+		b.Inputs = append(b.Inputs, in)
+		b.Targets = append(b.Targets, []float64{in[addressBits+address]})
+	}
+
+	b.Required = len(b.Inputs)
+	return b
+}
+
+// Two interleaved spirals (Lang & Witbrock 1988), 97 points each, scaled into [-1, 1]. Solved at 95% of the points,
+// since getting every one is very hard without a lot of hidden structure
+func TwoSpirals() *Benchmark {
+	b := &Benchmark{Name: "two-spirals", Binary: true}
+
+	for i := 0; i <= 96; i += 1 {
+		angle := float64(i) * math.Pi / 16
+		radius := (104 - float64(i)) / 104
+
+		x, y := radius*math.Sin(angle), radius*math.Cos(angle)
+		b.Inputs = append(b.Inputs, []float64{x, y}, []float64{-x, -y})
+		b.Targets = append(b.Targets, []float64{1}, []float64{0})
+	}
+
+	b.Required = int(math.Ceil(0.95 * float64(len(b.Inputs))))
+	return b
+}
+
+// Points inside a circle of radius 0.5 are 1, points in the ring between 0.7 and 1 are 0. The points are random but
+// always the same ones. Solved at 95%
+func ConcentricCircles() *Benchmark {
+	b := &Benchmark{Name: "concentric circles", Binary: true}
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 100; i += 1 {
+		angle := r.Float64() * 2 * math.Pi
+
+		radius, target := 0.5*math.Sqrt(r.Float64()), 1.0
+		if i%2 == 1 {
+			radius, target = 0.7+0.3*r.Float64(), 0
+		}
+
+		b.Inputs = append(b.Inputs, []float64{radius * math.Cos(angle), radius * math.Sin(angle)})
+		b.Targets = append(b.Targets, []float64{target})
+	}
+
+	b.Required = int(math.Ceil(0.95 * float64(len(b.Inputs))))
+	return b
+}
+
+// One period of a sine wave, squashed into [0, 1] so sigmoid outputs can reach it. Every point has to be within 0.05
+func SineRegression() *Benchmark {
+	b := &Benchmark{Name: "sine regression", Tolerance: 0.05}
+
+	for i := 0; i < 50; i += 1 {
+		x := 2*float64(i)/49 - 1
+		b.Inputs = append(b.Inputs, []float64{x})
+		b.Targets = append(b.Targets, []float64{(math.Sin(math.Pi*x) + 1) / 2})
+	}
+
+	b.Required = len(b.Inputs)
+	return b
+}
+
+func Benchmarks() []*Benchmark {
+	return []*Benchmark{
+		Parity(3),
+		Multiplexer(2),
+		Multiplexer(3),
+		TwoSpirals(),
+		ConcentricCircles(),
+		SineRegression(),
+	}
+}
+
+type BenchmarkResult struct {
+	Name string
+
+	Runs   int
+	Solved int
+
+	// Averaged over the runs that solved the benchmark
+	MeanGenerations float64
+	MeanEvaluations float64
+}
+
+func (r BenchmarkResult) SuccessRate() float64 {
+	if r.Runs == 0 {
+		return 0
+	}
+	return float64(r.Solved) / float64(r.Runs)
+}
+
+func (r BenchmarkResult) String() string {
+	return fmt.Sprintf("%s: solved %d/%d (%.0f%%), %.1f generations and %.0f evaluations on average",
+		r.Name, r.Solved, r.Runs, 100*r.SuccessRate(), r.MeanGenerations, r.MeanEvaluations)
+}
+
+// Population set up like XorEvolution's
+func (b *Benchmark) population(fitness ma.FitnessFunction) *ma.Population {
+	seedGenome := NewGenome(b.InputCount(), b.OutputCount(), true, -5, 5)
+	seedGenome.MutationRatios = map[ma.MutationType]float64{
+		MutationAddConnection:     0.05,
+		MutationAddNode:           0.03,
+		MutationMutateWeights:     0.85,
+		MutationMutateBias:        0.05,
+		MutationChangeAggregation: 0.02,
+	}
+	seedNetwork := NewNetwork(seedGenome, nil)
+
+	p := ma.NewPopulation(ma.Organism(seedNetwork), fitness)
+	seedNetwork.Population = p
+
+	p.Size = 150
+	p.DistanceThreshold = 2.0
+	p.CullingPercent = 0.5
+	p.RecombinationPercent = 0.8
+	p.MinimumEntropy = 0.35
+	p.LocalSearchGenerations = 8
+	p.DropoffAge = 15
+
+	p.Cs = []float64{1, 1, 0.4, 0}
+
+	return p
+}
+
+// One evolution run, returns the generation it was solved in (0 if it wasn't) and how many times fitness was evaluated
+// until then
+func (b *Benchmark) run(seed int64, maxGenerations int, setup func(*ma.Population)) (int, int64, error) {
+	ResetInnovationHistory()
+	Seed(seed)
+	rand.Seed(seed)
+
+	var evaluations int64
+	fitness := func(o ma.Organism) float64 {
+		atomic.AddInt64(&evaluations, 1)
+		return b.Fitness(o)
+	}
+
+	p := b.population(fitness)
+	if setup != nil {
+		setup(p)
+	}
+	p.Generate()
+
+	speciesTargetMin := 7
+	speciesTargetMax := 13
+	distanceThresholdEpsilon := 0.1
+
+	for generation := 1; generation <= maxGenerations; generation += 1 {
+		championDNAs, _, err := p.Epoch()
+		if err != nil {
+			return 0, atomic.LoadInt64(&evaluations), err
+		}
+
+		if len(p.Species) > speciesTargetMax {
+			p.DistanceThreshold += distanceThresholdEpsilon
+		} else if len(p.Species) < speciesTargetMin {
+			p.DistanceThreshold -= distanceThresholdEpsilon
+		}
+
+		for _, dna := range championDNAs {
+			if b.Solved(NewNetwork(dna.(*Genome), p)) {
+				return generation, atomic.LoadInt64(&evaluations), nil
+			}
+		}
+	}
+
+	return 0, atomic.LoadInt64(&evaluations), nil
+}
+
+// Evolve the benchmark once per seed, for at most maxGenerations each. setup can change the population's settings
+// before it is generated, and may be nil. Runs that go extinct count as failures
+func (b *Benchmark) Run(seeds []int64, maxGenerations int, setup func(*ma.Population)) BenchmarkResult {
+	result := BenchmarkResult{Name: b.Name, Runs: len(seeds)}
+
+	totalGenerations, totalEvaluations := 0, int64(0)
+	for _, seed := range seeds {
+		generation, evaluations, err := b.run(seed, maxGenerations, setup)
+		if err != nil {
+			log.Book(fmt.Sprintf("%s, seed %d: %s\n", b.Name, seed, err), log.DEBUG, log.DEBUG_EPOCH)
+			continue
+		}
+
+		if generation > 0 {
+			result.Solved += 1
+			totalGenerations += generation
+			totalEvaluations += evaluations
+		}
+	}
+
+	if result.Solved > 0 {
+		result.MeanGenerations = float64(totalGenerations) / float64(result.Solved)
+		result.MeanEvaluations = float64(totalEvaluations) / float64(result.Solved)
+	}
+
+	return result
+}
+
+// Run every benchmark over seeds 1..k
+func RunBenchmarks(benchmarks []*Benchmark, k, maxGenerations int, setup func(*ma.Population)) []BenchmarkResult {
+	seeds := make([]int64, k)
+	for i := range seeds {
+		seeds[i] = int64(i + 1)
+	}
+
+	results := make([]BenchmarkResult, len(benchmarks))
+	for i, b := range benchmarks {
+		results[i] = b.Run(seeds, maxGenerations, setup)
+	}
+
+	return results
+}
@@ -0,0 +1,273 @@
+package neat
+
+import (
+	"fmt"
+	"go/format"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Turn a network into standalone Go source, so champions can be used without importing any of this. The generated
+// function does the same arithmetic in the same order as the evaluation plan, so it gives the same outputs as Predict
+// (up to rounding on platforms where the compiler fuses multiplies and adds)
+
+type CodegenError struct {
+	Msg string
+}
+
+func (e *CodegenError) Error() string {
+	return e.Msg
+}
+
+// Go literal for a float, exact when parsed back
+func goFloat(x float64) string {
+	switch {
+	case math.IsNaN(x):
+		return "math.NaN()"
+	case math.IsInf(x, 1):
+		return "math.Inf(1)"
+	case math.IsInf(x, -1):
+		return "math.Inf(-1)"
+	}
+
+	s := strconv.FormatFloat(x, 'g', -1, 64)
+	if x < 0 || (x == 0 && math.Signbit(x)) {
+		return "(" + s + ")"
+	}
+
+	return s
+}
+
+// Name of the generated helper for an activation function, e.g. evalNEATSigmoid for Eval and NEATSigmoid
+func helperName(funcName, activation string) string {
+	var b strings.Builder
+	for i, r := range funcName {
+		if i == 0 {
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	upper := true
+	for _, r := range activation {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Statements that compute every node into v, in plan order. inputs are the names of the sensors' inputs
+func (n *Network) goBody(funcName string, inputs []string, helpers map[string]string) (string, error) {
+	p := n.plan
+
+	sensorInput := make(map[int]string)
+	for j, i := range p.sensors {
+		sensorInput[i] = inputs[j]
+	}
+
+	var b strings.Builder
+	for k, i := range p.order {
+		node := n.Nodes[i]
+
+		activation := n.DNA.ActivationFunctionNameOf(node.ID)
+		a, ok := ActivationByName(activation)
+		if !ok {
+			activation = IdentityStr
+			a, _ = ActivationByName(IdentityStr)
+		}
+		if a.Source == "" {
+			return "", &CodegenError{Msg: fmt.Sprintf("activation function %q has no Go source", activation)}
+		}
+		helper := helperName(funcName, activation)
+		helpers[helper] = a.Source
+
+		start, end := p.inStart[k], p.inStart[k+1]
+		if start == end {
+			input, ok := sensorInput[i]
+			if !ok {
+				input = "0"
+			}
+			b.WriteString(fmt.Sprintf("\tv[%d] = %s(%s + %s) // node %d\n", i, helper, input, goFloat(p.biases[k]), node.ID))
+			continue
+		}
+
+		// Same order of operations as evaluationPlan.run
+		terms := make([]string, 0)
+		bias := goFloat(p.biases[k])
+		for e := start; e < end; e += 1 {
+			term := fmt.Sprintf("v[%d]*%s", p.sources[e], goFloat(p.weights[e]))
+			if p.isBias[e] {
+				bias = fmt.Sprintf("%s + %s", bias, term)
+			} else {
+				terms = append(terms, term)
+			}
+		}
+
+		var agg string
+		if len(terms) == 0 {
+			agg = "0"
+		} else {
+			switch p.aggs[k] {
+			case ProductAggregation:
+				agg = "(" + strings.Join(terms, ") * (") + ")"
+			case MaxAggregation, MinAggregation:
+				fn := "math.Max"
+				if p.aggs[k] == MinAggregation {
+					fn = "math.Min"
+				}
+				agg = terms[0]
+				for _, term := range terms[1:] {
+					agg = fmt.Sprintf("%s(%s, %s)", fn, agg, term)
+				}
+			case MeanAggregation:
+				agg = fmt.Sprintf("(%s) / %d", strings.Join(terms, " + "), len(terms))
+			default:
+				agg = strings.Join(terms, " + ")
+			}
+		}
+
+		b.WriteString(fmt.Sprintf("\tv[%d] = %s((%s) + (%s)) // node %d\n", i, helper, agg, bias, node.ID))
+	}
+
+	return b.String(), nil
+}
+
+// Write a Go file for package pkg with `func <funcName>(in []float64) []float64`, which takes the same inputs as
+// Predict (no bias). Recurrent networks also get a <funcName>State type with Step and Reset, and funcName relaxes
+// from a blank state like Predict does
+func (n *Network) WriteGo(w io.Writer, pkg, funcName string) error {
+	n.Compile()
+	p := n.plan
+
+	inputs := make([]string, len(p.sensors))
+	j := 0
+	for k, i := range p.sensors {
+		if n.Nodes[i].Type == BiasNode {
+			inputs[k] = "1"
+		} else {
+			inputs[k] = fmt.Sprintf("in[%d]", j)
+			j += 1
+		}
+	}
+	nInputs := j
+
+	helpers := make(map[string]string)
+	body, err := n.goBody(funcName, inputs, helpers)
+	if err != nil {
+		return err
+	}
+
+	outputs := make([]string, len(p.outputs))
+	for j, i := range p.outputs {
+		outputs[j] = fmt.Sprintf("v[%d]", i)
+	}
+	ret := fmt.Sprintf("\treturn []float64{%s}\n", strings.Join(outputs, ", "))
+
+	var b strings.Builder
+	b.WriteString("// Code generated by neat.WriteGo. DO NOT EDIT.\n\n")
+	b.WriteString(fmt.Sprintf("package %s\n\n", pkg))
+
+	var code strings.Builder
+	check := fmt.Sprintf("\tif len(in) != %d {\n\t\tpanic(\"%s: expected %d inputs\")\n\t}\n\n", nInputs, funcName, nInputs)
+
+	if p.recurrent {
+		state := funcName + "State"
+		code.WriteString(fmt.Sprintf("// Node values of a recurrent network, carried between steps\ntype %s struct {\n\tv [%d]float64\n}\n\n", state, len(n.Nodes)))
+		code.WriteString(fmt.Sprintf("func (s *%s) Reset() {\n\ts.v = [%d]float64{}\n}\n\n", state, len(n.Nodes)))
+		code.WriteString(fmt.Sprintf("// Advance the network by one time step\nfunc (s *%s) Step(in []float64) []float64 {\n", state))
+		code.WriteString(check)
+		code.WriteString("\tv := &s.v\n\n")
+		code.WriteString(body)
+		code.WriteString("\n" + ret + "}\n\n")
+
+		code.WriteString(fmt.Sprintf("// Outputs after %d steps from a blank state\nfunc %s(in []float64) []float64 {\n", n.relaxationSteps(), funcName))
+		code.WriteString(fmt.Sprintf("\tvar s %s\n\tvar out []float64\n", state))
+		code.WriteString(fmt.Sprintf("\tfor step := 0; step < %d; step++ {\n\t\tout = s.Step(in)\n\t}\n\n\treturn out\n}\n\n", n.relaxationSteps()))
+	} else {
+		code.WriteString(fmt.Sprintf("func %s(in []float64) []float64 {\n", funcName))
+		code.WriteString(check)
+		code.WriteString(fmt.Sprintf("\tvar v [%d]float64\n\n", len(n.Nodes)))
+		code.WriteString(body)
+		code.WriteString("\n" + ret + "}\n\n")
+	}
+
+	names := make([]string, 0, len(helpers))
+	for name := range helpers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		code.WriteString(fmt.Sprintf("func %s(x float64) float64 {\n\t%s\n}\n\n", name, strings.ReplaceAll(helpers[name], "\n", "\n\t")))
+	}
+
+	if strings.Contains(code.String(), "math.") {
+		b.WriteString("import \"math\"\n\n")
+	}
+	b.WriteString(code.String())
+
+	formatted, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return &CodegenError{Msg: fmt.Sprintf("generated code doesn't parse: %v", err)}
+	}
+
+	_, err = w.Write(formatted)
+	return err
+}
+
+// Write a test for the code from WriteGo, checking it against what Predict gives for each of the inputs
+func (n *Network) WriteGoTest(w io.Writer, pkg, funcName string, inputs [][]float64) error {
+	var b strings.Builder
+	b.WriteString("// Code generated by neat.WriteGoTest. DO NOT EDIT.\n\n")
+	b.WriteString(fmt.Sprintf("package %s\n\n", pkg))
+	b.WriteString("import (\n\t\"math\"\n\t\"testing\"\n)\n\n")
+	b.WriteString(fmt.Sprintf("func Test%s%s(t *testing.T) {\n", strings.ToUpper(funcName[:1]), funcName[1:]))
+	b.WriteString("\tcases := []struct {\n\t\tin  []float64\n\t\tout []float64\n\t}{\n")
+
+	floats := func(xs []float64) string {
+		strs := make([]string, len(xs))
+		for i, x := range xs {
+			strs[i] = goFloat(x)
+		}
+		return strings.Join(strs, ", ")
+	}
+
+	for _, input := range inputs {
+		expected, err := n.Predict(input)
+		if err != nil {
+			return err
+		}
+
+		b.WriteString(fmt.Sprintf("\t\t{[]float64{%s}, []float64{%s}},\n", floats(input), floats(expected)))
+	}
+
+	b.WriteString("\t}\n\n")
+	b.WriteString("\tfor _, c := range cases {\n")
+	b.WriteString(fmt.Sprintf("\t\tgot := %s(c.in)\n", funcName))
+	b.WriteString("\t\tfor i := range c.out {\n")
+	b.WriteString("\t\t\tsame := got[i] == c.out[i] || (math.IsNaN(got[i]) && math.IsNaN(c.out[i]))\n")
+	b.WriteString("\t\t\tif !same && math.Abs(got[i]-c.out[i]) > 1e-9*math.Max(1, math.Abs(c.out[i])) {\n")
+	b.WriteString(fmt.Sprintf("\t\t\t\tt.Errorf(\"%s(%%v)[%%d] = %%g, expected %%g\", c.in, i, got[i], c.out[i])\n", funcName))
+	b.WriteString("\t\t\t}\n\t\t}\n\t}\n}\n")
+
+	formatted, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return &CodegenError{Msg: fmt.Sprintf("generated test doesn't parse: %v", err)}
+	}
+
+	_, err = w.Write(formatted)
+	return err
+}
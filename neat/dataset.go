@@ -0,0 +1,441 @@
+package neat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+
+	"github.com/TylerLeite/neuro-q/ma"
+)
+
+// Supervised learning tasks built from a table of examples, as an alternative to writing a fitness function like
+// XorFitness by hand
+
+type TaskKind uint8
+
+const (
+	Regression     TaskKind = iota // Outputs are the targets, scored by mean squared error
+	Classification                 // Softmax over outputs gives class probabilities, scored by cross-entropy
+)
+
+type DatasetError struct {
+	Msg string
+}
+
+func (e *DatasetError) Error() string {
+	return e.Msg
+}
+
+type Dataset struct {
+	Features [][]float64
+	Targets  [][]float64 // One-hot for classification
+
+	FeatureNames []string
+	TargetNames  []string
+	Classes      []string // Class labels, in one-hot order. Classification only
+}
+
+func (d *Dataset) Len() int {
+	return len(d.Features)
+}
+
+// Subset of the examples, in the given order. Rows are shared with d
+func (d *Dataset) subset(indices []int) *Dataset {
+	sub := &Dataset{
+		Features: make([][]float64, len(indices)),
+		Targets:  make([][]float64, len(indices)),
+
+		FeatureNames: d.FeatureNames,
+		TargetNames:  d.TargetNames,
+		Classes:      d.Classes,
+	}
+
+	for i, j := range indices {
+		sub.Features[i] = d.Features[j]
+		sub.Targets[i] = d.Targets[j]
+	}
+
+	return sub
+}
+
+// Shuffle with the seed and cut into train, validation and test sets. Whatever is left after the train and
+// validation fractions is the test set
+func (d *Dataset) Split(trainFraction, validationFraction float64, seed int64) (*Dataset, *Dataset, *Dataset) {
+	order := rand.New(rand.NewSource(seed)).Perm(d.Len())
+
+	// Fractions past 1 (or under 0) just take everything (or nothing)
+	clamp := func(n int) int {
+		return int(math.Min(math.Max(float64(n), 0), float64(d.Len())))
+	}
+
+	nTrain := clamp(int(math.Round(trainFraction * float64(d.Len()))))
+	nValidation := clamp(int(math.Round(validationFraction * float64(d.Len()))))
+	if nTrain+nValidation > d.Len() {
+		nValidation = d.Len() - nTrain
+	}
+
+	return d.subset(order[:nTrain]), d.subset(order[nTrain : nTrain+nValidation]), d.subset(order[nTrain+nValidation:])
+}
+
+// Read a CSV file where every column but the targets is a numeric feature. For regression the target columns have
+// to be numeric too, for classification there should be one target column of labels, which get one-hot encoded.
+// With a header, the first row names the columns
+func ReadCSV(r io.Reader, kind TaskKind, header bool, targetColumns []int) (*Dataset, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, &DatasetError{Msg: "no rows in CSV"}
+	}
+
+	if kind == Classification && len(targetColumns) != 1 {
+		return nil, &DatasetError{Msg: "classification needs exactly one target column"}
+	}
+
+	isTarget := make(map[int]bool)
+	for _, column := range targetColumns {
+		if column < 0 || column >= len(rows[0]) {
+			return nil, &DatasetError{Msg: fmt.Sprintf("target column %d is out of range", column)}
+		}
+		isTarget[column] = true
+	}
+
+	d := &Dataset{
+		Features: make([][]float64, 0, len(rows)),
+		Targets:  make([][]float64, 0, len(rows)),
+	}
+
+	if header {
+		for column, name := range rows[0] {
+			if isTarget[column] {
+				d.TargetNames = append(d.TargetNames, name)
+			} else {
+				d.FeatureNames = append(d.FeatureNames, name)
+			}
+		}
+		rows = rows[1:]
+	}
+
+	labels := make([]string, 0, len(rows))
+	classIndex := make(map[string]int)
+
+	for line, row := range rows {
+		features := make([]float64, 0, len(row)-len(targetColumns))
+		targets := make([]float64, 0, len(targetColumns))
+
+		for column, cell := range row {
+			if isTarget[column] && kind == Classification {
+				labels = append(labels, cell)
+				if _, ok := classIndex[cell]; !ok {
+					classIndex[cell] = len(d.Classes)
+					d.Classes = append(d.Classes, cell)
+				}
+				continue
+			}
+
+			x, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				return nil, &DatasetError{Msg: fmt.Sprintf("row %d, column %d: %q isn't a number", line+1, column+1, cell)}
+			}
+
+			if isTarget[column] {
+				targets = append(targets, x)
+			} else {
+				features = append(features, x)
+			}
+		}
+
+		d.Features = append(d.Features, features)
+		d.Targets = append(d.Targets, targets)
+	}
+
+	if kind == Classification {
+		for i, label := range labels {
+			d.Targets[i] = make([]float64, len(d.Classes))
+			d.Targets[i][classIndex[label]] = 1
+		}
+	}
+
+	return d, nil
+}
+
+func ReadCSVFile(fName string, kind TaskKind, header bool, targetColumns []int) (*Dataset, error) {
+	file, err := os.Open(fName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadCSV(file, kind, header, targetColumns)
+}
+
+// Shifts and scales features to mean 0, standard deviation 1, using statistics from the data it was fit on
+type Normalizer struct {
+	Mean []float64
+	Std  []float64
+}
+
+func FitNormalizer(d *Dataset) *Normalizer {
+	if d.Len() == 0 {
+		return &Normalizer{}
+	}
+
+	nFeatures := len(d.Features[0])
+	norm := &Normalizer{
+		Mean: make([]float64, nFeatures),
+		Std:  make([]float64, nFeatures),
+	}
+
+	for _, features := range d.Features {
+		for j, x := range features {
+			norm.Mean[j] += x
+		}
+	}
+	for j := range norm.Mean {
+		norm.Mean[j] /= float64(d.Len())
+	}
+
+	for _, features := range d.Features {
+		for j, x := range features {
+			norm.Std[j] += (x - norm.Mean[j]) * (x - norm.Mean[j])
+		}
+	}
+	for j := range norm.Std {
+		norm.Std[j] = math.Sqrt(norm.Std[j] / float64(d.Len()))
+
+		// Constant features just get centered
+		if norm.Std[j] == 0 {
+			norm.Std[j] = 1
+		}
+	}
+
+	return norm
+}
+
+func (norm *Normalizer) Normalize(features []float64) []float64 {
+	out := make([]float64, len(features))
+	for j, x := range features {
+		out[j] = x
+		if j < len(norm.Mean) {
+			out[j] = (x - norm.Mean[j]) / norm.Std[j]
+		}
+	}
+
+	return out
+}
+
+// Copy of the dataset with normalized features
+func (norm *Normalizer) Apply(d *Dataset) *Dataset {
+	out := &Dataset{
+		Features: make([][]float64, d.Len()),
+		Targets:  d.Targets,
+
+		FeatureNames: d.FeatureNames,
+		TargetNames:  d.TargetNames,
+		Classes:      d.Classes,
+	}
+
+	for i, features := range d.Features {
+		out.Features[i] = norm.Normalize(features)
+	}
+
+	return out
+}
+
+// A dataset split three ways, normalized with statistics from the train set. Evolution only ever sees the train set
+type SupervisedTask struct {
+	Kind TaskKind
+
+	Train      *Dataset
+	Validation *Dataset
+	Test       *Dataset
+
+	Normalizer *Normalizer
+}
+
+func NewSupervisedTask(d *Dataset, kind TaskKind, trainFraction, validationFraction float64, seed int64) *SupervisedTask {
+	train, validation, test := d.Split(trainFraction, validationFraction, seed)
+	norm := FitNormalizer(train)
+
+	return &SupervisedTask{
+		Kind: kind,
+
+		Train:      norm.Apply(train),
+		Validation: norm.Apply(validation),
+		Test:       norm.Apply(test),
+
+		Normalizer: norm,
+	}
+}
+
+func softmax(xs []float64) []float64 {
+	max := math.Inf(-1)
+	for _, x := range xs {
+		max = math.Max(max, x)
+	}
+
+	out := make([]float64, len(xs))
+	total := 0.0
+	for i, x := range xs {
+		out[i] = math.Exp(x - max)
+		total += out[i]
+	}
+	for i := range out {
+		out[i] /= total
+	}
+
+	return out
+}
+
+// Class probabilities from network outputs. A single output for two classes is the probability of the second one,
+// otherwise there's one output per class and they go through a softmax
+func classProbabilities(outputs []float64, nClasses int) []float64 {
+	if len(outputs) == 1 && nClasses == 2 {
+		p := math.Min(math.Max(outputs[0], 0), 1)
+		return []float64{1 - p, p}
+	}
+
+	return softmax(outputs)
+}
+
+func argmax(xs []float64) int {
+	best := 0
+	for i, x := range xs {
+		if x > xs[best] {
+			best = i
+		}
+	}
+
+	return best
+}
+
+// Mean loss (MSE or cross-entropy) of the network on a dataset, and for classification the fraction it gets right
+func (t *SupervisedTask) Evaluate(n *Network, d *Dataset) (float64, float64) {
+	if d.Len() == 0 {
+		return math.NaN(), math.NaN()
+	}
+
+	outputs, err := n.PredictBatch(d.Features)
+	if err != nil {
+		return math.Inf(1), 0
+	}
+
+	loss := 0.0
+	correct := 0
+	for i, out := range outputs {
+		target := d.Targets[i]
+
+		if t.Kind == Regression {
+			if len(out) != len(target) {
+				// Wrong number of outputs for the targets
+				return math.Inf(1), 0
+			}
+
+			for j := range target {
+				diff := out[j] - target[j]
+				loss += diff * diff / float64(len(target))
+			}
+			continue
+		}
+
+		probabilities := classProbabilities(out, len(target))
+		if len(probabilities) != len(target) {
+			// Wrong number of outputs for the classes
+			return math.Inf(1), 0
+		}
+
+		class := argmax(target)
+		loss -= math.Log(math.Max(probabilities[class], 1e-12))
+
+		if argmax(probabilities) == class {
+			correct += 1
+		}
+	}
+
+	if math.IsNaN(loss) {
+		loss = math.Inf(1)
+	}
+
+	return loss / float64(d.Len()), float64(correct) / float64(d.Len())
+}
+
+// Fitness on the train set, 1/(1 + loss), so higher is better and it stays positive
+func (t *SupervisedTask) Fitness(o ma.Organism) float64 {
+	loss, _ := t.Evaluate(o.(*Network), t.Train)
+	return 1 / (1 + loss)
+}
+
+// How a network does on each part of the dataset. Accuracies are 0 for regression
+type GeneralizationReport struct {
+	Epoch int
+
+	TrainLoss      float64
+	ValidationLoss float64
+	TestLoss       float64
+
+	TrainAccuracy      float64
+	ValidationAccuracy float64
+	TestAccuracy       float64
+}
+
+func (r GeneralizationReport) String() string {
+	return fmt.Sprintf("epoch %d: loss %.4g / %.4g / %.4g, accuracy %.3g / %.3g / %.3g (train / validation / test)",
+		r.Epoch, r.TrainLoss, r.ValidationLoss, r.TestLoss, r.TrainAccuracy, r.ValidationAccuracy, r.TestAccuracy)
+}
+
+func (t *SupervisedTask) Report(epoch int, n *Network) GeneralizationReport {
+	r := GeneralizationReport{Epoch: epoch}
+	r.TrainLoss, r.TrainAccuracy = t.Evaluate(n, t.Train)
+	r.ValidationLoss, r.ValidationAccuracy = t.Evaluate(n, t.Validation)
+	r.TestLoss, r.TestAccuracy = t.Evaluate(n, t.Test)
+
+	return r
+}
+
+// Run a population (which should be using t.Fitness) for some epochs, reporting on the best champion after each.
+// Returns the champion with the lowest validation loss seen, and every report
+func (t *SupervisedTask) Evolve(p *ma.Population, epochs int, report func(GeneralizationReport)) (*Network, []GeneralizationReport, error) {
+	reports := make([]GeneralizationReport, 0, epochs)
+
+	var best *Network
+	bestValidationLoss := math.Inf(1)
+
+	for epoch := 1; epoch <= epochs; epoch += 1 {
+		championDNAs, championFitnesses, err := p.Epoch()
+		if err != nil {
+			return best, reports, err
+		}
+
+		if len(championDNAs) == 0 {
+			continue
+		}
+
+		// Champion genomes still belong to the population, later epochs keep mutating them
+		champion := NewNetwork(championDNAs[argmax(championFitnesses)].(*Genome).Copy().(*Genome), p)
+
+		r := t.Report(epoch, champion)
+		reports = append(reports, r)
+		if report != nil {
+			report(r)
+		}
+
+		// Without a validation set, fall back on the train loss
+		loss := r.ValidationLoss
+		if math.IsNaN(loss) {
+			loss = r.TrainLoss
+		}
+
+		if best == nil || loss < bestValidationLoss {
+			best = champion
+			bestValidationLoss = loss
+		}
+	}
+
+	return best, reports, nil
+}
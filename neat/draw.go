@@ -61,14 +61,32 @@ var alphabet = map[string][2]int{
 	")": {21, 0},
 }
 
+// Border color shows how a node aggregates its inputs
+var aggregationColors = map[Aggregation]color.RGBA{
+	SumAggregation:     {R: math.MaxUint8, G: 0, B: 0, A: math.MaxUint8},
+	ProductAggregation: {R: 0, G: 0, B: math.MaxUint8, A: math.MaxUint8},
+	MaxAggregation:     {R: 0, G: 160, B: 0, A: math.MaxUint8},
+	MinAggregation:     {R: math.MaxUint8, G: 128, B: 0, A: math.MaxUint8},
+	MeanAggregation:    {R: 128, G: 0, B: 128, A: math.MaxUint8},
+}
+
 // TODO: draw activation function
-func drawNode(x, y int, label string, font image.Image, canvas *image.RGBA) {
+func drawNode(x, y int, label string, bias float64, aggregation Aggregation, font image.Image, canvas *image.RGBA) {
+	// Tint the inside the same way as edges, yellow = negative bias, purple = positive
+	tint := math.Min(math.Abs(bias), 1) * 0.5 * float64(math.MaxUint8)
+	fill := color.RGBA{R: math.MaxUint8, G: math.MaxUint8, B: math.MaxUint8, A: math.MaxUint8}
+	if bias > 0 {
+		fill.G -= uint8(tint)
+	} else {
+		fill.B -= uint8(tint)
+	}
+
 	for i := 0; i < 15; i += 1 {
 		for j := 0; j < 9; j += 1 {
 			if (j == 0 || j == 8) || (i == 0 || i == 14) {
-				canvas.Set(x+i, y+j, color.RGBA{R: math.MaxUint8, G: 0, B: 0, A: math.MaxUint8})
+				canvas.Set(x+i, y+j, aggregationColors[aggregation])
 			} else {
-				canvas.Set(x+i, y+j, color.RGBA{R: math.MaxUint8, G: math.MaxUint8, B: math.MaxUint8, A: math.MaxUint8})
+				canvas.Set(x+i, y+j, fill)
 			}
 		}
 	}
@@ -157,7 +175,7 @@ func (n *Network) SeparateIntoLayers() [][]*Node {
 
 	inputLayer := make([]*Node, 0)
 	for _, nodeId := range n.DNA.SensorNodes {
-		sensorNode := n.Nodes[nodeId]
+		sensorNode := n.NodeById(nodeId)
 		visited[sensorNode] = true
 		inputLayer = append(inputLayer, sensorNode)
 	}
@@ -165,7 +183,7 @@ func (n *Network) SeparateIntoLayers() [][]*Node {
 
 	outputLayer := make([]*Node, 0)
 	for _, nodeId := range n.DNA.OutputNodes {
-		outputNode := n.Nodes[nodeId]
+		outputNode := n.NodeById(nodeId)
 		visited[outputNode] = true
 		outputLayer = append(outputLayer, outputNode)
 	}
@@ -295,7 +313,7 @@ func (n *Network) SeparateIntoLayers() [][]*Node {
 	return layers
 }
 
-// TODO: label nodes with activation function, support nodeId > 999
+// TODO: label nodes with activation function, support nodeId > 999. WriteSVG and WriteDOT already do both
 func (n *Network) Draw(fName string) error {
 	layers := n.SeparateIntoLayers()
 	maxDepth := len(layers)
@@ -360,7 +378,7 @@ func (n *Network) Draw(fName string) error {
 	for _, node := range n.Nodes {
 		xOffset, yOffset := nodePosition(node)
 
-		drawNode(xOffset, yOffset, node.Label, font, canvas)
+		drawNode(xOffset, yOffset, node.Label, node.Bias, node.Aggregation, font, canvas)
 	}
 
 	file, _ := os.Create(fName)
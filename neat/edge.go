@@ -9,6 +9,8 @@ type Edge struct {
 	Enabled bool
 
 	Label string
+
+	Gene *EdgeGene // The connection gene this edge was compiled from, nil for hand-built networks
 }
 
 func NewEdge(n, c *Node) *Edge {
@@ -85,6 +85,9 @@ var (
 func ResetInnovationHistory() {
 	InnovationHistory = make(map[string]uint)
 	NextInnovationNumber = 0
+
+	NodeHistory = make(map[uint]uint)
+	NextNodeNumber = 0
 }
 
 func NewEdgeGene(in, out uint, weight float64, origin ma.MutationType) *EdgeGene {
@@ -18,56 +18,41 @@ func xor(x, y int) int {
 	}
 }
 
+var xorInputs = [][]float64{
+	{0, 0},
+	{0, 1},
+	{1, 0},
+	{1, 1},
+}
+
 func XorFitness(o ma.Organism) float64 {
 	n := o.(*Network)
-	n.Compile()
 
 	// Test 1: XOR
-	var (
-		bias *Node
-		inX  *Node
-		inY  *Node
-	)
-
-	for _, nodeI := range n.DNA.SensorNodes {
-		node := n.Nodes[nodeI]
-		if node.Label == "0" {
-			bias = node
-		} else if node.Label == "1" {
-			inX = node
-		} else {
-			inY = node
-		}
+	results, err := n.PredictBatch(xorInputs)
+	if err != nil {
+		panic(err)
 	}
 
-	log.Book(fmt.Sprintf("Bias label: %s, X: %s, Y: %s\n", bias.Label, inX.Label, inY.Label), log.DEBUG, log.DEBUG_PROPAGATION)
-
-	out := n.Nodes[n.DNA.OutputNodes[0]]
-
 	fitness := float64(0)
 
 	correctAnswers := 0
 
-	for x := 0; x < 2; x += 1 {
-		for y := 0; y < 2; y += 1 {
-			inputValues := []float64{1, float64(x), float64(y)}
-			n.Activate(inputValues, []*Node{bias, inX, inY}, []*Node{out})
-
-			result := out.Value()
-			if math.IsNaN(result) {
-				log.Book(n.String(), log.DEBUG)
-				panic("NaN network")
-			}
-			log.Book(fmt.Sprintf("Inputs were: %v and output was: %0.2g\n", inputValues, out.value), log.DEBUG, log.DEBUG_PROPAGATION)
+	for i, inputValues := range xorInputs {
+		result := results[i][0]
+		if math.IsNaN(result) {
+			log.Book(n.String(), log.DEBUG)
+			panic("NaN network")
+		}
+		log.Book(fmt.Sprintf("Inputs were: %v and output was: %0.2g\n", inputValues, result), log.DEBUG, log.DEBUG_PROPAGATION)
 
-			target := float64(xor(x, y))
+		target := float64(xor(int(inputValues[0]), int(inputValues[1])))
 
-			if target == 1 && result >= 0.5 || target == 0 && result < 0.5 {
-				correctAnswers += 1
-			}
-
-			fitness += (result - target) * (result - target)
+		if target == 1 && result >= 0.5 || target == 0 && result < 0.5 {
+			correctAnswers += 1
 		}
+
+		fitness += (result - target) * (result - target)
 	}
 
 	// Take reciprocal of square, want maximum value at minimum difference between result + target
@@ -81,50 +66,31 @@ func XorFitness(o ma.Organism) float64 {
 
 func XorVerify(o ma.Organism) int {
 	n := o.(*Network)
-	n.Compile()
 
 	// Test 1: XOR
-	var (
-		bias *Node
-		inX  *Node
-		inY  *Node
-	)
-
-	for _, nodeI := range n.DNA.SensorNodes {
-		node := n.Nodes[nodeI]
-		if node.Label == "0" {
-			bias = node
-		} else if node.Label == "1" {
-			inX = node
-		} else {
-			inY = node
-		}
+	results, err := n.PredictBatch(xorInputs)
+	if err != nil {
+		panic(err)
 	}
 
-	out := n.Nodes[n.DNA.OutputNodes[0]]
 	testsPassed := 0
-	for x := 0; x < 2; x += 1 {
-		for y := 0; y < 2; y += 1 {
-			inputValues := []float64{1, float64(x), float64(y)}
-			n.Activate(inputValues, []*Node{bias, inX, inY}, []*Node{out})
-
-			outValue := out.Value()
-			if math.IsNaN(outValue) {
-				log.Book(n.String(), log.DEBUG)
-				panic("NaN network")
-			}
+	for i, inputValues := range xorInputs {
+		outValue := results[i][0]
+		if math.IsNaN(outValue) {
+			log.Book(n.String(), log.DEBUG)
+			panic("NaN network")
+		}
 
-			target := xor(x, y)
-			result := 0
-			if outValue >= 0.5 {
-				result = 1
-			}
+		target := xor(int(inputValues[0]), int(inputValues[1]))
+		result := 0
+		if outValue >= 0.5 {
+			result = 1
+		}
 
-			if result == target {
-				testsPassed += 1
-			}
-			log.Book(fmt.Sprintf("Inputs were: %v and output was: %dg\n", inputValues, result), log.DEBUG, log.DEBUG_PROPAGATION)
+		if result == target {
+			testsPassed += 1
 		}
+		log.Book(fmt.Sprintf("Inputs were: %v and output was: %d\n", inputValues, result), log.DEBUG, log.DEBUG_PROPAGATION)
 	}
 
 	return testsPassed
@@ -136,9 +102,11 @@ func XorEvolution() error {
 
 	seedGenome := NewGenome(2, 1, true, -5, 5)
 	seedGenome.MutationRatios = map[ma.MutationType]float64{
-		MutationAddConnection: 0.05,
-		MutationAddNode:       0.03,
-		MutationMutateWeights: 0.92,
+		MutationAddConnection:     0.05,
+		MutationAddNode:           0.03,
+		MutationMutateWeights:     0.85,
+		MutationMutateBias:        0.05,
+		MutationChangeAggregation: 0.02,
 	}
 	seedNetwork := NewNetwork(seedGenome, nil)
 
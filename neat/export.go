@@ -0,0 +1,277 @@
+package neat
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// Text based drawings of networks. Unlike Draw these can label nodes with anything, so they show activation functions
+// and full node ids. Both include disabled genes (dashed) so the whole genome is visible
+
+func weightColor(weight float64) string {
+	// Same scheme as the bitmap, purple = positive, yellow = negative. Fades out as the weight gets closer to 0
+	strength := math.Min(math.Abs(weight), 1)
+	fade := func(c float64) int {
+		return int(math.Round(c*strength + 220*(1-strength)))
+	}
+
+	if weight >= 0 {
+		return fmt.Sprintf("#%02x%02x%02x", fade(128), fade(0), fade(255))
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", fade(230), fade(180), fade(0))
+}
+
+func edgeWidth(weight float64) float64 {
+	return 0.5 + 2.5*math.Min(math.Abs(weight), 2)/2
+}
+
+// Node id and a description of what the node computes, e.g. "7" and "sigmoid(sum +0.25)"
+func (n *Network) nodeDescription(node *Node) (string, string) {
+	activation := n.DNA.ActivationFunctionNameOf(node.ID)
+	inner := node.Aggregation.String()
+	if node.Bias != 0 {
+		inner = fmt.Sprintf("%s %+.3g", inner, node.Bias)
+	}
+
+	return fmt.Sprintf("%d", node.ID), fmt.Sprintf("%s(%s)", activation, inner)
+}
+
+// Connections to draw, with disabled genes included. Genes that use a missing node are skipped
+func (n *Network) drawableConnections() []*EdgeGene {
+	n.Compile()
+
+	connections := make([]*EdgeGene, 0, len(n.DNA.Connections))
+	for _, edgeGene := range n.DNA.Connections {
+		if n.NodeById(edgeGene.InNode) != nil && n.NodeById(edgeGene.OutNode) != nil {
+			connections = append(connections, edgeGene)
+		}
+	}
+
+	return connections
+}
+
+// Layers from SeparateIntoLayers, plus a layer before the outputs for any node it couldn't place
+func (n *Network) drawingLayers() [][]*Node {
+	layers := n.SeparateIntoLayers()
+
+	placed := make(map[*Node]bool)
+	for _, layer := range layers {
+		for _, node := range layer {
+			placed[node] = true
+		}
+	}
+
+	unplaced := make([]*Node, 0)
+	for _, node := range n.Nodes {
+		if !placed[node] {
+			unplaced = append(unplaced, node)
+		}
+	}
+
+	if len(unplaced) == 0 {
+		return layers
+	}
+
+	// Nothing got placed at all, the unplaced nodes are the whole drawing
+	if len(layers) == 0 {
+		return [][]*Node{unplaced}
+	}
+
+	last := len(layers) - 1
+	layers = append(layers[:last], unplaced, layers[last])
+
+	return layers
+}
+
+// Names can be anything once activation functions are registered, so escape whatever would end a quoted DOT string
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+var dotNodeShapes = map[NodeType]string{
+	BiasNode:   "diamond",
+	SensorNode: "box",
+	HiddenNode: "ellipse",
+	OutputNode: "doubleoctagon",
+}
+
+// Graphviz source for the network, e.g. for `dot -Tpng`
+func (n *Network) WriteDOT(w io.Writer) error {
+	n.Compile()
+
+	var b strings.Builder
+	b.WriteString("digraph network {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [fontname=\"monospace\" fontsize=10];\n")
+	b.WriteString("\tedge [fontname=\"monospace\" fontsize=8];\n")
+
+	for i, layer := range n.drawingLayers() {
+		b.WriteString(fmt.Sprintf("\tsubgraph layer%d {\n\t\trank=same;\n", i))
+		for _, node := range layer {
+			id, description := n.nodeDescription(node)
+			b.WriteString(fmt.Sprintf("\t\tn%d [label=\"%s\\n%s\" shape=%s];\n", node.ID, dotEscape(id), dotEscape(description), dotNodeShapes[node.Type]))
+		}
+		b.WriteString("\t}\n")
+	}
+
+	for _, edgeGene := range n.drawableConnections() {
+		style := "solid"
+		if !edgeGene.Enabled {
+			style = "dashed"
+		}
+
+		b.WriteString(fmt.Sprintf(
+			"\tn%d -> n%d [label=\"%.3g\" color=\"%s\" penwidth=%.2f style=%s tooltip=\"innovation %d (%s)\"];\n",
+			edgeGene.InNode, edgeGene.OutNode, edgeGene.Weight, weightColor(edgeGene.Weight), edgeWidth(edgeGene.Weight),
+			style, edgeGene.InnovationNumber, dotEscape(MutationTypeString[edgeGene.Origin]),
+		))
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Layout for WriteSVG
+const (
+	svgNodeWidth    = 110
+	svgNodeHeight   = 34
+	svgColumnMargin = 90
+	svgRowMargin    = 24
+	svgPadding      = 20
+)
+
+var svgNodeFills = map[NodeType]string{
+	BiasNode:   "#eeeeee",
+	SensorNode: "#ddeeff",
+	HiddenNode: "#ffffff",
+	OutputNode: "#ddffdd",
+}
+
+// Standalone SVG of the network, laid out in the same columns as Draw. Hovering over an edge shows its innovation
+// number, hovering over a node shows its gene
+func (n *Network) WriteSVG(w io.Writer) error {
+	n.Compile()
+
+	layers := n.drawingLayers()
+
+	maxRows := 0
+	for _, layer := range layers {
+		if len(layer) > maxRows {
+			maxRows = len(layer)
+		}
+	}
+
+	width := 2*svgPadding + len(layers)*svgNodeWidth + (len(layers)-1)*svgColumnMargin
+	height := 2*svgPadding + maxRows*svgNodeHeight + (maxRows-1)*svgRowMargin
+	if maxRows == 0 {
+		height = 2 * svgPadding
+	}
+
+	// Top left corner of each node, columns are centered vertically
+	x := make(map[uint]int)
+	y := make(map[uint]int)
+	for column, layer := range layers {
+		layerHeight := len(layer)*svgNodeHeight + (len(layer)-1)*svgRowMargin
+		top := (height - layerHeight) / 2
+
+		for row, node := range layer {
+			x[node.ID] = svgPadding + column*(svgNodeWidth+svgColumnMargin)
+			y[node.ID] = top + row*(svgNodeHeight+svgRowMargin)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"monospace\">\n", width, height, width, height))
+	b.WriteString("<defs><marker id=\"arrow\" viewBox=\"0 0 10 10\" refX=\"10\" refY=\"5\" markerWidth=\"6\" markerHeight=\"6\" orient=\"auto-start-reverse\"><path d=\"M 0 0 L 10 5 L 0 10 z\" fill=\"#555555\"/></marker></defs>\n")
+
+	// Edges first so nodes are drawn on top
+	for _, edgeGene := range n.drawableConnections() {
+		dash := ""
+		if !edgeGene.Enabled {
+			dash = " stroke-dasharray=\"5,4\""
+		}
+
+		title := html.EscapeString(fmt.Sprintf("innovation %d (%s): %d -> %d @ %.4g", edgeGene.InnovationNumber, MutationTypeString[edgeGene.Origin], edgeGene.InNode, edgeGene.OutNode, edgeGene.Weight))
+
+		x0 := x[edgeGene.InNode] + svgNodeWidth
+		y0 := y[edgeGene.InNode] + svgNodeHeight/2
+		x1 := x[edgeGene.OutNode]
+		y1 := y[edgeGene.OutNode] + svgNodeHeight/2
+
+		var path string
+		if edgeGene.InNode == edgeGene.OutNode {
+			// Loop over the top of the node
+			cx := x[edgeGene.InNode] + svgNodeWidth/2
+			top := y[edgeGene.InNode]
+			path = fmt.Sprintf("M %d %d C %d %d %d %d %d %d", cx+10, top, cx+30, top-30, cx-30, top-30, cx-10, top)
+		} else if x1 <= x0 {
+			// Backwards edges (recurrent connections or nodes in the same column) curve underneath, bottom to bottom
+			sx := x[edgeGene.InNode] + svgNodeWidth/2
+			sy := y[edgeGene.InNode] + svgNodeHeight
+			tx := x[edgeGene.OutNode] + svgNodeWidth/2
+			ty := y[edgeGene.OutNode] + svgNodeHeight
+			path = fmt.Sprintf("M %d %d C %d %d %d %d %d %d", sx, sy, sx, sy+2*svgRowMargin, tx, ty+2*svgRowMargin, tx, ty)
+		} else {
+			// Ease in and out horizontally so edges in the same column fan out instead of overlapping
+			mid := (x0 + x1) / 2
+			path = fmt.Sprintf("M %d %d C %d %d %d %d %d %d", x0, y0, mid, y0, mid, y1, x1, y1)
+		}
+
+		b.WriteString(fmt.Sprintf("<path d=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"%.2f\"%s marker-end=\"url(#arrow)\"><title>%s</title></path>\n",
+			path, weightColor(edgeGene.Weight), edgeWidth(edgeGene.Weight), dash, title))
+	}
+
+	for _, node := range n.Nodes {
+		id, description := n.nodeDescription(node)
+
+		gene := fmt.Sprintf("node %s (%s)", id, nodeTypeString[node.Type])
+		if nodeGene := n.DNA.Node(node.ID); nodeGene != nil {
+			gene = nodeGene.String()
+		}
+
+		b.WriteString(fmt.Sprintf("<g><title>%s</title>\n", html.EscapeString(gene)))
+		b.WriteString(fmt.Sprintf("<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" rx=\"6\" fill=\"%s\" stroke=\"#333333\"/>\n",
+			x[node.ID], y[node.ID], svgNodeWidth, svgNodeHeight, svgNodeFills[node.Type]))
+		b.WriteString(fmt.Sprintf("<text x=\"%d\" y=\"%d\" font-size=\"11\" text-anchor=\"middle\" font-weight=\"bold\">%s</text>\n",
+			x[node.ID]+svgNodeWidth/2, y[node.ID]+14, html.EscapeString(id)))
+		b.WriteString(fmt.Sprintf("<text x=\"%d\" y=\"%d\" font-size=\"9\" text-anchor=\"middle\">%s</text>\n",
+			x[node.ID]+svgNodeWidth/2, y[node.ID]+27, html.EscapeString(description)))
+		b.WriteString("</g>\n")
+	}
+
+	b.WriteString("</svg>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeToFile(fName string, write func(io.Writer) error) error {
+	file, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffer := bufio.NewWriter(file)
+	if err := write(buffer); err != nil {
+		return err
+	}
+
+	return buffer.Flush()
+}
+
+func (n *Network) DrawDOT(fName string) error {
+	return writeToFile(fName, n.WriteDOT)
+}
+
+func (n *Network) DrawSVG(fName string) error {
+	return writeToFile(fName, n.WriteSVG)
+}
@@ -0,0 +1,570 @@
+package neat
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Closed-form expressions for what a network computes, mostly for reading what an evolved CPPN is doing. Each output
+// becomes a tree of sums, products and activation functions of the inputs, which can be simplified and printed as
+// plain text or LaTeX
+
+type ExpressionKind uint8
+
+const (
+	ConstantExpression ExpressionKind = iota
+	VariableExpression
+	SumExpression
+	ProductExpression
+	MaxExpression
+	MinExpression
+	MeanExpression
+	FunctionExpression
+)
+
+type Expression struct {
+	Kind ExpressionKind
+
+	Value    float64 // Constants only
+	Name     string  // Variable name, or registered activation function name
+	Children []*Expression
+}
+
+type ExpressionError struct {
+	Msg string
+}
+
+func (e *ExpressionError) Error() string {
+	return e.Msg
+}
+
+func Constant(x float64) *Expression {
+	return &Expression{Kind: ConstantExpression, Value: x}
+}
+
+func Variable(name string) *Expression {
+	return &Expression{Kind: VariableExpression, Name: name}
+}
+
+func (e *Expression) IsConstant() bool {
+	return e.Kind == ConstantExpression
+}
+
+// Aggregation expression kind for an aggregation function
+var aggregationExpressions = map[Aggregation]ExpressionKind{
+	SumAggregation:     SumExpression,
+	ProductAggregation: ProductExpression,
+	MaxAggregation:     MaxExpression,
+	MinAggregation:     MinExpression,
+	MeanAggregation:    MeanExpression,
+}
+
+// One unsimplified expression per output. inputNames name the sensors (not the bias) in order, and default to x, y,
+// z for up to three inputs and x0, x1, ... otherwise. Recurrent networks are unrolled for their relaxation steps,
+// starting from a blank state, so they match Predict
+func (n *Network) Expressions(inputNames []string) ([]*Expression, error) {
+	n.Compile()
+	p := n.plan
+
+	nInputs := 0
+	for _, i := range p.sensors {
+		if n.Nodes[i].Type != BiasNode {
+			nInputs += 1
+		}
+	}
+
+	if inputNames == nil {
+		inputNames = make([]string, nInputs)
+		for j := range inputNames {
+			if nInputs <= 3 {
+				inputNames[j] = []string{"x", "y", "z"}[j]
+			} else {
+				inputNames[j] = fmt.Sprintf("x%d", j)
+			}
+		}
+	}
+
+	if len(inputNames) != nInputs {
+		return nil, &ExpressionError{Msg: fmt.Sprintf("expected %d input names but got %d", nInputs, len(inputNames))}
+	}
+
+	sensorExpressions := make(map[int]*Expression)
+	j := 0
+	for _, i := range p.sensors {
+		if n.Nodes[i].Type == BiasNode {
+			sensorExpressions[i] = Constant(1)
+		} else {
+			sensorExpressions[i] = Variable(inputNames[j])
+			j += 1
+		}
+	}
+
+	position := make(map[int]int)
+	for k, i := range p.order {
+		position[i] = k
+	}
+
+	// Expression for node i after the given step, shared between everything that uses it
+	memo := make(map[[2]int]*Expression)
+	var nodeExpression func(i, step int) *Expression
+	nodeExpression = func(i, step int) *Expression {
+		if step < 0 {
+			return Constant(0)
+		}
+
+		key := [2]int{i, step}
+		if e, ok := memo[key]; ok {
+			return e
+		}
+
+		k := position[i]
+		node := n.Nodes[i]
+		fn := &Expression{Kind: FunctionExpression, Name: n.DNA.ActivationFunctionNameOf(node.ID)}
+		if _, ok := ActivationByName(fn.Name); !ok {
+			fn.Name = IdentityStr
+		}
+
+		start, end := p.inStart[k], p.inStart[k+1]
+		if start == end {
+			input, ok := sensorExpressions[i]
+			if !ok {
+				input = Constant(0)
+			}
+
+			fn.Children = []*Expression{{Kind: SumExpression, Children: []*Expression{input, Constant(p.biases[k])}}}
+			memo[key] = fn
+			return fn
+		}
+
+		terms := make([]*Expression, 0)
+		bias := &Expression{Kind: SumExpression, Children: []*Expression{Constant(p.biases[k])}}
+		for e := start; e < end; e += 1 {
+			// Edges that point backwards in the order read the previous step
+			sourceStep := step
+			if position[p.sources[e]] >= k {
+				sourceStep -= 1
+			}
+
+			term := &Expression{Kind: ProductExpression, Children: []*Expression{Constant(p.weights[e]), nodeExpression(p.sources[e], sourceStep)}}
+			if p.isBias[e] {
+				bias.Children = append(bias.Children, term)
+			} else {
+				terms = append(terms, term)
+			}
+		}
+
+		agg := Constant(0)
+		if len(terms) > 0 {
+			agg = &Expression{Kind: aggregationExpressions[p.aggs[k]], Children: terms}
+		}
+
+		fn.Children = []*Expression{{Kind: SumExpression, Children: []*Expression{agg, bias}}}
+		memo[key] = fn
+		return fn
+	}
+
+	steps := n.relaxationSteps()
+	outputs := make([]*Expression, len(p.outputs))
+	for j, i := range p.outputs {
+		outputs[j] = nodeExpression(i, steps-1)
+	}
+
+	return outputs, nil
+}
+
+// Simplified expressions for every output, see Expressions and Simplify
+func (n *Network) SimplifiedExpressions(inputNames []string) ([]*Expression, error) {
+	outputs, err := n.Expressions(inputNames)
+	if err != nil {
+		return nil, err
+	}
+
+	for j := range outputs {
+		outputs[j] = outputs[j].Simplify()
+	}
+
+	return outputs, nil
+}
+
+// Value of the expression for the given variables. Unknown variables are 0
+func (e *Expression) Evaluate(variables map[string]float64) float64 {
+	switch e.Kind {
+	case ConstantExpression:
+		return e.Value
+	case VariableExpression:
+		return variables[e.Name]
+	case FunctionExpression:
+		return FuncByName(e.Name)(e.Children[0].Evaluate(variables))
+	}
+
+	values := make([]float64, len(e.Children))
+	for i, child := range e.Children {
+		values[i] = child.Evaluate(variables)
+	}
+
+	switch e.Kind {
+	case ProductExpression:
+		return ProductAggregation.Aggregate(values)
+	case MaxExpression:
+		return MaxAggregation.Aggregate(values)
+	case MinExpression:
+		return MinAggregation.Aggregate(values)
+	case MeanExpression:
+		return MeanAggregation.Aggregate(values)
+	default:
+		return SumAggregation.Aggregate(values)
+	}
+}
+
+// Equivalent expression with constants folded, identity functions removed, nested sums and products flattened and
+// constant factors multiplied through sums, so chains of linear nodes collapse into one weighted sum. Returns a new
+// tree, e isn't changed
+func (e *Expression) Simplify() *Expression {
+	return e.simplify(make(map[*Expression]*Expression))
+}
+
+// Subtrees are shared between the nodes that use them, memo makes sure each one is only simplified once
+func (e *Expression) simplify(memo map[*Expression]*Expression) *Expression {
+	switch e.Kind {
+	case ConstantExpression, VariableExpression:
+		return e
+	}
+
+	if simplified, ok := memo[e]; ok {
+		return simplified
+	}
+
+	simplified := e.simplifyNode(memo)
+	memo[e] = simplified
+	return simplified
+}
+
+func (e *Expression) simplifyNode(memo map[*Expression]*Expression) *Expression {
+	children := make([]*Expression, len(e.Children))
+	allConstant := true
+	for i, child := range e.Children {
+		children[i] = child.simplify(memo)
+		allConstant = allConstant && children[i].IsConstant()
+	}
+
+	simplified := &Expression{Kind: e.Kind, Name: e.Name, Children: children}
+	if allConstant {
+		return Constant(simplified.Evaluate(nil))
+	}
+
+	switch e.Kind {
+	case FunctionExpression:
+		switch e.Name {
+		case IdentityStr:
+			return children[0]
+		case InversionStr:
+			return (&Expression{Kind: ProductExpression, Children: []*Expression{Constant(-1), children[0]}}).Simplify()
+		case NullStr:
+			return Constant(0)
+		}
+		return simplified
+	case SumExpression:
+		return simplifySum(children)
+	case ProductExpression:
+		return simplifyProduct(children)
+	default:
+		if len(children) == 1 {
+			return children[0]
+		}
+		return simplified
+	}
+}
+
+// Split a term into its constant coefficient and the rest
+func splitCoefficient(e *Expression) (float64, *Expression) {
+	if e.Kind == ProductExpression && len(e.Children) > 0 && e.Children[0].IsConstant() {
+		rest := e.Children[1:]
+		if len(rest) == 1 {
+			return e.Children[0].Value, rest[0]
+		}
+		return e.Children[0].Value, &Expression{Kind: ProductExpression, Children: rest}
+	}
+
+	return 1, e
+}
+
+func simplifySum(children []*Expression) *Expression {
+	// Flatten, then add up like terms (same expression, different coefficients)
+	flat := make([]*Expression, 0, len(children))
+	for _, child := range children {
+		if child.Kind == SumExpression {
+			flat = append(flat, child.Children...)
+		} else {
+			flat = append(flat, child)
+		}
+	}
+
+	constant := 0.0
+	coefficients := make(map[string]float64)
+	bases := make(map[string]*Expression)
+	order := make([]string, 0)
+	for _, term := range flat {
+		if term.IsConstant() {
+			constant += term.Value
+			continue
+		}
+
+		coefficient, base := splitCoefficient(term)
+		key := base.key()
+		if _, ok := bases[key]; !ok {
+			bases[key] = base
+			order = append(order, key)
+		}
+		coefficients[key] += coefficient
+	}
+
+	terms := make([]*Expression, 0, len(order)+1)
+	for _, key := range order {
+		switch coefficients[key] {
+		case 0:
+			continue
+		case 1:
+			terms = append(terms, bases[key])
+		default:
+			terms = append(terms, simplifyProduct([]*Expression{Constant(coefficients[key]), bases[key]}))
+		}
+	}
+
+	if constant != 0 || len(terms) == 0 {
+		terms = append(terms, Constant(constant))
+	}
+
+	if len(terms) == 1 {
+		return terms[0]
+	}
+
+	return &Expression{Kind: SumExpression, Children: terms}
+}
+
+func simplifyProduct(children []*Expression) *Expression {
+	coefficient := 1.0
+	factors := make([]*Expression, 0, len(children))
+	for _, child := range children {
+		if child.IsConstant() {
+			coefficient *= child.Value
+		} else if child.Kind == ProductExpression {
+			c, rest := splitCoefficient(child)
+			coefficient *= c
+			if rest.Kind == ProductExpression {
+				factors = append(factors, rest.Children...)
+			} else {
+				factors = append(factors, rest)
+			}
+		} else {
+			factors = append(factors, child)
+		}
+	}
+
+	if coefficient == 0 || len(factors) == 0 {
+		return Constant(coefficient)
+	}
+
+	// c * (a + b) = c*a + c*b, which is what lets linear chains collapse
+	if len(factors) == 1 && factors[0].Kind == SumExpression && coefficient != 1 {
+		terms := make([]*Expression, len(factors[0].Children))
+		for i, term := range factors[0].Children {
+			terms[i] = simplifyProduct([]*Expression{Constant(coefficient), term})
+		}
+		return simplifySum(terms)
+	}
+
+	if coefficient == 1 {
+		if len(factors) == 1 {
+			return factors[0]
+		}
+		return &Expression{Kind: ProductExpression, Children: factors}
+	}
+
+	return &Expression{Kind: ProductExpression, Children: append([]*Expression{Constant(coefficient)}, factors...)}
+}
+
+// Exact description of the tree, for telling whether two subtrees are the same
+func (e *Expression) key() string {
+	switch e.Kind {
+	case ConstantExpression:
+		return strconv.FormatFloat(e.Value, 'g', -1, 64)
+	case VariableExpression:
+		return "$" + e.Name
+	}
+
+	children := make([]string, len(e.Children))
+	for i, child := range e.Children {
+		children[i] = child.key()
+	}
+
+	return fmt.Sprintf("%d%s(%s)", e.Kind, e.Name, strings.Join(children, ","))
+}
+
+// Significant digits of constants in String and LaTeX
+var ExpressionPrecision = 4
+
+func formatConstant(x float64) string {
+	return strconv.FormatFloat(x, 'g', ExpressionPrecision, 64)
+}
+
+func activationSymbol(name string) string {
+	if a, ok := ActivationByName(name); ok {
+		return a.Symbol
+	}
+
+	return name
+}
+
+// Plain text, e.g. sin(0.3*x + gauss(-1.2*y))
+func (e *Expression) String() string {
+	switch e.Kind {
+	case ConstantExpression:
+		return formatConstant(e.Value)
+	case VariableExpression:
+		return e.Name
+	case FunctionExpression:
+		return fmt.Sprintf("%s(%s)", activationSymbol(e.Name), e.Children[0].String())
+	case SumExpression:
+		out := ""
+		for i, child := range e.Children {
+			s := child.String()
+			if i == 0 {
+				out = s
+			} else if strings.HasPrefix(s, "-") {
+				out += " - " + s[1:]
+			} else {
+				out += " + " + s
+			}
+		}
+		return out
+	case ProductExpression:
+		factors := make([]string, len(e.Children))
+		for i, child := range e.Children {
+			factors[i] = child.String()
+			if child.Kind == SumExpression || (i > 0 && child.IsConstant() && child.Value < 0) {
+				factors[i] = "(" + factors[i] + ")"
+			}
+		}
+
+		// -1*x reads better as -x
+		if len(factors) > 1 && e.Children[0].IsConstant() && e.Children[0].Value == -1 {
+			return "-" + strings.Join(factors[1:], "*")
+		}
+		return strings.Join(factors, "*")
+	default:
+		args := make([]string, len(e.Children))
+		for i, child := range e.Children {
+			args[i] = child.String()
+		}
+		name := map[ExpressionKind]string{MaxExpression: "max", MinExpression: "min", MeanExpression: "mean"}[e.Kind]
+		return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+	}
+}
+
+func latexConstant(x float64) string {
+	s := formatConstant(x)
+	if i := strings.Index(s, "e"); i >= 0 {
+		n, _ := strconv.Atoi(s[i+1:])
+		return fmt.Sprintf(`%s \times 10^{%d}`, s[:i], n)
+	}
+
+	return s
+}
+
+func (e *Expression) LaTeX() string {
+	switch e.Kind {
+	case ConstantExpression:
+		return latexConstant(e.Value)
+	case VariableExpression:
+		// x0 -> x_{0}
+		i := strings.IndexFunc(e.Name, func(r rune) bool { return r >= '0' && r <= '9' })
+		if i > 0 {
+			return fmt.Sprintf("%s_{%s}", e.Name[:i], e.Name[i:])
+		}
+		return e.Name
+	case FunctionExpression:
+		template := `\operatorname{` + activationSymbol(e.Name) + `}\left(%[1]s\right)`
+		if a, ok := ActivationByName(e.Name); ok {
+			template = a.LaTeX
+		}
+		if !strings.Contains(template, "%[1]s") {
+			return template
+		}
+		return fmt.Sprintf(template, e.Children[0].LaTeX())
+	case SumExpression:
+		out := ""
+		for i, child := range e.Children {
+			s := child.LaTeX()
+			if i == 0 {
+				out = s
+			} else if strings.HasPrefix(s, "-") {
+				out += " - " + s[1:]
+			} else {
+				out += " + " + s
+			}
+		}
+		return out
+	case ProductExpression:
+		factors := make([]string, len(e.Children))
+		for i, child := range e.Children {
+			factors[i] = child.LaTeX()
+			if child.Kind == SumExpression || (i > 0 && child.IsConstant() && child.Value < 0) {
+				factors[i] = `\left(` + factors[i] + `\right)`
+			}
+		}
+
+		if len(factors) > 1 && e.Children[0].IsConstant() && e.Children[0].Value == -1 {
+			return "-" + strings.Join(factors[1:], ` \cdot `)
+		}
+		return strings.Join(factors, ` \cdot `)
+	case MeanExpression:
+		args := make([]string, len(e.Children))
+		for i, child := range e.Children {
+			args[i] = child.LaTeX()
+		}
+		return fmt.Sprintf(`\frac{%s}{%d}`, strings.Join(args, " + "), len(args))
+	default:
+		args := make([]string, len(e.Children))
+		for i, child := range e.Children {
+			args[i] = child.LaTeX()
+		}
+		name := map[ExpressionKind]string{MaxExpression: `\max`, MinExpression: `\min`}[e.Kind]
+		return fmt.Sprintf(`%s\left(%s\right)`, name, strings.Join(args, ", "))
+	}
+}
+
+// Number of nodes in the tree, counting shared subtrees every time they're used
+func (e *Expression) Size() int {
+	size := 1
+	for _, child := range e.Children {
+		size += child.Size()
+	}
+
+	return size
+}
+
+// Names of the variables used, sorted
+func (e *Expression) Variables() []string {
+	seen := make(map[string]bool)
+
+	var visit func(e *Expression)
+	visit = func(e *Expression) {
+		if e.Kind == VariableExpression {
+			seen[e.Name] = true
+		}
+		for _, child := range e.Children {
+			visit(child)
+		}
+	}
+	visit(e)
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -2,16 +2,28 @@ package neat
 
 import (
 	"math"
+	"sync"
 )
 
-var seed int64 = 0
+var (
+	seed      int64      = 0
+	seedMutex sync.Mutex // Species mutate in parallel, and they all draw activation functions from here
+)
+
+const randModulus = 1737017
 
 func randi() int64 {
-	seed = (1028597*seed + 488249) % 1737017
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+
+	seed = (1028597*seed + 488249) % randModulus
 	return seed
 }
 
 func Seed(s int64) {
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+
 	seed = s
 }
 
@@ -53,112 +65,32 @@ const (
 	IdentityRep       = "E"
 )
 
-// TODO: Use an enum for function names
-func RandomFunc() (ActivationFunction, string) {
-	const totalFunctions = 15
-
-	p := randi() % totalFunctions
-	if p <= 0 {
-		return SinFunc, SinStr
-	} else if p <= 1 {
-		return Sin2Func, Sin2Str
-	} else if p <= 2 {
-		return AbsFunc, AbsStr
-	} else if p <= 3 {
-		return NullFunc, NullStr
-	} else if p <= 4 {
-		return GaussianFunc, GaussianStr
-	} else if p <= 5 {
-		return SigmoidFunc, SigmoidStr
-	} else if p <= 10 {
-		return NEATSigmoidFunc, NEATSigmoidStr
-	} else if p <= 6 {
-		return BipolarSigmoidFunc, BipolarSigmoidStr
-	} else if p <= 7 {
-		return QuadraticFunc, QuadraticStr
-	} else if p <= 8 {
-		return StepFunc, StepStr
-	} else if p <= 9 {
-		return InversionFunc, InversionStr
-	} else if p <= 11 {
-		return ExponentiationFunc, ExponentiationStr
-	} else if p <= 12 {
-		return TetrationFunc, TetrationStr
-	} else if p <= 13 {
-		return SawFunc, SawStr
-	} else {
-		return IdentityFunc, IdentityStr
-	}
-}
+// Lookups into the activation registry, see activations.go. Unknown names are the identity function
 
 func FuncByName(name string) ActivationFunction {
-	switch name {
-	case SinStr:
-		return SinFunc
-	case Sin2Str:
-		return Sin2Func
-	case AbsStr:
-		return AbsFunc
-	case NullStr:
-		return NullFunc
-	case GaussianStr:
-		return GaussianFunc
-	case SigmoidStr:
-		return SigmoidFunc
-	case NEATSigmoidStr:
-		return NEATSigmoidFunc
-	case BipolarSigmoidStr:
-		return BipolarSigmoidFunc
-	case QuadraticStr:
-		return QuadraticFunc
-	case StepStr:
-		return StepFunc
-	case InversionStr:
-		return InversionFunc
-	case ExponentiationStr:
-		return ExponentiationFunc
-	case TetrationStr:
-		return TetrationFunc
-	case SawStr:
-		return SawFunc
-	default:
-		return IdentityFunc
+	if a, ok := ActivationByName(name); ok {
+		return a.Fn
 	}
+
+	return IdentityFunc
 }
 
 func RepByName(name string) string {
-	switch name {
-	case SinStr:
-		return SinRep
-	case Sin2Str:
-		return Sin2Rep
-	case AbsStr:
-		return AbsRep
-	case NullStr:
-		return NullRep
-	case GaussianStr:
-		return GaussianRep
-	case SigmoidStr:
-		return SigmoidRep
-	case NEATSigmoidStr:
-		return NEATSigmoidRep
-	case BipolarSigmoidStr:
-		return BipolarSigmoidRep
-	case QuadraticStr:
-		return QuadraticRep
-	case StepStr:
-		return StepRep
-	case InversionStr:
-		return InversionRep
-	case ExponentiationStr:
-		return ExponentiationRep
-	case TetrationStr:
-		return TetrationRep
-	case SawStr:
-		return SawRep
-	default:
-		return IdentityRep
+	if a, ok := ActivationByName(name); ok {
+		return a.Rep
 	}
+
+	return IdentityRep
+}
+
+// Derivative of the named activation function, false if the function isn't usefully differentiable
+func DerivativeByName(name string) (ActivationFunction, bool) {
+	a, ok := ActivationByName(name)
+	if !ok {
+		return IdentityDerivative, true
+	}
+
+	return a.Derivative, a.Derivative != nil
 }
 
 func SinFunc(x float64) float64 {
@@ -222,3 +154,53 @@ func IdentityFunc(x float64) float64 {
 func NEATSigmoidFunc(x float64) float64 {
 	return 1 / (1 + math.Exp(-4.9*x))
 }
+
+// Derivatives
+
+func SinDerivative(x float64) float64 {
+	return math.Cos(x)
+}
+
+func Sin2Derivative(x float64) float64 {
+	return 2 * math.Cos(2*x)
+}
+
+func AbsDerivative(x float64) float64 {
+	if x < 0 {
+		return -1
+	} else if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+func GaussianDerivative(x float64) float64 {
+	return -25 * x * math.Exp(-math.Pow(2.5*x, 2))
+}
+
+func SigmoidDerivative(x float64) float64 {
+	s := SigmoidFunc(x)
+	return s * (1 - s)
+}
+
+func BipolarSigmoidDerivative(x float64) float64 {
+	s := NEATSigmoidFunc(x)
+	return 2 * 4.9 * s * (1 - s)
+}
+
+func QuadraticDerivative(x float64) float64 {
+	return 2 * x
+}
+
+func InversionDerivative(x float64) float64 {
+	return -1
+}
+
+func IdentityDerivative(x float64) float64 {
+	return 1
+}
+
+func NEATSigmoidDerivative(x float64) float64 {
+	s := NEATSigmoidFunc(x)
+	return 4.9 * s * (1 - s)
+}
@@ -18,18 +18,28 @@ func flag2Int(n bool) int {
 	}
 }
 
-// TODO: node genes, will they always just work out if they go sensor -> output -> hidden?
-// TODO: bias flag for nodes
 type Genome struct {
+	Nodes       []*NodeGene // Sorted by id
 	Connections []*EdgeGene
+
+	// Node ids by type, derived from Nodes by PopulateNodeSlices
 	SensorNodes []uint
 	HiddenNodes []uint
 	OutputNodes []uint
 
-	ActivationFunctions map[uint]string
+	// CPPNs mutate activation functions and give new nodes random ones, plain NEAT always uses defaultActivation
+	MutableActivations bool
+	ActivationSet      []string // Names of the registered functions mutation can pick from, nil for DefaultActivationSet
 
 	UsesBias bool
 
+	// Recurrent genomes can grow cycles and self-loops. Their networks remember node values between Steps
+	Recurrent       bool
+	RelaxationSteps int // Steps per stateless activation of a recurrent network
+
+	// Run Repair on children and mutants, see validate.go
+	AutoRepair bool
+
 	MinWeight float64
 	MaxWeight float64
 
@@ -37,28 +47,62 @@ type Genome struct {
 }
 
 func NewGenome(inNodes, outNodes int, useBias bool, minWeight, maxWeight float64) *Genome {
+	return NewGenomeWithHiddenNodes(inNodes, 0, outNodes, useBias, minWeight, maxWeight)
+}
+
+// Node ids are the bias (if used), then sensors, then outputs, then hidden nodes
+func NewGenomeWithHiddenNodes(inNodes, hiddenNodes, outNodes int, useBias bool, minWeight, maxWeight float64) *Genome {
 	g := &Genome{
+		Nodes:       make([]*NodeGene, 0),
 		Connections: make([]*EdgeGene, 0),
-		SensorNodes: make([]uint, inNodes+flag2Int(useBias)),
-		HiddenNodes: make([]uint, 0),
-		OutputNodes: make([]uint, outNodes),
 		UsesBias:    useBias,
 
 		MinWeight: minWeight,
 		MaxWeight: maxWeight,
 	}
 
+	nodeId := uint(0)
+	if useBias {
+		g.AddNodeGene(NewNodeGene(nodeId, BiasNode, defaultActivation(BiasNode)))
+		nodeId += 1
+	}
+
+	for i := 0; i < inNodes; i += 1 {
+		g.AddNodeGene(NewNodeGene(nodeId, SensorNode, defaultActivation(SensorNode)))
+		nodeId += 1
+	}
+
+	for i := 0; i < outNodes; i += 1 {
+		g.AddNodeGene(NewNodeGene(nodeId, OutputNode, defaultActivation(OutputNode)))
+		nodeId += 1
+	}
+
+	for i := 0; i < hiddenNodes; i += 1 {
+		g.AddNodeGene(NewNodeGene(nodeId, HiddenNode, defaultActivation(HiddenNode)))
+		nodeId += 1
+	}
+
 	g.Randomize()
 	return g
 }
 
 func (g *Genome) Copy() ma.GeneticCode {
 	newGenome := &Genome{
+		Nodes:       make([]*NodeGene, len(g.Nodes)),
 		Connections: make([]*EdgeGene, len(g.Connections)),
 		SensorNodes: make([]uint, len(g.SensorNodes)),
 		HiddenNodes: make([]uint, len(g.HiddenNodes)),
 		OutputNodes: make([]uint, len(g.OutputNodes)),
-		UsesBias:    g.UsesBias,
+
+		MutableActivations: g.MutableActivations,
+		ActivationSet:      g.ActivationSet,
+
+		UsesBias: g.UsesBias,
+
+		Recurrent:       g.Recurrent,
+		RelaxationSteps: g.RelaxationSteps,
+
+		AutoRepair: g.AutoRepair,
 
 		MinWeight: g.MinWeight,
 		MaxWeight: g.MaxWeight,
@@ -66,15 +110,12 @@ func (g *Genome) Copy() ma.GeneticCode {
 		MutationRatios: g.MutationRatios,
 	}
 
-	for i, v := range g.Connections {
-		newGenome.Connections[i] = v.Copy()
+	for i, v := range g.Nodes {
+		newGenome.Nodes[i] = v.Copy()
 	}
 
-	if g.ActivationFunctions != nil {
-		newGenome.ActivationFunctions = make(map[uint]string)
-		for k, v := range g.ActivationFunctions {
-			newGenome.ActivationFunctions[k] = v
-		}
+	for i, v := range g.Connections {
+		newGenome.Connections[i] = v.Copy()
 	}
 
 	copy(newGenome.SensorNodes, g.SensorNodes)
@@ -84,38 +125,46 @@ func (g *Genome) Copy() ma.GeneticCode {
 	return ma.GeneticCode(newGenome)
 }
 
+// Replace all connections with a minimal random topology. Every node is connected at least once, sensors feed into
+// hidden nodes if there are any and outputs otherwise
 func (g *Genome) Randomize() {
-	inNodes := len(g.SensorNodes)
-	outNodes := len(g.OutputNodes)
+	g.PopulateNodeSlices()
 
 	g.Connections = make([]*EdgeGene, 0)
-	g.HiddenNodes = make([]uint, 0)
-	g.SensorNodes = make([]uint, 0)
-	g.OutputNodes = make([]uint, 0)
 
-	// Make sure all nodes are connected at least once
-	nodesConnected := make([]bool, outNodes)
-	for s := 0; s < inNodes; s += 1 {
-		outNode := rand.Intn(outNodes)
-		nodesConnected[outNode] = true
-		outNode += inNodes
+	connectLayers := func(from, to []uint) {
+		// Make sure all nodes are connected at least once
+		nodesConnected := make([]bool, len(to))
+		for _, inNode := range from {
+			o := rand.Intn(len(to))
+			nodesConnected[o] = true
 
-		c := NewEdgeGene(uint(s), uint(outNode), g.RandomWeight(), NoMutation)
-		g.Connections = append(g.Connections, c)
-	}
+			c := NewEdgeGene(inNode, to[o], g.RandomWeight(), NoMutation)
+			g.Connections = append(g.Connections, c)
+		}
 
-	for o := 0; o < outNodes; o += 1 {
-		// No need to double up
-		if nodesConnected[o] {
-			continue
+		for o, outNode := range to {
+			// No need to double up
+			if nodesConnected[o] {
+				continue
+			}
+
+			inNode := from[rand.Intn(len(from))]
+			c := NewEdgeGene(inNode, outNode, g.RandomWeight(), NoMutation)
+			g.Connections = append(g.Connections, c)
 		}
+	}
 
-		inNode := uint(rand.Intn(inNodes))
-		c := NewEdgeGene(inNode, uint(o+inNodes), g.RandomWeight(), NoMutation)
-		g.Connections = append(g.Connections, c)
+	if len(g.SensorNodes) == 0 || len(g.OutputNodes) == 0 {
+		return
 	}
 
-	g.PopulateNodeSlices()
+	if len(g.HiddenNodes) == 0 {
+		connectLayers(g.SensorNodes, g.OutputNodes)
+	} else {
+		connectLayers(g.SensorNodes, g.HiddenNodes)
+		connectLayers(g.HiddenNodes, g.OutputNodes)
+	}
 }
 
 func (g *Genome) String() string {
@@ -135,15 +184,23 @@ func (g *Genome) String() string {
 	}
 
 	nodes := ""
-	if g.ActivationFunctions != nil {
-		if len(g.ActivationFunctions) == 0 {
-			nodes = "?"
-		} else {
-			for _, fnStr := range g.ActivationFunctions {
-				nodes += RepByName(fnStr)
-			}
+	if g.MutableActivations {
+		for _, node := range g.Nodes {
+			nodes += RepByName(node.Activation)
+		}
+	}
+
+	// Only nodes with a bias or aggregation function that isn't the default, so most genomes look the same as before
+	extra := ""
+	for _, node := range g.Nodes {
+		agg := AggregationByName(node.Aggregation)
+		if node.Bias != 0 || agg != SumAggregation {
+			extra += fmt.Sprintf("%s%d%s", uintToBase64(node.ID, digitsInBase64), agg, uintToBase64(uint(math.Float64bits(node.Bias)), 11))
 		}
 	}
+	if extra != "" {
+		nodes += "." + extra
+	}
 
 	return edges + "." + nodes
 }
@@ -156,14 +213,13 @@ func (g *Genome) ToPretty() string {
 	edges = edges[:len(edges)-1] + "]"
 
 	nodes := ""
-	if g.ActivationFunctions != nil {
+	if g.MutableActivations {
 		nodes = "\n"
-		for nodeId, fnStr := range g.ActivationFunctions {
-			nodes += fmt.Sprintf("\t%d: %s\n", nodeId, fnStr)
+		for _, node := range g.Nodes {
+			nodes += fmt.Sprintf("\t%d: %s\n", node.ID, node.Activation)
 		}
 	}
 
-	// Shouldn't need to include nodes in the representation. That would be redundant considering nodes are generated based on edges
 	return edges + nodes
 }
 
@@ -193,30 +249,18 @@ func (g *Genome) NodesString() string {
 }
 
 func (g *Genome) ActivationFunctionOf(nodeId uint) ActivationFunction {
-	// Use vanilla NEAT activation functions for defaults
-	if g.ActivationFunctions == nil {
-		log.Book("Activation functions are nil, using defaults\n", log.DEBUG, log.DEBUG_GET_ACTIVATION)
-		for _, v := range g.SensorNodes {
-			if v == nodeId {
-				log.Book(fmt.Sprintf("%d: Identity\n", nodeId), log.DEBUG, log.DEBUG_GET_ACTIVATION)
-				return IdentityFunc
-			}
-		}
-
-		log.Book(fmt.Sprintf("%d: Sigmoid\n", nodeId), log.DEBUG, log.DEBUG_GET_ACTIVATION)
-		return NEATSigmoidFunc
-	}
-
-	log.Book("Activation functions are not nil\n", log.DEBUG, log.DEBUG_GET_ACTIVATION)
-	if fn, ok := g.ActivationFunctions[nodeId]; ok {
-		log.Book(fmt.Sprintf("Found a function for %d\n", nodeId), log.DEBUG, log.DEBUG_GET_ACTIVATION)
+	return FuncByName(g.ActivationFunctionNameOf(nodeId))
+}
 
-		return FuncByName(fn)
+func (g *Genome) ActivationFunctionNameOf(nodeId uint) string {
+	node := g.Node(nodeId)
+	if node == nil || node.Activation == "" {
+		log.Book(fmt.Sprintf("No function found for %d\n", nodeId), log.DEBUG, log.DEBUG_GET_ACTIVATION)
+		// Use the identity function if no other function is specified in the genome
+		return IdentityStr
 	}
 
-	log.Book(fmt.Sprintf("No function found for %d\n", nodeId), log.DEBUG, log.DEBUG_GET_ACTIVATION)
-	// Use the identity function if no other function is specified in the genome
-	return IdentityFunc
+	return node.Activation
 }
 
 // NOTE: need to map innovation numbers within a generation to specific mutations
@@ -229,6 +273,11 @@ const (
 	MutationMutateWeights
 	MutationChangeAFunction
 	MutationDisableConnection
+	MutationEnableConnection
+	MutationDeleteConnection
+	MutationDeleteNode
+	MutationMutateBias
+	MutationChangeAggregation
 )
 
 const (
@@ -237,7 +286,12 @@ const (
 	MutationAddNodeStr           = "Add a node"
 	MutationMutateWeightsStr     = "Mutate weights"
 	MutationChangeAFunctionStr   = "Change an activation function"
-	MutationDisableConnectionStr = "Disable a mutation"
+	MutationDisableConnectionStr = "Disable a connection"
+	MutationEnableConnectionStr  = "Enable a connection"
+	MutationDeleteConnectionStr  = "Delete a connection"
+	MutationDeleteNodeStr        = "Delete a node"
+	MutationMutateBiasStr        = "Mutate biases"
+	MutationChangeAggregationStr = "Change an aggregation function"
 )
 
 var MutationTypeString = map[ma.MutationType]string{
@@ -247,6 +301,11 @@ var MutationTypeString = map[ma.MutationType]string{
 	MutationMutateWeights:     MutationMutateWeightsStr,
 	MutationChangeAFunction:   MutationChangeAFunctionStr,
 	MutationDisableConnection: MutationDisableConnectionStr,
+	MutationEnableConnection:  MutationEnableConnectionStr,
+	MutationDeleteConnection:  MutationDeleteConnectionStr,
+	MutationDeleteNode:        MutationDeleteNodeStr,
+	MutationMutateBias:        MutationMutateBiasStr,
+	MutationChangeAggregation: MutationChangeAggregationStr,
 }
 
 func (g *Genome) ListMutations() map[string]ma.MutationType {
@@ -257,6 +316,11 @@ func (g *Genome) ListMutations() map[string]ma.MutationType {
 	m[MutationMutateWeightsStr] = MutationMutateWeights
 	m[MutationChangeAFunctionStr] = MutationChangeAFunction
 	m[MutationDisableConnectionStr] = MutationDisableConnection
+	m[MutationEnableConnectionStr] = MutationEnableConnection
+	m[MutationDeleteConnectionStr] = MutationDeleteConnection
+	m[MutationDeleteNodeStr] = MutationDeleteNode
+	m[MutationMutateBiasStr] = MutationMutateBias
+	m[MutationChangeAggregationStr] = MutationChangeAggregation
 	return m
 }
 
@@ -265,10 +329,17 @@ func (g *Genome) MutationOdds() map[ma.MutationType]float64 {
 		return g.MutationRatios
 	}
 
+	// Structure mostly grows, but now and then it gets pruned back
 	m := make(map[ma.MutationType]float64)
 	m[MutationAddConnection] = 0.15
 	m[MutationAddNode] = 0.05
-	m[MutationMutateWeights] = 0.8
+	m[MutationMutateWeights] = 0.7
+	m[MutationMutateBias] = 0.05
+	m[MutationChangeAggregation] = 0.02
+	m[MutationDisableConnection] = 0.01
+	m[MutationEnableConnection] = 0.01
+	m[MutationDeleteConnection] = 0.005
+	m[MutationDeleteNode] = 0.005
 	return m
 }
 
@@ -292,6 +363,16 @@ func (g *Genome) Mutate(typ ma.MutationType, args interface{}) {
 		g.MutateActivation()
 	case MutationDisableConnection:
 		g.DisableConnection()
+	case MutationEnableConnection:
+		g.EnableConnection(args.(MutateArgs).FeedForward)
+	case MutationDeleteConnection:
+		g.DeleteConnection()
+	case MutationDeleteNode:
+		g.DeleteNode()
+	case MutationMutateBias:
+		g.MutateBiases()
+	case MutationChangeAggregation:
+		g.MutateAggregation()
 	default:
 		// TODO: unknown mutation type error
 		fmt.Printf("ERROR: Unknown mutation type: %d", typ)
@@ -349,7 +430,8 @@ func (e *AddConnectionError) Error() string {
 	return e.Msg
 }
 
-// TODO: Make it so feedForward actually matters
+// Without feedForward, connections can make cycles and hidden nodes can connect to themselves. Outputs are never the
+// source of a connection since node types are inferred from connections
 // TODO: This is extremely inefficient for feed-forward + large networks
 func (g *Genome) AddConnection(feedForward bool) error {
 	log.Book("Mutate add conection", log.DEBUG, log.DEBUG_ADD_CONNECTION)
@@ -376,7 +458,7 @@ func (g *Genome) AddConnection(feedForward bool) error {
 				r2 = int(g.HiddenNodes[r2])
 			}
 
-			if r2 == r1 {
+			if r2 == r1 && feedForward {
 				continue
 			} else {
 				log.Book(fmt.Sprintf("Found two nodes to try to connect, %d -> %d\n", r1, r2), log.DEBUG, log.DEBUG_ADD_CONNECTION)
@@ -398,7 +480,7 @@ func (g *Genome) AddConnection(feedForward bool) error {
 			continue
 		}
 
-		if g.checkForCycles(r1, r2) {
+		if feedForward && g.checkForCycles(r1, r2) {
 			sanity -= 1
 			continue
 		}
@@ -426,6 +508,8 @@ func (e *AddNodeError) Error() string {
 }
 
 func (g *Genome) AddNode() error {
+	g.PopulateNodeSlices()
+
 	// Randomly pick a connection to bifurcate
 	var randomGene *EdgeGene
 	sanity := 100
@@ -446,7 +530,7 @@ func (g *Genome) AddNode() error {
 	log.Book(fmt.Sprintf("Adding a node to connection: %s\n", randomGene.String()), log.DEBUG, log.DEBUG_ADD_NODE)
 
 	// Need to add a node between the two nodes of the existing connection. Figure out what to call that node
-	nextNode := uint(len(g.SensorNodes) + len(g.HiddenNodes) + len(g.OutputNodes))
+	nextNode := g.splitNodeId(randomGene)
 
 	log.Book(fmt.Sprintf("Next node is %d\n", nextNode), log.DEBUG, log.DEBUG_ADD_NODE)
 
@@ -456,9 +540,9 @@ func (g *Genome) AddNode() error {
 	new2 := NewEdgeGene(nextNode, randomGene.OutNode, randomGene.Weight, MutationAddNode)
 
 	// Now also need a random activation function
-	if g.ActivationFunctions != nil {
-		_, fn := RandomFunc()
-		g.ActivationFunctions[nextNode] = fn
+	node := NewNodeGene(nextNode, HiddenNode, defaultActivation(HiddenNode))
+	if g.MutableActivations {
+		node.Activation = g.RandomActivation(HiddenNode)
 	}
 
 	log.Book(fmt.Sprintf("New edges:\n%s\n%s\n", new1.String(), new2.String()), log.DEBUG, log.DEBUG_ADD_NODE)
@@ -467,6 +551,7 @@ func (g *Genome) AddNode() error {
 	randomGene.Enabled = false
 
 	g.Connections = append(g.Connections, []*EdgeGene{new1, new2}...)
+	g.AddNodeGene(node)
 	g.HiddenNodes = append(g.HiddenNodes, nextNode)
 
 	if log.DEBUG_ADD_NODE {
@@ -511,61 +596,134 @@ func (g *Genome) MutateWeights() {
 }
 
 func (g *Genome) MutateActivation() {
-	if g.ActivationFunctions == nil {
-		log.Book("Tried MutationChangeAFunction on a genome with nil activation function map\n", log.DEBUG, log.DEBUG_MUTATION)
+	if !g.MutableActivations || len(g.Nodes) == 0 {
+		log.Book("Tried MutationChangeAFunction on a genome without mutable activation functions\n", log.DEBUG, log.DEBUG_MUTATION)
 		return
 	}
 
 	// Select a random node with equal probability regardless of layer
-	nNodes := len(g.SensorNodes) + len(g.HiddenNodes) + len(g.OutputNodes)
-	randi := rand.Intn(nNodes)
+	node := g.Nodes[rand.Intn(len(g.Nodes))]
 
-	_, functionString := RandomFunc()
-	g.ActivationFunctions[uint(randi)] = functionString
+	node.Activation = g.RandomActivation(node.Type)
 }
 
-func (g *Genome) DisableConnection() {
-	// randi := rand.Intn(len(g.Connections))
+// Name of a random function from the genome's activation set that's allowed for the node type
+func (g *Genome) RandomActivation(typ NodeType) string {
+	_, name := RandomActivation(typ, g.ActivationSet)
+	return name
+}
+
+// Same as MutateWeights, but for the biases of hidden and output nodes
+func (g *Genome) MutateBiases() {
+	for _, node := range g.Nodes {
+		if node.Type != HiddenNode && node.Type != OutputNode {
+			continue
+		}
+
+		if rand.Intn(10) < 9 {
+			node.Bias += rand.Float64()*0.5 - 0.25
+		} else {
+			node.Bias = g.RandomWeight()
+		}
+	}
 }
 
-type boolpair []bool
+// Sensors don't aggregate anything, so only hidden and output nodes are picked
+func (g *Genome) MutateAggregation() {
+	candidates := make([]*NodeGene, 0)
+	for _, node := range g.Nodes {
+		if node.Type == HiddenNode || node.Type == OutputNode {
+			candidates = append(candidates, node)
+		}
+	}
+
+	if len(candidates) == 0 {
+		log.Book("Tried MutationChangeAggregation on a genome without hidden or output nodes\n", log.DEBUG, log.DEBUG_MUTATION)
+		return
+	}
 
+	node := candidates[rand.Intn(len(candidates))]
+	node.Aggregation = RandomAggregation()
+}
+
+// Fill in SensorNodes, HiddenNodes and OutputNodes from the node genes. Genomes built by hand without node genes get
+// them inferred from the node slices if those are set, or from the connections otherwise. Once a genome has node
+// genes, connections to nodes without one are left alone for Validate and Repair to deal with
 func (g *Genome) PopulateNodeSlices() {
-	// Make sure node slices aren't already populated
-	// Could probably just do this without the check and it wouldn't be much different
-	if len(g.SensorNodes)+len(g.HiddenNodes)+len(g.OutputNodes) > 0 {
-		g.SensorNodes = make([]uint, 0)
-		g.HiddenNodes = make([]uint, 0)
-		g.OutputNodes = make([]uint, 0)
+	if len(g.Nodes) == 0 {
+		g.inferNodeGenes()
+	}
+
+	g.SensorNodes = make([]uint, 0)
+	g.HiddenNodes = make([]uint, 0)
+	g.OutputNodes = make([]uint, 0)
+
+	for _, node := range g.Nodes {
+		switch node.Type {
+		case BiasNode, SensorNode:
+			g.SensorNodes = append(g.SensorNodes, node.ID)
+		case HiddenNode:
+			g.HiddenNodes = append(g.HiddenNodes, node.ID)
+		case OutputNode:
+			g.OutputNodes = append(g.OutputNodes, node.ID)
+		}
 	}
+}
 
-	nodeClassifications := make(map[uint]boolpair)
+func (g *Genome) inferNodeGenes() {
+	types := make(map[uint]NodeType)
 
-	for _, edge := range g.Connections {
-		if _, ok := nodeClassifications[edge.InNode]; !ok {
-			nodeClassifications[edge.InNode] = boolpair{false, false}
+	if len(g.SensorNodes)+len(g.HiddenNodes)+len(g.OutputNodes) > 0 {
+		for _, nodeId := range g.SensorNodes {
+			types[nodeId] = SensorNode
+		}
+		for _, nodeId := range g.HiddenNodes {
+			types[nodeId] = HiddenNode
+		}
+		for _, nodeId := range g.OutputNodes {
+			types[nodeId] = OutputNode
 		}
-		if _, ok := nodeClassifications[edge.OutNode]; !ok {
-			nodeClassifications[edge.OutNode] = boolpair{false, false}
+
+		// Anything else the connections use is hidden, older hand built genomes didn't always list those
+		for _, edge := range g.Connections {
+			for _, nodeId := range []uint{edge.InNode, edge.OutNode} {
+				if _, ok := types[nodeId]; !ok {
+					types[nodeId] = HiddenNode
+				}
+			}
+		}
+	} else {
+		// Nodes with only outgoing connections are sensors, only incoming are outputs, and both are hidden
+		hasIn := make(map[uint]bool)
+		hasOut := make(map[uint]bool)
+		for _, edge := range g.Connections {
+			hasOut[edge.InNode] = true
+			hasIn[edge.OutNode] = true
 		}
 
-		// Gotta do it this way or face the red squiggly underlines of doom
-		nodeClassifications[edge.InNode][0] = true
-		nodeClassifications[edge.OutNode][1] = true
+		for _, edge := range g.Connections {
+			for _, nodeId := range []uint{edge.InNode, edge.OutNode} {
+				if hasIn[nodeId] && hasOut[nodeId] {
+					types[nodeId] = HiddenNode
+				} else if hasOut[nodeId] {
+					types[nodeId] = SensorNode
+				} else {
+					types[nodeId] = OutputNode
+				}
+			}
+		}
 	}
 
-	for k, v := range nodeClassifications {
-		if v[0] && v[1] {
-			g.HiddenNodes = append(g.HiddenNodes, k)
-		} else if v[0] && !v[1] {
-			g.SensorNodes = append(g.SensorNodes, k)
-		} else if !v[0] && v[1] {
-			g.OutputNodes = append(g.OutputNodes, k)
-		} else {
-			// This should be logically impossible to reach
-			fmt.Printf("Found an orphan node during PopulateNodeSlices!\n")
+	if g.UsesBias {
+		if _, ok := types[0]; ok {
+			types[0] = BiasNode
 		}
 	}
+
+	g.Nodes = make([]*NodeGene, 0, len(types))
+	for nodeId, typ := range types {
+		g.AddNodeGene(NewNodeGene(nodeId, typ, defaultActivation(typ)))
+	}
 }
 
 func (g *Genome) DistanceFrom(gc ma.GeneticCode, cs ...float64) float64 {
@@ -585,8 +743,8 @@ func (g *Genome) DistanceFrom(gc ma.GeneticCode, cs ...float64) float64 {
 		nDisjoint float64 // disjoint genes, how many are misaligned before the last matching gene
 		nShared   float64 // shared genes, how many match in innovation number
 		N         float64 // N is the number of genes in the larger genome
-		W         float64 // average weight difference between matching genes
-		A         float64 // percent of nodes with a shared activation function
+		W         float64 // average weight difference between matching genes, plus average bias difference between shared nodes
+		A         float64 // percent of shared nodes with a different activation or aggregation function
 	)
 
 	N = math.Max(float64(len(g.Connections)), float64(len(g2.Connections)))
@@ -632,19 +790,31 @@ func (g *Genome) DistanceFrom(gc ma.GeneticCode, cs ...float64) float64 {
 		W /= nShared
 	}
 
-	// Check differences in activation functions per node
-	if g.ActivationFunctions != nil && g2.ActivationFunctions != nil {
-		gNodeCount := len(g.ActivationFunctions)
-		g2NodeCount := len(g2.ActivationFunctions)
+	// Check differences in biases, activation functions and aggregation functions per node. Activation functions only
+	// count if they can mutate
+	compareActivations := g.MutableActivations && g2.MutableActivations
+	sharedNodes := 0
+	differentFns := 0
+	B := 0.0
+	for _, node := range g.Nodes {
+		node2 := g2.Node(node.ID)
+		if node2 == nil {
+			continue
+		}
+
+		sharedNodes += 1
+		B += math.Abs(node.Bias - node2.Bias)
 
-		matchingFns := 0
-		for k, v := range g.ActivationFunctions {
-			if v2, ok := g2.ActivationFunctions[k]; ok && v2 == v {
-				matchingFns += 1
-			}
+		sameActivation := !compareActivations || node.Activation == node2.Activation
+		sameAggregation := AggregationByName(node.Aggregation) == AggregationByName(node2.Aggregation)
+		if !sameActivation || !sameAggregation {
+			differentFns += 1
 		}
+	}
 
-		A = float64(matchingFns) / math.Max(float64(gNodeCount), float64(g2NodeCount))
+	if sharedNodes > 0 {
+		W += B / float64(sharedNodes)
+		A = float64(differentFns) / float64(sharedNodes)
 	}
 
 	// Should never be negative, but take absolute value just in case
@@ -677,6 +847,29 @@ func (g *Genome) SortConnections() {
 	sort.Sort(sg)
 }
 
+// Weights of all enabled connections, in the order of g.Connections
+func (g *Genome) Weights() []float64 {
+	weights := make([]float64, 0, len(g.Connections))
+	for _, edgeGene := range g.Connections {
+		if edgeGene.Enabled {
+			weights = append(weights, edgeGene.Weight)
+		}
+	}
+
+	return weights
+}
+
+// Inverse of Weights, topology is left unchanged
+func (g *Genome) SetWeights(weights []float64) {
+	i := 0
+	for _, edgeGene := range g.Connections {
+		if edgeGene.Enabled {
+			edgeGene.Weight = weights[i]
+			i += 1
+		}
+	}
+}
+
 func (g *Genome) RandomWeight() float64 {
 	rng := g.MaxWeight - g.MinWeight
 	return rand.Float64()*rng + g.MinWeight
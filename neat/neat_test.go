@@ -1,7 +1,20 @@
 package neat
 
 import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/TylerLeite/neuro-q/ma"
 )
 
 // Initialize population with no hidden layers
@@ -60,6 +73,132 @@ func TestMassiveDraw(t *testing.T) {
 	network.Draw("test_massive.bmp")
 }
 
+// XOR topology with one hidden node, weights are left for the caller to fill in
+func xorTopology(weights []float64) *Genome {
+	ResetInnovationHistory()
+
+	return &Genome{
+		SensorNodes: []uint{0, 1, 2},
+		OutputNodes: []uint{3},
+		HiddenNodes: []uint{4},
+		Connections: []*EdgeGene{
+			NewEdgeGene(0, 3, weights[0], NoMutation),
+			NewEdgeGene(1, 3, weights[1], NoMutation),
+			NewEdgeGene(2, 3, weights[2], NoMutation),
+			NewEdgeGene(0, 4, weights[3], NoMutation),
+			NewEdgeGene(1, 4, weights[4], NoMutation),
+			NewEdgeGene(2, 4, weights[5], NoMutation),
+			NewEdgeGene(4, 3, weights[6], NoMutation),
+		},
+
+		UsesBias:  true,
+		MinWeight: -5,
+		MaxWeight: 5,
+	}
+}
+
+func TestWeightSearch(t *testing.T) {
+	rand.Seed(1)
+
+	names := []string{"(1+1)-ES", "sep-CMA-ES"}
+	strategies := []ma.LocalSearchStrategy{OnePlusOneES, SepCMAES}
+
+	for i, strategy := range strategies {
+		name := names[i]
+		weights := make([]float64, 7)
+		for i := range weights {
+			weights[i] = rand.Float64()*2 - 1
+		}
+		network := NewNetwork(xorTopology(weights), nil)
+
+		p := ma.NewPopulation(network, XorFitness)
+		p.LocalSearchGenerations = 200
+		p.LocalSearchStrategy = strategy
+		network.Population = p
+
+		before := XorFitness(network)
+		improved := strategy(p, network).(*Network)
+		after := XorFitness(improved)
+
+		if after <= before {
+			t.Errorf("%s did not improve fitness: %.4g -> %.4g", name, before, after)
+		}
+
+		if len(improved.DNA.Connections) != len(network.DNA.Connections) {
+			t.Errorf("%s changed the topology", name)
+		}
+	}
+}
+
+func TestBackprop(t *testing.T) {
+	rand.Seed(2)
+
+	weights := make([]float64, 7)
+	for i := range weights {
+		weights[i] = rand.Float64()*2 - 1
+	}
+
+	inputs := [][]float64{{0, 0}, {0, 1}, {1, 0}, {1, 1}}
+	targets := [][]float64{{0}, {1}, {1}, {0}}
+
+	for _, optimizer := range []Optimizer{SGD, Adam} {
+		network := NewNetwork(xorTopology(weights), nil)
+
+		args := DefaultTrainingArgs()
+		args.Optimizer = optimizer
+		args.Epochs = 1
+		before, err := network.Train(inputs, targets, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args.Epochs = 2000
+		if optimizer == SGD {
+			args.LearningRate = 0.1
+		}
+		after, err := network.Train(inputs, targets, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if after >= before {
+			t.Errorf("optimizer %d did not reduce the error: %.4g -> %.4g", optimizer, before, after)
+		}
+
+		// Lamarckian training writes weights back into the genome
+		for _, edge := range network.Edges {
+			if edge.Weight != edge.Gene.Weight {
+				t.Errorf("edge %s was not written back into its gene", edge.String())
+			}
+		}
+	}
+}
+
+func TestActivateFeedForward(t *testing.T) {
+	w := []float64{0.5, -1, 2, 0.25, 3, -3, 1.5}
+	network := NewNetwork(xorTopology(w), nil)
+
+	for x := 0.0; x <= 1; x += 1 {
+		for y := 0.0; y <= 1; y += 1 {
+			// Bias is added before the activation function
+			hidden := NEATSigmoidFunc(w[4]*x + w[5]*y + w[3])
+			expected := NEATSigmoidFunc(w[1]*x + w[2]*y + w[6]*hidden + w[0])
+
+			out := network.ActivateFeedForward([]float64{1, x, y})
+			if math.Abs(out[0]-expected) > 1e-9 {
+				t.Errorf("f(%g, %g) = %.6g, expected %.6g", x, y, out[0], expected)
+			}
+		}
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		network.ActivateFeedForward([]float64{1, 0, 1})
+	})
+	if allocs > 0 {
+		t.Errorf("ActivateFeedForward allocated %.0f times per run", allocs)
+	}
+}
+
 // func TestXor(t *testing.T) {
 
 // 	var fitness float64
@@ -97,3 +236,714 @@ func TestMassiveDraw(t *testing.T) {
 // 	fitness = XorFitness(ma.Organism(network))
 // 	fmt.Printf("Fitness of manual xor solution: %.2g\n", fitness)
 // }
+
+func TestRecurrent(t *testing.T) {
+	// Hidden node 2 accumulates its input through a self-loop
+	genome := &Genome{
+		Connections: []*EdgeGene{
+			NewEdgeGene(0, 2, 1, NoMutation),
+			NewEdgeGene(2, 2, 1, NoMutation),
+			NewEdgeGene(2, 1, 1, NoMutation),
+		},
+		Recurrent:       true,
+		RelaxationSteps: 3,
+	}
+	genome.PopulateNodeSlices()
+	for _, node := range genome.Nodes {
+		node.Activation = IdentityStr
+	}
+
+	network := NewNetwork(genome, nil)
+	if !network.IsRecurrent() {
+		t.Fatal("network with a self-loop should be recurrent")
+	}
+
+	for step := 1; step <= 3; step += 1 {
+		out := network.Step([]float64{1})
+		if out[0] != float64(step) {
+			t.Errorf("step %d: got %g", step, out[0])
+		}
+	}
+
+	network.Reset()
+	if out := network.Step([]float64{2}); out[0] != 2 {
+		t.Errorf("after Reset: got %g, expected 2", out[0])
+	}
+
+	// Stateless activation relaxes for RelaxationSteps steps from a clean state
+	if out := network.ActivateFeedForward([]float64{1}); out[0] != 3 {
+		t.Errorf("relaxed: got %g, expected 3", out[0])
+	}
+
+	// Recurrent mutations should never break activation
+	genome = NewGenome(2, 1, true, -1, 1)
+	genome.Recurrent = true
+	for i := 0; i < 10; i += 1 {
+		genome.AddNode()
+	}
+	for i := 0; i < 50; i += 1 {
+		genome.AddConnection(false)
+	}
+
+	network = NewNetwork(genome, nil)
+	out := network.ActivateFeedForward([]float64{1, 0.5, -0.5})
+	if math.IsNaN(out[0]) {
+		t.Errorf("recurrent network produced NaN")
+	}
+}
+
+func TestPredict(t *testing.T) {
+	w := []float64{0.5, -1, 2, 0.25, 3, -3, 1.5}
+	network := NewNetwork(xorTopology(w), nil)
+
+	inputs := [][]float64{{0, 0}, {0, 1}, {1, 0}, {1, 1}}
+	predictions, err := network.PredictBatch(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, sample := range inputs {
+		expected := network.ActivateFeedForward([]float64{1, sample[0], sample[1]})
+		if predictions[i][0] != expected[0] {
+			t.Errorf("Predict(%v) = %g, expected %g", sample, predictions[i][0], expected[0])
+		}
+	}
+
+	if _, err := network.Predict([]float64{1, 0, 1}); err == nil {
+		t.Errorf("Predict should not accept a value for the bias")
+	}
+}
+
+func TestNodeGenes(t *testing.T) {
+	ResetInnovationHistory()
+
+	genome := NewGenomeWithHiddenNodes(2, 1, 1, true, -1, 1)
+	expected := []NodeType{BiasNode, SensorNode, SensorNode, OutputNode, HiddenNode}
+	if len(genome.Nodes) != len(expected) {
+		t.Fatalf("expected %d node genes, got %d", len(expected), len(genome.Nodes))
+	}
+	for i, typ := range expected {
+		if genome.Nodes[i].ID != uint(i) || genome.Nodes[i].Type != typ {
+			t.Errorf("node %d is %s", i, genome.Nodes[i].String())
+		}
+	}
+
+	// Seed hidden node should be wired in
+	network := NewNetwork(genome, nil)
+	hidden := network.NodeById(4)
+	if len(hidden.In) == 0 || len(hidden.Out) == 0 {
+		t.Errorf("seed hidden node is not connected")
+	}
+
+	// Hidden nodes survive having all of their connections disabled
+	copied := genome.Copy().(*Genome)
+	for _, edgeGene := range copied.Connections {
+		if edgeGene.InNode == 4 || edgeGene.OutNode == 4 {
+			edgeGene.Enabled = false
+		}
+	}
+	network = NewNetwork(copied, nil)
+	if len(network.Nodes) != len(expected) || network.NodeById(4) == nil {
+		t.Errorf("lost a node gene after disabling its connections")
+	}
+
+	copied.Nodes[4].Activation = SinStr
+	if genome.Nodes[4].Activation == SinStr {
+		t.Errorf("Copy did not copy node genes")
+	}
+
+	genome.MutableActivations = true
+	if d := genome.DistanceFrom(genome.Copy(), 1, 1, 1, 1); d != 0 {
+		t.Errorf("distance from a copy should be 0, got %g", d)
+	}
+}
+
+func TestStructuralMutations(t *testing.T) {
+	ResetInnovationHistory()
+	rand.Seed(2)
+
+	genome := NewGenomeWithHiddenNodes(3, 2, 2, true, -1, 1)
+	for i := 0; i < 10; i += 1 {
+		genome.AddNode()
+		genome.AddConnection(true)
+	}
+
+	mutations := []ma.MutationType{MutationDisableConnection, MutationEnableConnection, MutationDeleteConnection, MutationDeleteNode}
+	for i := 0; i < 200; i += 1 {
+		genome.Mutate(mutations[rand.Intn(len(mutations))], MutateArgs{FeedForward: true})
+
+		if disconnected := genome.disconnectedNodes(); len(disconnected) > 0 {
+			t.Fatalf("nodes %v got disconnected:\n%s", disconnected, genome.ToPretty())
+		}
+
+		for _, edgeGene := range genome.Connections {
+			if genome.Node(edgeGene.InNode) == nil || genome.Node(edgeGene.OutNode) == nil {
+				t.Fatalf("connection %s uses a deleted node", edgeGene.String())
+			}
+		}
+
+		if NewNetwork(genome.Copy().(*Genome), nil).IsRecurrent() {
+			t.Fatalf("feed-forward genome grew a cycle")
+		}
+	}
+
+	if len(genome.SensorNodes) != 1+3 || len(genome.OutputNodes) != 2 {
+		t.Errorf("sensors or outputs were deleted")
+	}
+
+	// Deleting the newest hidden node and adding one shouldn't hand its id out again
+	genome = NewGenome(2, 1, true, -1, 1)
+	genome.AddNode()
+	genome.AddNode()
+	deleted := genome.Nodes[len(genome.Nodes)-1].ID
+	genome.removeNode(deleted)
+
+	genome.AddNode()
+	if added := genome.Nodes[len(genome.Nodes)-1].ID; added <= deleted {
+		t.Errorf("new node reused id %d, deleted node was %d", added, deleted)
+	}
+}
+
+func TestMutationOdds(t *testing.T) {
+	odds := NewGenome(2, 1, true, -1, 1).MutationOdds()
+
+	const samples = 200000
+	counts := make(map[ma.MutationType]float64)
+	for i := 0; i < samples; i += 1 {
+		counts[pickMutation(odds, rand.Float64())] += 1
+	}
+
+	for mutation, p := range odds {
+		// Several standard deviations of a binomial, plenty for a test that shouldn't flake
+		tolerance := 5 * math.Sqrt(p*(1-p)/samples)
+		if got := counts[mutation] / samples; math.Abs(got-p) > tolerance {
+			t.Errorf("%s picked %.4f of the time, expected %.4f", MutationTypeString[mutation], got, p)
+		}
+	}
+}
+
+func TestBiasAndAggregation(t *testing.T) {
+	w := []float64{0.5, -1, 2, 0.25, 3, -3, 1.5}
+	genome := xorTopology(w)
+	genome.PopulateNodeSlices()
+
+	genome.Node(4).Aggregation = ProductAggregationStr
+	genome.Node(4).Bias = 0.3
+	genome.Node(3).Aggregation = MaxAggregationStr
+	genome.Node(3).Bias = -0.2
+
+	network := NewNetwork(genome, nil)
+	for x := 0.0; x <= 1; x += 1 {
+		for y := 0.0; y <= 1; y += 1 {
+			// Bias node edges and node biases are both added after aggregation
+			hidden := NEATSigmoidFunc((w[4]*x)*(w[5]*y) + w[3] + 0.3)
+			expected := NEATSigmoidFunc(math.Max(w[1]*x, math.Max(w[2]*y, w[6]*hidden)) + w[0] - 0.2)
+
+			out, err := network.Predict([]float64{x, y})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(out[0]-expected) > 1e-9 {
+				t.Errorf("f(%g, %g) = %.6g, expected %.6g", x, y, out[0], expected)
+			}
+		}
+	}
+
+	// Different aggregation functions make genomes further apart
+	other := genome.Copy().(*Genome)
+	other.Node(4).Aggregation = MeanAggregationStr
+	if genome.DistanceFrom(other, 1, 1, 1, 1) <= 0 {
+		t.Errorf("changing an aggregation function should change the distance")
+	}
+	if genome.String() == other.String() {
+		t.Errorf("genomes with different aggregation functions have the same string")
+	}
+
+	// Evolution with the shipped odds should actually get around to both
+	ResetInnovationHistory()
+	rand.Seed(4)
+	parity := Parity(2)
+	p := parity.population(parity.Fitness)
+	p.Generate()
+
+	// Selection can throw them out again, so look at every generation
+	biased, aggregated := false, false
+	for i := 0; i < 30 && !(biased && aggregated); i += 1 {
+		if _, _, err := p.Epoch(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, o := range p.Members() {
+			for _, node := range o.(*Network).DNA.Nodes {
+				biased = biased || node.Bias != 0
+				aggregated = aggregated || (node.Aggregation != "" && node.Aggregation != SumAggregationStr)
+			}
+		}
+	}
+	if !biased || !aggregated {
+		t.Errorf("evolution didn't change biases (%t) or aggregations (%t)", biased, aggregated)
+	}
+}
+
+func TestActivationRegistry(t *testing.T) {
+	err := RegisterActivation(Activation{
+		Name:       "softplus",
+		Fn:         func(x float64) float64 { return math.Log1p(math.Exp(x)) },
+		Derivative: SigmoidFunc,
+		NodeTypes:  []NodeType{HiddenNode},
+		Weight:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterActivation(Activation{Name: "softplus", Fn: IdentityFunc}); err == nil {
+		t.Errorf("registering a function twice should fail")
+	}
+
+	if math.Abs(FuncByName("softplus")(0)-math.Log(2)) > 1e-12 {
+		t.Errorf("softplus(0) = %g", FuncByName("softplus")(0))
+	}
+	if rep := RepByName("softplus"); rep == "" || rep == IdentityRep {
+		t.Errorf("softplus got rep %q", rep)
+	}
+	if _, ok := DerivativeByName(StepStr); ok {
+		t.Errorf("step shouldn't be differentiable")
+	}
+
+	// Every built in function should be reachable, and the set should restrict the draw
+	seen := make(map[string]bool)
+	for i := 0; i < 5000; i += 1 {
+		_, name := RandomFunc()
+		seen[name] = true
+	}
+	for _, name := range RegisteredActivations() {
+		if !seen[name] {
+			t.Errorf("RandomFunc never picked %s", name)
+		}
+	}
+
+	set := []string{"softplus", GaussianStr}
+	for i := 0; i < 100; i += 1 {
+		if _, name := RandomActivation(OutputNode, set); name != GaussianStr {
+			t.Fatalf("drew %s for an output node, softplus is hidden only", name)
+		}
+	}
+
+	// Species mutate in parallel, run with -race to check drawing is safe
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i += 1 {
+		wg.Add(1)
+		go func() {
+			for j := 0; j < 100; j += 1 {
+				RandomActivation(HiddenNode, set)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
+
+func validationProblems(genome *Genome) map[string]bool {
+	found := make(map[string]bool)
+	for _, err := range genome.Validate() {
+		switch err.(type) {
+		case *CycleError:
+			found["cycle"] = true
+		case *SelfLoopError:
+			found["self-loop"] = true
+		case *DanglingNodeError:
+			found["dangling"] = true
+		case *DuplicateInnovationError:
+			found["duplicate"] = true
+		case *DisconnectedOutputError:
+			found["disconnected"] = true
+		}
+	}
+
+	return found
+}
+
+func TestValidateAndRepair(t *testing.T) {
+	genome := xorTopology([]float64{0.5, -1, 2, 0.25, 3, -3, 1.5})
+	genome.PopulateNodeSlices()
+
+	if errs := genome.Validate(); errs != nil {
+		t.Fatalf("xor genome should be valid, got %v", errs)
+	}
+
+	for _, edgeGene := range genome.Connections {
+		if edgeGene.OutNode == 3 {
+			edgeGene.Enabled = false
+		}
+	}
+	genome.Connections = append(genome.Connections,
+		NewEdgeGene(4, 4, 1, NoMutation),
+		NewEdgeGene(4, 9, 1, NoMutation),
+		genome.Connections[3].Copy(),
+	)
+
+	// Compiling skips the dangling connection but shouldn't paper over it
+	NewNetwork(genome, nil).Compile()
+
+	found := validationProblems(genome)
+	for _, kind := range []string{"self-loop", "dangling", "duplicate", "disconnected"} {
+		if !found[kind] {
+			t.Errorf("Validate didn't find the %s", kind)
+		}
+	}
+
+	// Reconnect the output through the hidden node and close a loop
+	genome.Connections[6].Enabled = true
+	genome.Connections = append(genome.Connections, NewEdgeGene(3, 4, 1, NoMutation))
+	if !validationProblems(genome)["cycle"] {
+		t.Errorf("Validate didn't find the cycle")
+	}
+
+	// Recurrent genomes are allowed to have both
+	genome.Recurrent = true
+	if found := validationProblems(genome); found["cycle"] || found["self-loop"] {
+		t.Errorf("recurrent genomes can have cycles and self-loops")
+	}
+	genome.Recurrent = false
+
+	if errs := genome.Repair(); errs != nil {
+		t.Fatalf("Repair left errors: %v", errs)
+	}
+
+	network := NewNetwork(genome, nil)
+	if _, err := network.Predict([]float64{1, 0}); err != nil {
+		t.Errorf("repaired genome doesn't run: %v", err)
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	genome := xorTopology([]float64{0.5, -1, 2, 0.25, 3, 1e-9, 1.5})
+	genome.PopulateNodeSlices()
+
+	// Identity node between sensor 1 and the output, a dead end, and a disabled gene
+	genome.AddNodeGene(NewNodeGene(5, HiddenNode, IdentityStr))
+	genome.AddNodeGene(NewNodeGene(6, HiddenNode, NEATSigmoidStr))
+	disabled := NewEdgeGene(2, 6, 1, NoMutation)
+	disabled.Enabled = false
+	genome.Connections = append(genome.Connections,
+		NewEdgeGene(1, 5, 2, NoMutation),
+		NewEdgeGene(5, 3, -0.5, NoMutation),
+		NewEdgeGene(1, 6, 1, NoMutation),
+		disabled,
+	)
+
+	network := NewNetwork(genome, nil)
+	simplified, err := network.SimplifyAndVerify(1e-6, xorInputs, 1e-6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := simplified.DNA
+	if s.Node(5) != nil || s.Node(6) != nil {
+		t.Errorf("identity node and dead end should be gone, nodes are %v", s.Nodes)
+	}
+	if len(s.Connections) != 6 {
+		t.Errorf("expected 6 connections after simplifying, got %d: %v", len(s.Connections), s.Connections)
+	}
+	if errs := s.Validate(); errs != nil {
+		t.Errorf("simplified genome isn't valid: %v", errs)
+	}
+
+	// Dropping big weights should be caught by the verification
+	if _, err := network.SimplifyAndVerify(10, xorInputs, 1e-6); err == nil {
+		t.Errorf("dropping every weight should change the outputs")
+	}
+}
+
+func TestWriteDOTAndSVG(t *testing.T) {
+	genome := xorTopology([]float64{0.5, -1, 2, 0.25, 3, -3, 1.5})
+	genome.PopulateNodeSlices()
+	genome.Connections[1].Enabled = false
+
+	// Ids past 999 and activation names are what the bitmap can't draw
+	genome.AddNodeGene(NewNodeGene(1234, HiddenNode, GaussianStr))
+	genome.Connections = append(genome.Connections, NewEdgeGene(4, 1234, 1, NoMutation), NewEdgeGene(1234, 3, -1, NoMutation))
+
+	network := NewNetwork(genome, nil)
+
+	var dot bytes.Buffer
+	if err := network.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"digraph", "n1234 -> n3", "style=dashed", GaussianStr} {
+		if !strings.Contains(dot.String(), expected) {
+			t.Errorf("DOT output is missing %q:\n%s", expected, dot.String())
+		}
+	}
+
+	// Registered names can have quotes and backslashes in them. Tiny weight so other tests never pick it
+	const awkward = `say "hi" \ bye`
+	if _, ok := ActivationByName(awkward); !ok {
+		if err := RegisterActivation(Activation{Name: awkward, Fn: IdentityFunc, Weight: 1e-12}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quoted := genome.Copy().(*Genome)
+	quoted.Node(1234).Activation = awkward
+	dot.Reset()
+	if err := NewNetwork(quoted, nil).WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dot.String(), `say \"hi\" \\ bye`) {
+		t.Errorf("DOT output didn't escape the activation name:\n%s", dot.String())
+	}
+
+	var svg bytes.Buffer
+	if err := network.WriteSVG(&svg); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"1234", "stroke-dasharray", "innovation", GaussianStr} {
+		if !strings.Contains(svg.String(), expected) {
+			t.Errorf("SVG output is missing %q", expected)
+		}
+	}
+
+	decoder := xml.NewDecoder(&svg)
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("SVG isn't valid XML: %v", err)
+		}
+	}
+}
+
+// Generate Go for the network and its test into a fresh module, then run the generated test
+func testGeneratedGo(t *testing.T, network *Network, inputs [][]float64) {
+	dir := t.TempDir()
+
+	files := map[string]func(*os.File) error{
+		"eval.go": func(f *os.File) error {
+			return network.WriteGo(f, "champion", "Eval")
+		},
+		"eval_test.go": func(f *os.File) error {
+			return network.WriteGoTest(f, "champion", "Eval", inputs)
+		},
+		"go.mod": func(f *os.File) error {
+			_, err := f.WriteString("module champion\n\ngo 1.16\n")
+			return err
+		},
+	}
+
+	for name, write := range files {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := write(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	cmd := exec.Command("go", "test", "./...")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		source, _ := os.ReadFile(filepath.Join(dir, "eval.go"))
+		t.Errorf("generated code failed its test: %v\n%s\n%s", err, out, source)
+	}
+}
+
+func TestWriteGo(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil || testing.Short() {
+		t.Skip("needs the go tool")
+	}
+
+	genome := xorTopology([]float64{0.5, -1, 2, 0.25, 3, -3, 1.5})
+	genome.PopulateNodeSlices()
+	genome.Node(4).Aggregation = ProductAggregationStr
+	genome.Node(4).Activation = GaussianStr
+	genome.Node(3).Aggregation = MaxAggregationStr
+	genome.Node(3).Bias = -0.2
+
+	inputs := append([][]float64{{0.3, -0.7}, {12, -40}}, xorInputs...)
+	testGeneratedGo(t, NewNetwork(genome, nil), inputs)
+
+	// Output feeds back into the hidden node
+	recurrent := genome.Copy().(*Genome)
+	recurrent.Recurrent = true
+	recurrent.RelaxationSteps = 4
+	recurrent.Connections = append(recurrent.Connections, NewEdgeGene(3, 4, 0.7, NoMutation), NewEdgeGene(4, 4, -0.4, NoMutation))
+
+	network := NewNetwork(recurrent, nil)
+	if !network.IsRecurrent() {
+		t.Fatal("network should be recurrent")
+	}
+	testGeneratedGo(t, network, inputs)
+}
+
+func TestExpressions(t *testing.T) {
+	genome := xorTopology([]float64{0.5, -1, 2, 0.25, 3, -3, 1.5})
+	genome.PopulateNodeSlices()
+	genome.Node(4).Activation = SinStr
+	genome.Node(4).Aggregation = MaxAggregationStr
+	genome.Node(3).Bias = 0.1
+
+	// Linear chain 1 -> 5 -> 6 -> 3 should collapse into a single weight
+	genome.AddNodeGene(NewNodeGene(5, HiddenNode, IdentityStr))
+	genome.AddNodeGene(NewNodeGene(6, HiddenNode, IdentityStr))
+	genome.Connections = append(genome.Connections,
+		NewEdgeGene(1, 5, 2, NoMutation),
+		NewEdgeGene(5, 6, 0.5, NoMutation),
+		NewEdgeGene(6, 3, 0.25, NoMutation),
+	)
+
+	network := NewNetwork(genome, nil)
+	expressions, err := network.SimplifiedExpressions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "nsigmoid(-0.75*x + 2*y + 1.5*sin(max(3*x, -3*y) + 0.25) + 0.6)"
+	if expressions[0].String() != expected {
+		t.Errorf("expected %s, got %s", expected, expressions[0].String())
+	}
+	if !strings.Contains(expressions[0].LaTeX(), `\sin\left(\max\left(3 \cdot x, -3 \cdot y\right)`) {
+		t.Errorf("unexpected LaTeX %s", expressions[0].LaTeX())
+	}
+
+	// Recurrent networks get unrolled
+	recurrent := genome.Copy().(*Genome)
+	recurrent.Recurrent = true
+	recurrent.RelaxationSteps = 3
+	recurrent.Connections = append(recurrent.Connections, NewEdgeGene(3, 4, 0.7, NoMutation))
+
+	for _, network := range []*Network{network, NewNetwork(recurrent, nil)} {
+		raw, _ := network.Expressions([]string{"a", "b"})
+		simplified, _ := network.SimplifiedExpressions([]string{"a", "b"})
+
+		for _, input := range append([][]float64{{0.3, -0.7}}, xorInputs...) {
+			out, _ := network.Predict(input)
+			variables := map[string]float64{"a": input[0], "b": input[1]}
+
+			if got := raw[0].Evaluate(variables); got != out[0] {
+				t.Errorf("expression gives %g for %v, network gives %g", got, input, out[0])
+			}
+			if got := simplified[0].Evaluate(variables); math.Abs(got-out[0]) > 1e-12 {
+				t.Errorf("simplified expression gives %g for %v, network gives %g", got, input, out[0])
+			}
+		}
+	}
+}
+
+func TestSupervisedTask(t *testing.T) {
+	rand.Seed(2)
+
+	// Two blobs, labelled by which side of x = 10 they're on
+	csvText := "x,label,y\n"
+	for i := 0; i < 100; i += 1 {
+		label := "a"
+		x := 5 + rand.Float64()*4
+		if i%2 == 1 {
+			label = "b"
+			x = 11 + rand.Float64()*4
+		}
+		csvText += fmt.Sprintf("%g,%s,%g\n", x, label, rand.Float64())
+	}
+
+	d, err := ReadCSV(strings.NewReader(csvText), Classification, true, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() != 100 || len(d.Features[0]) != 2 || len(d.Classes) != 2 || d.TargetNames[0] != "label" {
+		t.Fatalf("dataset read wrong: %d rows, %d features, classes %v", d.Len(), len(d.Features[0]), d.Classes)
+	}
+
+	task := NewSupervisedTask(d, Classification, 0.6, 0.2, 1)
+	if task.Train.Len() != 60 || task.Validation.Len() != 20 || task.Test.Len() != 20 {
+		t.Errorf("bad split sizes %d/%d/%d", task.Train.Len(), task.Validation.Len(), task.Test.Len())
+	}
+
+	// Overshooting fractions shouldn't slice out of range
+	train, validation, test := d.Split(1.5, 0.2, 1)
+	if train.Len() != 100 || validation.Len() != 0 || test.Len() != 0 {
+		t.Errorf("bad overshot split sizes %d/%d/%d", train.Len(), validation.Len(), test.Len())
+	}
+
+	mean := 0.0
+	for _, features := range task.Train.Features {
+		mean += features[0] / float64(task.Train.Len())
+	}
+	if math.Abs(mean) > 1e-9 {
+		t.Errorf("normalized train features should have mean 0, got %g", mean)
+	}
+
+	// A network that only looks at x separates the blobs
+	ResetInnovationHistory()
+	genome := NewGenome(2, 1, true, -5, 5)
+	for _, edgeGene := range genome.Connections {
+		edgeGene.Weight = 0
+		if edgeGene.InNode == 1 {
+			edgeGene.Weight = 5
+		}
+	}
+
+	_, accuracy := task.Evaluate(NewNetwork(genome, nil), task.Test)
+	if accuracy != 1 {
+		t.Errorf("expected a perfect test accuracy, got %g", accuracy)
+	}
+
+	// Too few outputs for a regression target is a bad fit, not a panic
+	regression := NewSupervisedTask(d, Regression, 0.6, 0.2, 1)
+	regression.Train.Targets = make([][]float64, regression.Train.Len())
+	for i := range regression.Train.Targets {
+		regression.Train.Targets[i] = []float64{0, 1}
+	}
+	if loss, _ := regression.Evaluate(NewNetwork(genome, nil), regression.Train); !math.IsInf(loss, 1) {
+		t.Errorf("expected an infinite loss for a missing output, got %g", loss)
+	}
+
+	// Short run to make sure the reports come out
+	seed := NewNetwork(NewGenome(2, 1, true, -5, 5), nil)
+	p := ma.NewPopulation(seed, task.Fitness)
+	seed.Population = p
+	p.Size = 30
+	p.Generate()
+
+	champion, reports, err := task.Evolve(p, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if champion == nil || len(reports) != 3 {
+		t.Errorf("expected a champion and 3 reports, got %v and %d", champion, len(reports))
+	}
+}
+
+func TestBenchmarks(t *testing.T) {
+	for _, b := range Benchmarks() {
+		if len(b.Inputs) != len(b.Targets) || b.Required <= 0 || b.Required > len(b.Inputs) {
+			t.Errorf("%s is malformed", b.Name)
+		}
+	}
+
+	if n := len(Multiplexer(3).Inputs); n != 2048 {
+		t.Errorf("11-multiplexer should have 2048 cases, got %d", n)
+	}
+
+	// Parity(2) is XOR, so a hand built XOR network solves it
+	xorNetwork := NewNetwork(xorTopology([]float64{-2.3, 4.6, 4.6, -6.9, 4.6, 4.6, -9.6}), nil)
+	parity := Parity(2)
+	if XorVerify(xorNetwork) != 4 {
+		t.Fatal("hand built XOR network is wrong")
+	}
+	if !parity.Solved(xorNetwork) || !math.IsInf(parity.Fitness(xorNetwork), 1) {
+		t.Errorf("XOR network should solve 2-bit parity, passed %d/4", parity.Verify(xorNetwork))
+	}
+
+	result := parity.Run([]int64{1, 2}, 3, func(p *ma.Population) {
+		p.Size = 30
+	})
+	if result.Runs != 2 || result.Solved > 2 {
+		t.Errorf("bad bookkeeping: %s", result)
+	}
+	if result.Solved > 0 && (result.MeanGenerations < 1 || result.MeanEvaluations < 30) {
+		t.Errorf("solved runs should count generations and evaluations: %s", result)
+	}
+}
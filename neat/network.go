@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 
 	"github.com/TylerLeite/neuro-q/log"
 	"github.com/TylerLeite/neuro-q/ma"
@@ -26,6 +27,10 @@ type Network struct {
 	DNA        *Genome
 	isCompiled bool
 
+	nodeIndex map[*Node]int   // Position of each node in Nodes
+	nodeIds   map[uint]int    // Position in Nodes by node id
+	plan      *evaluationPlan // Also holds the state of recurrent networks
+
 	// Because fitness function is defined on populations and crossover is defined on organisms, need a reference here
 	// TODO: maybe add crossover as a function member of population like fitness is?
 	Population *ma.Population
@@ -67,49 +72,50 @@ func (n *Network) Copy() ma.Organism {
 	return ma.Organism(out)
 }
 
+// Roulette wheel over the odds, r is uniform in [0, 1). Goes through the mutations in a fixed order so the result only
+// depends on r, and falls back to adding a node if the odds add up to less than r
+func pickMutation(odds map[ma.MutationType]float64, r float64) ma.MutationType {
+	mutations := make([]ma.MutationType, 0, len(odds))
+	for k := range odds {
+		mutations = append(mutations, k)
+	}
+	sort.Slice(mutations, func(i, j int) bool {
+		return mutations[i] < mutations[j]
+	})
+
+	for _, k := range mutations {
+		r -= odds[k]
+		if r < 0 {
+			return k
+		}
+	}
+
+	return MutationAddNode
+}
+
 func (n *Network) RandomNeighbor() ma.Organism {
 	neighbor := n.Copy()
 	n.isCompiled = false
 
-	// TODO: get from config
 	args := MutateArgs{
-		FeedForward: true,
+		FeedForward: !n.DNA.Recurrent,
 	}
 
-	r := rand.Float64()
-	mutation := MutationAddNode
+	mutation := pickMutation(n.DNA.MutationOdds(), rand.Float64())
 
-	for k, v := range n.DNA.MutationOdds() {
-		r -= v
-		if r <= 0 {
-			mutation = k
+	genome := neighbor.GeneticCode().(*Genome)
+	genome.Mutate(mutation, args)
+
+	if genome.AutoRepair {
+		if errs := genome.Repair(); errs != nil {
+			log.Book(fmt.Sprintf("Mutation made a genome that couldn't be repaired: %v\n", errs), log.DEBUG, log.DEBUG_MUTATION)
 		}
 	}
 
-	neighbor.GeneticCode().Mutate(mutation, args)
-
 	// Check validity
 	if log.DEBUG_MUTATION {
-		neighbor.Compile()
-		network := neighbor.(*Network)
-
-		// Check for disconnected nodes
-		foundErrors := false
-		for _, node := range network.Nodes {
-			if len(node.In) == 0 && len(node.Out) == 0 {
-				foundErrors = true
-				break
-			}
-		}
-
-		for _, edge := range network.Edges {
-			if edge.In == edge.Out {
-				foundErrors = true
-			}
-		}
-
-		if foundErrors {
-			log.Book(fmt.Sprintf("vvvvvvvvvv\n%s\n %s\n", network.String(), neighbor.GeneticCode().String()), log.DEBUG_MUTATION)
+		if errs := genome.Validate(); errs != nil {
+			log.Book(fmt.Sprintf("vvvvvvvvvv\n%v\n %s\n", errs, genome.String()), log.DEBUG_MUTATION)
 			panic("Found errors in RandomNeighbor()")
 		}
 	}
@@ -124,20 +130,6 @@ func (n *Network) NewFromGeneticCode(geneticCode ma.GeneticCode) ma.Organism {
 }
 
 func (n *Network) Crossover(others []ma.Organism) ma.Organism {
-	insertActivation := func(child, parent *Genome, i int) {
-		if parent.ActivationFunctions != nil {
-			inNode := parent.Connections[i].InNode
-			outNode := parent.Connections[i].OutNode
-			if _, ok := child.ActivationFunctions[inNode]; !ok {
-				child.ActivationFunctions[inNode] = parent.ActivationFunctions[inNode]
-			}
-
-			if _, ok := child.ActivationFunctions[outNode]; !ok {
-				child.ActivationFunctions[outNode] = parent.ActivationFunctions[outNode]
-			}
-		}
-	}
-
 	// TODO: either check to make sure others is only 1 element long or support N >= 1 parents
 	n2 := others[0].(*Network)
 
@@ -160,16 +152,25 @@ func (n *Network) Crossover(others []ma.Organism) ma.Organism {
 	g2.SortConnections()
 
 	g := &Genome{
+		Nodes:       make([]*NodeGene, 0),
 		Connections: make([]*EdgeGene, 0),
 		SensorNodes: make([]uint, 0),
 		HiddenNodes: make([]uint, 0),
 		OutputNodes: make([]uint, 0),
 		UsesBias:    g1.UsesBias, // if g1 uses bias, g2 sure ought to as well
-	}
 
-	// Also need to crossover activation functions, if parents use this feature
-	if g1.ActivationFunctions != nil && g2.ActivationFunctions != nil { // If one is nil, both should be
-		g.ActivationFunctions = make(map[uint]string)
+		MutableActivations: g1.MutableActivations,
+		ActivationSet:      g1.ActivationSet,
+
+		MinWeight: g1.MinWeight,
+		MaxWeight: g1.MaxWeight,
+
+		MutationRatios: g1.MutationRatios,
+
+		Recurrent:       g1.Recurrent,
+		RelaxationSteps: g1.RelaxationSteps,
+
+		AutoRepair: g1.AutoRepair,
 	}
 
 	// Line up genes by innovation number
@@ -183,7 +184,6 @@ func (n *Network) Crossover(others []ma.Organism) ma.Organism {
 				// This loop will be empty if i2 >= len(g2.Connection)
 				for ; i2 < len(g2.Connections); i2 += 1 {
 					g.Connections = append(g.Connections, g2.Connections[i2].Copy())
-					insertActivation(g, g2, i2)
 				}
 			}
 			break
@@ -191,7 +191,6 @@ func (n *Network) Crossover(others []ma.Organism) ma.Organism {
 			if n == moreFitParent {
 				for ; i1 < len(g1.Connections); i1 += 1 {
 					g.Connections = append(g.Connections, g1.Connections[i1].Copy())
-					insertActivation(g, g1, i1)
 				}
 			}
 			break
@@ -201,23 +200,14 @@ func (n *Network) Crossover(others []ma.Organism) ma.Organism {
 			// Inherit a gene randomly when there is an innovation number  match
 			if rand.Intn(2) == 0 {
 				g.Connections = append(g.Connections, g1.Connections[i1].Copy())
-				insertActivation(g, g1, i1)
 			} else {
 				g.Connections = append(g.Connections, g2.Connections[i2].Copy())
-				insertActivation(g, g2, i2)
 			}
 
-			// TODO: check gene disable safety
-			// If either gene is disabled, there is a 75% chance the inherited gene is disabled as well
-			// (as long as it's safe to do so)
-			// if !g1.Connections[i1].Enabled || !g2.Connections[i2].Enabled && rand.Intn(4) == 0 {
-			// 	g.Connections[len(g.Connections)-1].Enabled = false
-			// }
-
-			// Unless both genes are disabled, enable this one
-			// TODO: figure out why this is necessary
-			if !g1.Connections[i1].Enabled && !g2.Connections[i2].Enabled {
-				g.Connections[len(g.Connections)-1].Enabled = false
+			// If either gene is disabled, there is a 75% chance the inherited gene is disabled as well. Otherwise it gets
+			// a chance to come back. Safety is checked once the whole genome is put together
+			if !g1.Connections[i1].Enabled || !g2.Connections[i2].Enabled {
+				g.Connections[len(g.Connections)-1].Enabled = rand.Intn(4) == 0
 			} else {
 				g.Connections[len(g.Connections)-1].Enabled = true
 			}
@@ -228,14 +218,12 @@ func (n *Network) Crossover(others []ma.Organism) ma.Organism {
 			// Inherit disjoint genes from the more fit parent
 			if n == moreFitParent {
 				g.Connections = append(g.Connections, g1.Connections[i1].Copy())
-				insertActivation(g, g1, i1)
 			}
 
 			i1 += 1
 		} else if g1.Connections[i1].InnovationNumber > g2.Connections[i2].InnovationNumber {
 			if n2 == moreFitParent {
 				g.Connections = append(g.Connections, g2.Connections[i2].Copy())
-				insertActivation(g, g2, i2)
 
 			}
 
@@ -243,6 +231,58 @@ func (n *Network) Crossover(others []ma.Organism) ma.Organism {
 		}
 	}
 
+	// Node genes: every sensor and output, plus anything the inherited connections use. Nodes both parents have are
+	// inherited randomly, like matching connection genes
+	g1.PopulateNodeSlices()
+	g2.PopulateNodeSlices()
+
+	needed := make(map[uint]bool)
+	for _, node := range moreFitParent.DNA.Nodes {
+		if node.Type != HiddenNode {
+			needed[node.ID] = true
+		}
+	}
+	for _, edgeGene := range g.Connections {
+		needed[edgeGene.InNode] = true
+		needed[edgeGene.OutNode] = true
+	}
+
+	// Sorted so the random choices are reproducible
+	neededIds := make([]uint, 0, len(needed))
+	for nodeId := range needed {
+		neededIds = append(neededIds, nodeId)
+	}
+	sort.Slice(neededIds, func(i, j int) bool {
+		return neededIds[i] < neededIds[j]
+	})
+
+	for _, nodeId := range neededIds {
+		node1 := g1.Node(nodeId)
+		node2 := g2.Node(nodeId)
+
+		if node1 != nil && node2 != nil {
+			if rand.Intn(2) == 0 {
+				g.AddNodeGene(node1.Copy())
+			} else {
+				g.AddNodeGene(node2.Copy())
+			}
+		} else if node1 != nil {
+			g.AddNodeGene(node1.Copy())
+		} else if node2 != nil {
+			g.AddNodeGene(node2.Copy())
+		}
+	}
+
+	// Disabled genes might have cut off a sensor or output
+	g.reconnect(!g.Recurrent)
+
+	// Genes from different parents can still make a cycle together
+	if g.AutoRepair {
+		if errs := g.Repair(); errs != nil {
+			log.Book(fmt.Sprintf("Crossover made a genome that couldn't be repaired: %v\n", errs), log.DEBUG, log.DEBUG_MUTATION)
+		}
+	}
+
 	// Make an organism out of this genome
 	return n.NewFromGeneticCode(ma.GeneticCode(g))
 }
@@ -255,51 +295,29 @@ func (n *Network) LoadGeneticCode(dna ma.GeneticCode) {
 	n.DNA = dna.(*Genome)
 }
 
-// TODO: check for errors e.g. loops in feed-forward networks, connections between nonexistant nodes, etc.
-// TODO: potential bug if all connections are removed out of a node (e.g. through gene disable). should think if this is possible. may be the reason to have node genes
+// Connections to nodes that don't exist are skipped, use Genome.Validate to find them and other problems
 func (n *Network) Compile() error {
 	// No need to recompile, genome should never change
 	if n.isCompiled {
 		return nil
 	}
 
-	// TODO: figure out where this isn't being called but should be (maybe crossover?)
 	n.DNA.PopulateNodeSlices()
 
-	// Create all nodes
-	nNodes := len(n.DNA.SensorNodes) + len(n.DNA.HiddenNodes) + len(n.DNA.OutputNodes)
-	n.Nodes = make([]*Node, nNodes)
+	// Create all nodes, in the same order as the genome's node genes
+	n.Nodes = make([]*Node, len(n.DNA.Nodes))
+	n.Edges = make([]*Edge, 0)
+	n.nodeIds = make(map[uint]int)
 
 	log.Book(fmt.Sprintf("Allocating space for %d + %d + %d = %d nodes.\n", len(n.DNA.SensorNodes), len(n.DNA.HiddenNodes), len(n.DNA.OutputNodes), len(n.Nodes)), log.DEBUG, log.DEBUG_COMPILE)
 
-	// TODO: support for other activation functions
-	// Also there is probably a slightly cleaner way of doing this than 3 nearly identical loops but oh well
-	for _, v := range n.DNA.SensorNodes {
-		if v == 0 && n.DNA.UsesBias {
-			n.Nodes[v] = NewNode(n.DNA.ActivationFunctionOf(v), BiasNode)
-		} else {
-			n.Nodes[v] = NewNode(n.DNA.ActivationFunctionOf(v), SensorNode)
-		}
-		n.Nodes[v].Label = fmt.Sprintf("%d", v)
-	}
-
-	for _, v := range n.DNA.HiddenNodes {
-		n.Nodes[v] = NewNode(n.DNA.ActivationFunctionOf(v), HiddenNode)
-		n.Nodes[v].Label = fmt.Sprintf("%d", v)
-	}
-
-	for _, v := range n.DNA.OutputNodes {
-		n.Nodes[v] = NewNode(n.DNA.ActivationFunctionOf(v), OutputNode) // TODO: is this the best activation function for output?
-		n.Nodes[v].Label = fmt.Sprintf("%d", v)
-	}
-
-	if log.DEBUG_COMPILE {
-		for i, n := range n.Nodes {
-			if n == nil || fmt.Sprintf("%d", i) != n.Label {
-				log.Book(fmt.Sprintf("%s\n", n.String()), log.DEBUG, log.DEBUG_COMPILE)
-				panic("Nodes not in order while compiling!")
-			}
-		}
+	for i, nodeGene := range n.DNA.Nodes {
+		n.Nodes[i] = NewNode(FuncByName(n.DNA.ActivationFunctionNameOf(nodeGene.ID)), nodeGene.Type)
+		n.Nodes[i].Bias = nodeGene.Bias
+		n.Nodes[i].Aggregation = AggregationByName(nodeGene.Aggregation)
+		n.Nodes[i].Label = fmt.Sprintf("%d", nodeGene.ID)
+		n.Nodes[i].ID = nodeGene.ID
+		n.nodeIds[nodeGene.ID] = i
 	}
 
 	// Create all edges
@@ -308,13 +326,31 @@ func (n *Network) Compile() error {
 			log.Book(fmt.Sprintf("Skipping disabled connection from %d to %d\n", v.InNode, v.OutNode), log.DEBUG, log.DEBUG_COMPILE)
 			continue
 		}
+		inIndex, inOk := n.nodeIds[v.InNode]
+		outIndex, outOk := n.nodeIds[v.OutNode]
+		if !inOk || !outOk {
+			log.Book(fmt.Sprintf("Skipping dangling connection from %d to %d\n", v.InNode, v.OutNode), log.DEBUG, log.DEBUG_COMPILE)
+			continue
+		}
+
 		log.Book(fmt.Sprintf("Adding connection from %d to %d\n", v.InNode, v.OutNode), log.DEBUG, log.DEBUG_COMPILE)
-		newEdge := n.Nodes[v.InNode].AddChild(n.Nodes[v.OutNode])
+		newEdge := n.Nodes[inIndex].AddChild(n.Nodes[outIndex])
 		newEdge.Label = fmt.Sprintf("%d (%s)", v.InnovationNumber, MutationTypeString[v.Origin])
 		newEdge.Weight = v.Weight
+		newEdge.Gene = v
 		n.Edges = append(n.Edges, newEdge)
 	}
 
+	n.nodeIndex = make(map[*Node]int)
+	for i, node := range n.Nodes {
+		n.nodeIndex[node] = i
+	}
+
+	n.plan = n.buildPlan()
+	if n.plan.recurrent {
+		log.Book("Network has a cycle, compiling as a recurrent network\n", log.DEBUG, log.DEBUG_COMPILE)
+	}
+
 	// TODO: sort edges?
 
 	n.isCompiled = true
@@ -330,35 +366,219 @@ func (n *Network) IsCompiled() bool {
 	return n.isCompiled
 }
 
-// TODO: This is ActivateRecurrent, also write ActivateFeedForward
+// Indices into Nodes of the sensors (bias first, since it is always node 0) and outputs, ordered by node id
+func (n *Network) sensorIndices() []int {
+	return n.indicesOf(n.DNA.SensorNodes)
+}
+
+func (n *Network) outputIndices() []int {
+	return n.indicesOf(n.DNA.OutputNodes)
+}
+
+func (n *Network) indicesOf(nodeIds []uint) []int {
+	sorted := make([]uint, len(nodeIds))
+	copy(sorted, nodeIds)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	indices := make([]int, len(sorted))
+	for i, nodeId := range sorted {
+		indices[i] = n.nodeIds[nodeId]
+	}
+
+	return indices
+}
+
+// Compiled node for a node gene, nil if the network doesn't have one
+func (n *Network) NodeById(nodeId uint) *Node {
+	n.Compile()
+
+	if i, ok := n.nodeIds[nodeId]; ok {
+		return n.Nodes[i]
+	}
+
+	return nil
+}
+
+type CycleError struct {
+	Msg string
+}
+
+func (e *CycleError) Error() string {
+	return e.Msg
+}
+
+// Topological order of Nodes (as indices), so that every node comes after all of its inputs
+// Order nodes so that every edge points forward, if possible. Networks with cycles still get a complete order, where
+// only the edges that close a cycle point backwards, and a CycleError is returned alongside it
+func (n *Network) evaluationOrder() ([]int, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := make([]int, len(n.Nodes))
+	postorder := make([]int, 0, len(n.Nodes))
+	hasCycle := false
+
+	// Depth first from the sensors, a node is finished once everything downstream of it is
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		for _, edge := range n.Nodes[i].Out {
+			j := n.nodeIndex[edge.Out]
+			if state[j] == visiting {
+				hasCycle = true
+			} else if state[j] == unvisited {
+				visit(j)
+			}
+		}
+		state[i] = done
+		postorder = append(postorder, i)
+	}
+
+	for _, i := range n.sensorIndices() {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+
+	// Nodes that can't be reached from a sensor
+	for i := range n.Nodes {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+
+	order := make([]int, len(postorder))
+	for k, i := range postorder {
+		order[len(postorder)-1-k] = i
+	}
+
+	if hasCycle {
+		return order, &CycleError{Msg: "network has a cycle, there is no feed-forward evaluation order"}
+	}
+
+	return order, nil
+}
+
+// Activate the network from scratch, with inputs[i] going to sensors[i], and leave each node's value available through
+// Node.Value. Recurrent networks are reset and relaxed for DNA.RelaxationSteps steps. See ActivateFeedForward and
+// Step for faster versions that don't go through Nodes
 func (n *Network) Activate(inputs []float64, sensors, outputs []*Node) error {
-	for _, node := range n.Nodes {
-		node.Reset()
+	n.Compile()
+
+	if len(inputs) != len(sensors) {
+		return fmt.Errorf("got %d inputs for %d sensors", len(inputs), len(sensors))
 	}
 
-	done := false
-	sanity := 100
-	for !done && sanity > 0 {
-		for i, in := range sensors {
-			in.SetDefaultValue(inputs[i])
-			log.Book(fmt.Sprintf("Sensor %s prop\n", in.Label), log.DEBUG, log.DEBUG_PROPAGATION)
-			in.ForwardPropogate()
+	// Plan wants inputs ordered by node id
+	ordered := make([]float64, len(n.plan.sensors))
+	for k, sensor := range sensors {
+		i, ok := n.nodeIndex[sensor]
+		if !ok {
+			return errors.New("sensor is not part of this network")
 		}
 
-		done = true
-		for _, out := range outputs {
-			if math.IsNaN(out.Value()) {
-				done = false
+		for j, s := range n.plan.sensors {
+			if s == i {
+				ordered[j] = inputs[k]
 			}
 		}
-		sanity -= 1
+	}
+
+	n.Reset()
+	for step := 0; step < n.relaxationSteps(); step += 1 {
+		n.plan.run(ordered, n.plan.values, n.plan.inputs, n.plan.out)
+	}
+
+	for i, node := range n.Nodes {
+		node.value = n.plan.values[i]
 	}
 
 	log.Book(fmt.Sprintf("\nProp trace\n%s\nGenome:\n\t%s\n%s\n", n.String(), n.DNA.NodesString(), n.DNA.ToPretty()), log.DEBUG, log.DEBUG_PROPAGATION)
 
-	if sanity <= 0 {
-		return errors.New("canceling activation, too many loops in the network")
+	for _, out := range outputs {
+		if math.IsNaN(out.Value()) {
+			return fmt.Errorf("output %s is NaN", out.Label)
+		}
 	}
 
 	return nil
 }
+
+// Inputs have one value per non-bias sensor, ordered by node id. The bias is filled in automatically
+func (n *Network) sensorValues(inputs []float64) ([]float64, error) {
+	values := make([]float64, len(n.plan.sensors))
+
+	j := 0
+	for k, i := range n.plan.sensors {
+		if n.Nodes[i].Type == BiasNode {
+			values[k] = 1
+			continue
+		}
+
+		if j >= len(inputs) {
+			return nil, fmt.Errorf("expected more than %d inputs", len(inputs))
+		}
+		values[k] = inputs[j]
+		j += 1
+	}
+
+	if j != len(inputs) {
+		return nil, fmt.Errorf("expected %d inputs but got %d", j, len(inputs))
+	}
+
+	return values, nil
+}
+
+// Activate the network on one sample. Inputs have one value per non-bias sensor and outputs one value per output
+// node, both ordered by node id. Recurrent networks start from a clean state every time
+func (n *Network) Predict(inputs []float64) ([]float64, error) {
+	n.Compile()
+
+	values, err := n.sensorValues(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := n.ActivateFeedForward(values)
+
+	prediction := make([]float64, len(out))
+	copy(prediction, out)
+
+	return prediction, nil
+}
+
+// Same as Predict, but recurrent networks keep their state between calls like Step. Call Reset to start over
+func (n *Network) PredictStep(inputs []float64) ([]float64, error) {
+	n.Compile()
+
+	values, err := n.sensorValues(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := n.Step(values)
+
+	prediction := make([]float64, len(out))
+	copy(prediction, out)
+
+	return prediction, nil
+}
+
+// Predict every sample in inputs
+func (n *Network) PredictBatch(inputs [][]float64) ([][]float64, error) {
+	predictions := make([][]float64, len(inputs))
+	for i, sample := range inputs {
+		prediction, err := n.Predict(sample)
+		if err != nil {
+			return nil, fmt.Errorf("sample %d: %s", i, err.Error())
+		}
+		predictions[i] = prediction
+	}
+
+	return predictions, nil
+}
@@ -29,12 +29,16 @@ type Node struct {
 	Out []*Edge
 
 	Label string
+	ID    uint // Node id in the genome this node was compiled from
 
 	Type NodeType
 
 	fn    ActivationFunction
 	value float64
 
+	Bias        float64
+	Aggregation Aggregation
+
 	visitedBy map[*Edge]bool // only used during activation
 }
 
@@ -109,11 +113,12 @@ func (n *Node) ForwardPropogate() {
 	log.Book(fmt.Sprintf("%s not activated, trying activation\n", n.Label), log.DEBUG, log.DEBUG_PROPAGATION)
 	if len(n.In) == 0 {
 		log.Book(fmt.Sprintf("%s is an input node\n", n.Label), log.DEBUG, log.DEBUG_PROPAGATION)
-		n.value = n.fn(n.value)
+		n.value = n.fn(n.value + n.Bias)
 	} else {
-		// Get sum of inputs
-		sum := 0.0
-		bias := 0.0
+		// Aggregate inputs
+		agg := n.Aggregation.identity()
+		count := 0
+		bias := n.Bias
 		allActivated := true
 
 		for _, p := range n.In {
@@ -121,16 +126,18 @@ func (n *Node) ForwardPropogate() {
 				log.Book(fmt.Sprintf("%s has an input which is not yet activated (%s)\n", n.Label, p.In.Label), log.DEBUG, log.DEBUG_PROPAGATION)
 				allActivated = false
 			} else if p.In.Type != BiasNode {
-				sum += p.In.value * p.Weight
-				log.Book(fmt.Sprintf("Adding #%s (value = %.2g*%.2g) to sum\n", p.In.Label, p.In.value, p.Weight), log.DEBUG, log.DEBUG_PROPAGATION)
+				agg = n.Aggregation.combine(agg, p.In.value*p.Weight)
+				count += 1
+				log.Book(fmt.Sprintf("Adding #%s (value = %.2g*%.2g) to %s\n", p.In.Label, p.In.value, p.Weight, n.Aggregation.String()), log.DEBUG, log.DEBUG_PROPAGATION)
 			} else {
-				bias = p.In.value * p.Weight
+				bias += p.In.value * p.Weight
 				log.Book(fmt.Sprintf("Adding #%s (value = %.2g*%.2g) to bias\n", p.In.Label, p.In.value, p.Weight), log.DEBUG, log.DEBUG_PROPAGATION)
 			}
 		}
 
-		log.Book(fmt.Sprintf("Sum is %.2g, bias is %.2g\n", sum, bias), log.DEBUG, log.DEBUG_PROPAGATION)
-		n.value = n.fn(sum) + bias
+		agg = n.Aggregation.finish(agg, count)
+		log.Book(fmt.Sprintf("Aggregate is %.2g, bias is %.2g\n", agg, bias), log.DEBUG, log.DEBUG_PROPAGATION)
+		n.value = n.fn(agg + bias)
 
 		if allActivated {
 			log.Book(fmt.Sprintf("%s activation finished! Value = %.2g\n", n.Label, n.value), log.DEBUG, log.DEBUG_PROPAGATION)
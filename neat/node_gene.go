@@ -0,0 +1,118 @@
+package neat
+
+import (
+	"fmt"
+	"sort"
+)
+
+type NodeGene struct {
+	ID   uint
+	Type NodeType
+
+	Activation  string
+	Aggregation string  // How weighted inputs are combined, empty for a sum
+	Bias        float64 // Added after aggregation, before the activation function
+}
+
+func NewNodeGene(id uint, typ NodeType, activation string) *NodeGene {
+	return &NodeGene{
+		ID:         id,
+		Type:       typ,
+		Activation: activation,
+	}
+}
+
+func (n *NodeGene) Copy() *NodeGene {
+	newNode := *n
+	return &newNode
+}
+
+var nodeTypeString = map[NodeType]string{
+	BiasNode:   "bias",
+	SensorNode: "sensor",
+	HiddenNode: "hidden",
+	OutputNode: "output",
+}
+
+func (n *NodeGene) String() string {
+	return fmt.Sprintf("{%d %s %s(%s + %.2f)}", n.ID, nodeTypeString[n.Type], n.Activation, AggregationByName(n.Aggregation).String(), n.Bias)
+}
+
+// Vanilla NEAT activation functions: sensors pass their input through, everything else is a sigmoid
+func defaultActivation(typ NodeType) string {
+	if typ == BiasNode || typ == SensorNode {
+		return IdentityStr
+	}
+
+	return NEATSigmoidStr
+}
+
+// Node gene with the given id, nil if there isn't one
+func (g *Genome) Node(nodeId uint) *NodeGene {
+	i := sort.Search(len(g.Nodes), func(i int) bool {
+		return g.Nodes[i].ID >= nodeId
+	})
+
+	if i < len(g.Nodes) && g.Nodes[i].ID == nodeId {
+		return g.Nodes[i]
+	}
+
+	return nil
+}
+
+// Insert a node gene, keeping Nodes sorted by id. Replaces any existing gene with the same id
+func (g *Genome) AddNodeGene(node *NodeGene) {
+	i := sort.Search(len(g.Nodes), func(i int) bool {
+		return g.Nodes[i].ID >= node.ID
+	})
+
+	if i < len(g.Nodes) && g.Nodes[i].ID == node.ID {
+		g.Nodes[i] = node
+		return
+	}
+
+	g.Nodes = append(g.Nodes, nil)
+	copy(g.Nodes[i+1:], g.Nodes[i:])
+	g.Nodes[i] = node
+}
+
+// Smallest id that is larger than every node in the genome
+func (g *Genome) NextNodeId() uint {
+	if len(g.Nodes) == 0 {
+		return 0
+	}
+
+	return g.Nodes[len(g.Nodes)-1].ID + 1
+}
+
+var (
+	// Id of the node that split each connection, by the connection's innovation number. Like InnovationHistory, the
+	// same split in different genomes gets the same node, so crossover and distance line up matching nodes
+	NodeHistory = make(map[uint]uint)
+
+	NextNodeNumber uint = 0
+)
+
+// Id for a node splitting edgeGene. Ids come from the history instead of the genome, so deleting the highest hidden
+// node and adding another doesn't hand its id (and its old connections' innovations) to an unrelated node. The only
+// way to get a deleted id back is to split the same connection again, which is the same innovation
+func (g *Genome) splitNodeId(edgeGene *EdgeGene) uint {
+	if id, ok := NodeHistory[edgeGene.InnovationNumber]; ok && g.Node(id) == nil {
+		return id
+	}
+
+	// Keep clear of the sensors and outputs, and of anything a hand built genome numbered itself
+	id := NextNodeNumber
+	if next := g.NextNodeId(); next > id {
+		id = next
+	}
+	NextNodeNumber = id + 1
+
+	// Splitting a connection that this genome already split (it got enabled again) makes a new node, but the history
+	// keeps the first one
+	if _, ok := NodeHistory[edgeGene.InnovationNumber]; !ok {
+		NodeHistory[edgeGene.InnovationNumber] = id
+	}
+
+	return id
+}
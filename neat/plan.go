@@ -0,0 +1,161 @@
+package neat
+
+// Flat evaluation plan for a network. Nodes are stored in topological order, and each node's incoming edges are a
+// contiguous run of (source, weight) pairs, so activation is a couple of tight loops with no allocations.
+// In recurrent networks some edges point backwards in the order, those read the source's value from the previous step
+type evaluationPlan struct {
+	order     []int                // Node indices in evaluation order
+	recurrent bool                 // True if some edges point backwards in order
+	fns       []ActivationFunction // Activation function of each node, by position in order
+	kinds     []NodeType           // Type of each node, by position in order
+	aggs      []Aggregation        // Aggregation of each node, by position in order
+	biases    []float64            // Bias of each node, by position in order
+
+	// Incoming edges of order[k] are sources[inStart[k]:inStart[k+1]]
+	inStart []int
+	sources []int
+	weights []float64
+	isBias  []bool
+
+	sensors []int // Node indices of sensors, ordered by node id (bias first)
+	outputs []int // Node indices of outputs, ordered by node id
+
+	// Scratch space, reused between activations. For recurrent networks values is also the network's state
+	values []float64
+	inputs []float64 // Per node, sensor input before the activation function
+	out    []float64
+}
+
+func (n *Network) buildPlan() *evaluationPlan {
+	order, err := n.evaluationOrder()
+
+	p := &evaluationPlan{
+		order:     order,
+		recurrent: err != nil,
+		fns:       make([]ActivationFunction, len(order)),
+		kinds:     make([]NodeType, len(order)),
+		aggs:      make([]Aggregation, len(order)),
+		biases:    make([]float64, len(order)),
+		inStart:   make([]int, len(order)+1),
+		sources:   make([]int, 0, len(n.Edges)),
+		weights:   make([]float64, 0, len(n.Edges)),
+		isBias:    make([]bool, 0, len(n.Edges)),
+
+		sensors: n.sensorIndices(),
+		outputs: n.outputIndices(),
+
+		values: make([]float64, len(n.Nodes)),
+		inputs: make([]float64, len(n.Nodes)),
+	}
+	p.out = make([]float64, len(p.outputs))
+
+	for k, i := range order {
+		node := n.Nodes[i]
+		p.fns[k] = node.fn
+		p.kinds[k] = node.Type
+		p.aggs[k] = node.Aggregation
+		p.biases[k] = node.Bias
+		p.inStart[k] = len(p.sources)
+
+		for _, edge := range node.In {
+			p.sources = append(p.sources, n.nodeIndex[edge.In])
+			p.weights = append(p.weights, edge.Weight)
+			p.isBias = append(p.isBias, edge.In.Type == BiasNode)
+		}
+	}
+	p.inStart[len(order)] = len(p.sources)
+
+	return p
+}
+
+// Same semantics as Node.ForwardPropogate: nodes without inputs (sensors) are fn(input + bias), other nodes are
+// fn(aggregate of weighted inputs + bias), where bias is the node's own bias plus any weighted bias node inputs
+func (p *evaluationPlan) run(inputs []float64, values, inputValues, out []float64) {
+	for i := range inputValues {
+		inputValues[i] = 0
+	}
+	for j, i := range p.sensors {
+		if j < len(inputs) {
+			inputValues[i] = inputs[j]
+		}
+	}
+
+	for k, i := range p.order {
+		start, end := p.inStart[k], p.inStart[k+1]
+		if start == end {
+			values[i] = p.fns[k](inputValues[i] + p.biases[k])
+			continue
+		}
+
+		agg := p.aggs[k]
+		acc := agg.identity()
+		count := 0
+		bias := p.biases[k]
+		for e := start; e < end; e += 1 {
+			if p.isBias[e] {
+				bias += values[p.sources[e]] * p.weights[e]
+			} else {
+				acc = agg.combine(acc, values[p.sources[e]]*p.weights[e])
+				count += 1
+			}
+		}
+
+		values[i] = p.fns[k](agg.finish(acc, count) + bias)
+	}
+
+	for j, i := range p.outputs {
+		out[j] = values[i]
+	}
+}
+
+// Activate a network without any allocations. Inputs have one value per sensor (including the bias), ordered by node
+// id. The returned slice is reused by the next activation, copy it if you need to keep it around.
+// Recurrent networks are reset first and relaxed for DNA.RelaxationSteps steps, use Step to keep their state
+func (n *Network) ActivateFeedForward(inputs []float64) []float64 {
+	n.Compile()
+
+	if n.plan.recurrent {
+		n.Reset()
+		for step := 0; step < n.relaxationSteps(); step += 1 {
+			n.plan.run(inputs, n.plan.values, n.plan.inputs, n.plan.out)
+		}
+		return n.plan.out
+	}
+
+	n.plan.run(inputs, n.plan.values, n.plan.inputs, n.plan.out)
+	return n.plan.out
+}
+
+// Advance the network by one time step. Inputs are the same as for ActivateFeedForward. Node values carry over
+// between steps, so recurrent connections see what their source was one step ago. Feed-forward networks have no
+// state and Step is the same as ActivateFeedForward
+func (n *Network) Step(inputs []float64) []float64 {
+	n.Compile()
+
+	n.plan.run(inputs, n.plan.values, n.plan.inputs, n.plan.out)
+	return n.plan.out
+}
+
+// Forget everything the network remembers from previous steps
+func (n *Network) Reset() {
+	n.Compile()
+
+	for i := range n.plan.values {
+		n.plan.values[i] = 0
+	}
+}
+
+// Steps needed for a stateless activation
+func (n *Network) relaxationSteps() int {
+	if !n.plan.recurrent || n.DNA.RelaxationSteps < 1 {
+		return 1
+	}
+
+	return n.DNA.RelaxationSteps
+}
+
+// True if the network has a cycle, and so has state between steps
+func (n *Network) IsRecurrent() bool {
+	n.Compile()
+	return n.plan.recurrent
+}
@@ -0,0 +1,255 @@
+package neat
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/TylerLeite/neuro-q/log"
+)
+
+// Shrinking evolved genomes down to something readable. Everything here either leaves the outputs exactly the same
+// or, for near-zero weights, changes them by about as much as the weights that got dropped
+
+type SimplificationError struct {
+	Msg string
+
+	Input    []float64
+	Expected []float64
+	Got      []float64
+}
+
+func (e *SimplificationError) Error() string {
+	return e.Msg
+}
+
+// Copy of the genome without disabled genes, enabled connections with |weight| < weightThreshold, hidden nodes that
+// can't reach an output, and hidden identity nodes that can be folded into their neighbors' weights
+func (g *Genome) Simplify(weightThreshold float64) *Genome {
+	s := g.Copy().(*Genome)
+	s.PopulateNodeSlices()
+
+	connections := make([]*EdgeGene, 0, len(s.Connections))
+	for _, edgeGene := range s.Connections {
+		if edgeGene.Enabled {
+			connections = append(connections, edgeGene)
+		}
+	}
+	s.Connections = connections
+
+	// Each step can make another one possible, e.g. dropping an edge can leave a dead end
+	for {
+		changed := s.dropSmallWeights(weightThreshold)
+		changed = s.removeDeadEnds() || changed
+		changed = s.foldIdentityNode() || changed
+
+		if !changed {
+			break
+		}
+	}
+
+	s.PopulateNodeSlices()
+	return s
+}
+
+// Can a weight be left out without changing anything but the weight's own contribution
+func (g *Genome) isDroppable(edgeGene *EdgeGene, inDegree map[uint]int) bool {
+	in := g.Node(edgeGene.InNode)
+	out := g.Node(edgeGene.OutNode)
+	if in == nil || out == nil {
+		return true
+	}
+
+	// Outputs keep at least one connection so the genome stays valid
+	if out.Type == OutputNode && inDegree[out.ID] <= 1 {
+		return false
+	}
+
+	// A zero only disappears from a sum. Bias node edges are always summed
+	return in.Type == BiasNode || AggregationByName(out.Aggregation) == SumAggregation
+}
+
+func (g *Genome) dropSmallWeights(weightThreshold float64) bool {
+	changed := false
+
+	for i := 0; i < len(g.Connections); i += 1 {
+		edgeGene := g.Connections[i]
+		if math.Abs(edgeGene.Weight) >= weightThreshold {
+			continue
+		}
+
+		_, inDegree := g.enabledDegrees()
+		if !g.isDroppable(edgeGene, inDegree) {
+			continue
+		}
+
+		log.Book(fmt.Sprintf("Simplify: dropping small weight %s\n", edgeGene.String()), log.DEBUG, log.DEBUG_MUTATION)
+		g.Connections = append(g.Connections[:i], g.Connections[i+1:]...)
+		i -= 1
+		changed = true
+	}
+
+	return changed
+}
+
+// Remove hidden nodes that no output depends on
+func (g *Genome) removeDeadEnds() bool {
+	in := make(map[uint][]uint)
+	for _, edgeGene := range g.Connections {
+		in[edgeGene.OutNode] = append(in[edgeGene.OutNode], edgeGene.InNode)
+	}
+
+	reachesOutput := make(map[uint]bool)
+	queue := make([]uint, 0)
+	for _, node := range g.Nodes {
+		if node.Type == OutputNode {
+			reachesOutput[node.ID] = true
+			queue = append(queue, node.ID)
+		}
+	}
+
+	for len(queue) > 0 {
+		nodeId := queue[0]
+		queue = queue[1:]
+
+		for _, parent := range in[nodeId] {
+			if !reachesOutput[parent] {
+				reachesOutput[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	changed := false
+	for _, node := range g.Nodes {
+		if node.Type == HiddenNode && !reachesOutput[node.ID] {
+			log.Book(fmt.Sprintf("Simplify: removing dead end %d\n", node.ID), log.DEBUG, log.DEBUG_MUTATION)
+			g.removeNode(node.ID)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// Replace one hidden node that just adds up its inputs (identity activation, sum, no bias) with direct connections
+// from each of its inputs to each of its outputs. Only does one node per call since the connections change
+func (g *Genome) foldIdentityNode() bool {
+	// A node in a recurrent genome delays its inputs by a step, so folding it could change the timing
+	if g.Recurrent {
+		return false
+	}
+
+	for _, node := range g.Nodes {
+		if node.Type != HiddenNode || node.Activation != IdentityStr || node.Bias != 0 || AggregationByName(node.Aggregation) != SumAggregation {
+			continue
+		}
+
+		ins := make([]*EdgeGene, 0)
+		outs := make([]*EdgeGene, 0)
+		for _, edgeGene := range g.Connections {
+			if edgeGene.InNode == node.ID && edgeGene.OutNode == node.ID {
+				ins = nil
+				break
+			} else if edgeGene.OutNode == node.ID {
+				ins = append(ins, edgeGene)
+			} else if edgeGene.InNode == node.ID {
+				outs = append(outs, edgeGene)
+			}
+		}
+
+		if len(ins) == 0 || !g.canFold(ins, outs) {
+			continue
+		}
+
+		log.Book(fmt.Sprintf("Simplify: folding identity node %d\n", node.ID), log.DEBUG, log.DEBUG_MUTATION)
+
+		g.removeNode(node.ID)
+		for _, in := range ins {
+			for _, out := range outs {
+				weight := in.Weight * out.Weight
+
+				merged := false
+				for _, edgeGene := range g.Connections {
+					if edgeGene.InNode == in.InNode && edgeGene.OutNode == out.OutNode {
+						edgeGene.Weight += weight
+						merged = true
+						break
+					}
+				}
+
+				if !merged {
+					g.Connections = append(g.Connections, NewEdgeGene(in.InNode, out.OutNode, weight, NoMutation))
+				}
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Folding turns the node's inputs into inputs of each output. That only keeps the same value if the output sums its
+// inputs, or if the node has a single (non-bias) input that doesn't get merged with an existing connection
+func (g *Genome) canFold(ins, outs []*EdgeGene) bool {
+	for _, out := range outs {
+		outNode := g.Node(out.OutNode)
+		if outNode == nil || AggregationByName(outNode.Aggregation) == SumAggregation {
+			continue
+		}
+
+		if len(ins) > 1 || g.Node(ins[0].InNode).Type == BiasNode {
+			return false
+		}
+
+		for _, edgeGene := range g.Connections {
+			if edgeGene.InNode == ins[0].InNode && edgeGene.OutNode == out.OutNode {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (n *Network) Simplify(weightThreshold float64) *Network {
+	return NewNetwork(n.DNA.Simplify(weightThreshold), n.Population)
+}
+
+// Check that other gives the same outputs as n for every input, within tolerance
+func (n *Network) VerifyEquivalent(other *Network, inputs [][]float64, tolerance float64) error {
+	for _, input := range inputs {
+		expected, err := n.Predict(input)
+		if err != nil {
+			return err
+		}
+
+		got, err := other.Predict(input)
+		if err != nil {
+			return err
+		}
+
+		for i := range expected {
+			if math.Abs(expected[i]-got[i]) > tolerance {
+				return &SimplificationError{
+					Msg:      fmt.Sprintf("output %d for %v is %g, expected %g", i, input, got[i], expected[i]),
+					Input:    input,
+					Expected: expected,
+					Got:      got,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Simplify and make sure the outputs didn't change by more than tolerance on the sample inputs
+func (n *Network) SimplifyAndVerify(weightThreshold float64, inputs [][]float64, tolerance float64) (*Network, error) {
+	simplified := n.Simplify(weightThreshold)
+	if err := n.VerifyEquivalent(simplified, inputs, tolerance); err != nil {
+		return nil, err
+	}
+
+	return simplified, nil
+}
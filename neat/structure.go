@@ -0,0 +1,271 @@
+package neat
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/TylerLeite/neuro-q/log"
+)
+
+// Structural mutations that remove things. All of them guarantee that every sensor keeps at least one enabled
+// outgoing connection and every output keeps at least one enabled incoming connection
+
+type MutationError struct {
+	Msg string
+}
+
+func (e *MutationError) Error() string {
+	return e.Msg
+}
+
+// Number of enabled connections out of and into each node
+func (g *Genome) enabledDegrees() (map[uint]int, map[uint]int) {
+	outDegree := make(map[uint]int)
+	inDegree := make(map[uint]int)
+
+	for _, edgeGene := range g.Connections {
+		if edgeGene.Enabled {
+			outDegree[edgeGene.InNode] += 1
+			inDegree[edgeGene.OutNode] += 1
+		}
+	}
+
+	return outDegree, inDegree
+}
+
+// Sensors and outputs that don't have an enabled connection
+func (g *Genome) disconnectedNodes() []uint {
+	outDegree, inDegree := g.enabledDegrees()
+
+	disconnected := make([]uint, 0)
+	for _, node := range g.Nodes {
+		switch node.Type {
+		case BiasNode, SensorNode:
+			if outDegree[node.ID] == 0 {
+				disconnected = append(disconnected, node.ID)
+			}
+		case OutputNode:
+			if inDegree[node.ID] == 0 {
+				disconnected = append(disconnected, node.ID)
+			}
+		}
+	}
+
+	return disconnected
+}
+
+// True if disabling this connection would leave a sensor or output disconnected
+func (g *Genome) isLastConnection(edgeGene *EdgeGene) bool {
+	if !edgeGene.Enabled {
+		return false
+	}
+
+	outDegree, inDegree := g.enabledDegrees()
+
+	in := g.Node(edgeGene.InNode)
+	if in != nil && (in.Type == BiasNode || in.Type == SensorNode) && outDegree[in.ID] <= 1 {
+		return true
+	}
+
+	out := g.Node(edgeGene.OutNode)
+	if out != nil && out.Type == OutputNode && inDegree[out.ID] <= 1 {
+		return true
+	}
+
+	return false
+}
+
+func (g *Genome) DisableConnection() error {
+	candidates := make([]*EdgeGene, 0)
+	for _, edgeGene := range g.Connections {
+		if edgeGene.Enabled && !g.isLastConnection(edgeGene) {
+			candidates = append(candidates, edgeGene)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return &MutationError{Msg: "no connection can be disabled without disconnecting a sensor or output"}
+	}
+
+	edgeGene := candidates[rand.Intn(len(candidates))]
+	edgeGene.Enabled = false
+	log.Book(fmt.Sprintf("Disabled connection %s\n", edgeGene.String()), log.DEBUG, log.DEBUG_MUTATION)
+
+	return nil
+}
+
+// Can edgeGene be enabled without connecting to a deleted node or, for feed-forward genomes, making a cycle
+func (g *Genome) canEnable(edgeGene *EdgeGene, feedForward bool) bool {
+	if g.Node(edgeGene.InNode) == nil || g.Node(edgeGene.OutNode) == nil {
+		return false
+	}
+
+	if feedForward && (edgeGene.InNode == edgeGene.OutNode || g.checkForCycles(int(edgeGene.InNode), int(edgeGene.OutNode))) {
+		return false
+	}
+
+	return true
+}
+
+func (g *Genome) EnableConnection(feedForward bool) error {
+	candidates := make([]*EdgeGene, 0)
+	for _, edgeGene := range g.Connections {
+		if !edgeGene.Enabled {
+			candidates = append(candidates, edgeGene)
+		}
+	}
+
+	// Checking for cycles is slow, so only check the one that gets picked
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	for _, edgeGene := range candidates {
+		if g.canEnable(edgeGene, feedForward) {
+			edgeGene.Enabled = true
+			log.Book(fmt.Sprintf("Enabled connection %s\n", edgeGene.String()), log.DEBUG, log.DEBUG_MUTATION)
+			return nil
+		}
+	}
+
+	return &MutationError{Msg: "no disabled connection can be enabled"}
+}
+
+// Remove hidden nodes that have no incoming or no outgoing connection genes, along with their connections. Removing
+// one node can orphan another, so repeat until nothing changes
+func (g *Genome) removeOrphans() {
+	for {
+		hasIn := make(map[uint]bool)
+		hasOut := make(map[uint]bool)
+		for _, edgeGene := range g.Connections {
+			// Self-loops don't count
+			if edgeGene.InNode != edgeGene.OutNode {
+				hasOut[edgeGene.InNode] = true
+				hasIn[edgeGene.OutNode] = true
+			}
+		}
+
+		removed := false
+		for _, node := range g.Nodes {
+			if node.Type == HiddenNode && (!hasIn[node.ID] || !hasOut[node.ID]) {
+				log.Book(fmt.Sprintf("Removing orphaned node %d\n", node.ID), log.DEBUG, log.DEBUG_MUTATION)
+				g.removeNode(node.ID)
+				removed = true
+				break
+			}
+		}
+
+		if !removed {
+			return
+		}
+	}
+}
+
+// Remove a node gene and every connection gene that uses it
+func (g *Genome) removeNode(nodeId uint) {
+	nodes := make([]*NodeGene, 0, len(g.Nodes))
+	for _, node := range g.Nodes {
+		if node.ID != nodeId {
+			nodes = append(nodes, node)
+		}
+	}
+	g.Nodes = nodes
+
+	connections := make([]*EdgeGene, 0, len(g.Connections))
+	for _, edgeGene := range g.Connections {
+		if edgeGene.InNode != nodeId && edgeGene.OutNode != nodeId {
+			connections = append(connections, edgeGene)
+		}
+	}
+	g.Connections = connections
+}
+
+// Try a deletion on a copy, keep it only if nothing important got disconnected
+func (g *Genome) tryDeletion(deletion func(*Genome)) bool {
+	candidate := g.Copy().(*Genome)
+	deletion(candidate)
+	candidate.removeOrphans()
+
+	if len(candidate.disconnectedNodes()) > 0 {
+		return false
+	}
+
+	g.Nodes = candidate.Nodes
+	g.Connections = candidate.Connections
+	g.PopulateNodeSlices()
+	return true
+}
+
+func (g *Genome) DeleteConnection() error {
+	order := rand.Perm(len(g.Connections))
+	for _, i := range order {
+		innovation := g.Connections[i].InnovationNumber
+
+		ok := g.tryDeletion(func(candidate *Genome) {
+			candidate.Connections = append(candidate.Connections[:i:i], candidate.Connections[i+1:]...)
+		})
+
+		if ok {
+			log.Book(fmt.Sprintf("Deleted connection %d\n", innovation), log.DEBUG, log.DEBUG_MUTATION)
+			return nil
+		}
+	}
+
+	return &MutationError{Msg: "no connection can be deleted without disconnecting a sensor or output"}
+}
+
+func (g *Genome) DeleteNode() error {
+	g.PopulateNodeSlices()
+
+	order := rand.Perm(len(g.HiddenNodes))
+	for _, i := range order {
+		nodeId := g.HiddenNodes[i]
+
+		ok := g.tryDeletion(func(candidate *Genome) {
+			candidate.removeNode(nodeId)
+		})
+
+		if ok {
+			log.Book(fmt.Sprintf("Deleted node %d\n", nodeId), log.DEBUG, log.DEBUG_MUTATION)
+			return nil
+		}
+	}
+
+	return &MutationError{Msg: "no hidden node can be deleted without disconnecting a sensor or output"}
+}
+
+// Enable connections until every sensor and output is connected again, used after crossover. If a node has no
+// disabled connection to fall back on, connect it directly (sensor -> output can never make a cycle)
+func (g *Genome) reconnect(feedForward bool) {
+	g.PopulateNodeSlices()
+
+	for _, nodeId := range g.disconnectedNodes() {
+		reconnected := false
+		for _, edgeGene := range g.Connections {
+			if edgeGene.Enabled || (edgeGene.InNode != nodeId && edgeGene.OutNode != nodeId) {
+				continue
+			}
+
+			if g.canEnable(edgeGene, feedForward) {
+				edgeGene.Enabled = true
+				reconnected = true
+				break
+			}
+		}
+
+		if reconnected || len(g.SensorNodes) == 0 || len(g.OutputNodes) == 0 {
+			continue
+		}
+
+		var edgeGene *EdgeGene
+		if g.Node(nodeId).Type == OutputNode {
+			edgeGene = NewEdgeGene(g.SensorNodes[rand.Intn(len(g.SensorNodes))], nodeId, g.RandomWeight(), NoMutation)
+		} else {
+			edgeGene = NewEdgeGene(nodeId, g.OutputNodes[rand.Intn(len(g.OutputNodes))], g.RandomWeight(), NoMutation)
+		}
+
+		// Might already have this exact connection disabled, but it was already checked above
+		g.Connections = append(g.Connections, edgeGene)
+		log.Book(fmt.Sprintf("Reconnected node %d with %s\n", nodeId, edgeGene.String()), log.DEBUG, log.DEBUG_MUTATION)
+	}
+}
@@ -0,0 +1,219 @@
+package neat
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/TylerLeite/neuro-q/log"
+)
+
+// Problems Validate can find with a genome. Cycles in feed-forward genomes are reported as CycleErrors
+
+type DanglingNodeError struct {
+	Msg string
+
+	Connection *EdgeGene
+	NodeId     uint // The endpoint that has no node gene
+}
+
+func (e *DanglingNodeError) Error() string {
+	return e.Msg
+}
+
+type DuplicateInnovationError struct {
+	Msg string
+
+	InnovationNumber uint
+}
+
+func (e *DuplicateInnovationError) Error() string {
+	return e.Msg
+}
+
+type SelfLoopError struct {
+	Msg string
+
+	Connection *EdgeGene
+}
+
+func (e *SelfLoopError) Error() string {
+	return e.Msg
+}
+
+type DisconnectedOutputError struct {
+	Msg string
+
+	NodeId uint
+}
+
+func (e *DisconnectedOutputError) Error() string {
+	return e.Msg
+}
+
+// Every problem with the genome, nil if there aren't any. Self-loops and cycles are only problems if the genome isn't
+// recurrent, and only enabled connections count for them
+func (g *Genome) Validate() []error {
+	errs := make([]error, 0)
+
+	for _, edgeGene := range g.Connections {
+		for _, nodeId := range []uint{edgeGene.InNode, edgeGene.OutNode} {
+			if g.Node(nodeId) == nil {
+				errs = append(errs, &DanglingNodeError{
+					Msg:        fmt.Sprintf("connection %s uses node %d, which doesn't exist", edgeGene.String(), nodeId),
+					Connection: edgeGene,
+					NodeId:     nodeId,
+				})
+			}
+		}
+	}
+
+	for _, innovation := range g.duplicateInnovations() {
+		errs = append(errs, &DuplicateInnovationError{
+			Msg:              fmt.Sprintf("innovation number %d is used by more than one connection", innovation),
+			InnovationNumber: innovation,
+		})
+	}
+
+	if !g.Recurrent {
+		for _, edgeGene := range g.Connections {
+			if edgeGene.Enabled && edgeGene.InNode == edgeGene.OutNode {
+				errs = append(errs, &SelfLoopError{
+					Msg:        fmt.Sprintf("connection %s is a self-loop in a feed-forward genome", edgeGene.String()),
+					Connection: edgeGene,
+				})
+			}
+		}
+
+		for _, edgeGene := range g.backEdges() {
+			errs = append(errs, &CycleError{
+				Msg: fmt.Sprintf("connection %s closes a cycle in a feed-forward genome", edgeGene.String()),
+			})
+		}
+	}
+
+	_, inDegree := g.enabledDegrees()
+	for _, node := range g.Nodes {
+		if node.Type == OutputNode && inDegree[node.ID] == 0 {
+			errs = append(errs, &DisconnectedOutputError{
+				Msg:    fmt.Sprintf("output %d has no enabled incoming connections", node.ID),
+				NodeId: node.ID,
+			})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// Innovation numbers that show up on more than one connection gene, smallest first
+func (g *Genome) duplicateInnovations() []uint {
+	counts := make(map[uint]int)
+	for _, edgeGene := range g.Connections {
+		counts[edgeGene.InnovationNumber] += 1
+	}
+
+	duplicates := make([]uint, 0)
+	for innovation, count := range counts {
+		if count > 1 {
+			duplicates = append(duplicates, innovation)
+		}
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i] < duplicates[j]
+	})
+
+	return duplicates
+}
+
+// Enabled connections (not counting self-loops) that point back to a node still being visited in a depth first
+// search. Disabling all of them leaves the genome acyclic
+func (g *Genome) backEdges() []*EdgeGene {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	out := make(map[uint][]*EdgeGene)
+	for _, edgeGene := range g.Connections {
+		if edgeGene.Enabled && edgeGene.InNode != edgeGene.OutNode {
+			out[edgeGene.InNode] = append(out[edgeGene.InNode], edgeGene)
+		}
+	}
+
+	state := make(map[uint]int)
+	back := make([]*EdgeGene, 0)
+
+	var visit func(nodeId uint)
+	visit = func(nodeId uint) {
+		state[nodeId] = visiting
+		for _, edgeGene := range out[nodeId] {
+			switch state[edgeGene.OutNode] {
+			case visiting:
+				back = append(back, edgeGene)
+			case unvisited:
+				visit(edgeGene.OutNode)
+			}
+		}
+		state[nodeId] = visited
+	}
+
+	// Start from the sensors so the edges that get blamed are the ones pointing backwards in the usual sense
+	for _, node := range g.Nodes {
+		if (node.Type == BiasNode || node.Type == SensorNode) && state[node.ID] == unvisited {
+			visit(node.ID)
+		}
+	}
+
+	for _, node := range g.Nodes {
+		if state[node.ID] == unvisited {
+			visit(node.ID)
+		}
+	}
+
+	return back
+}
+
+// Fix whatever Validate complains about: drop connections to missing nodes and repeated innovations, disable
+// self-loops and cycles in feed-forward genomes, then reconnect outputs. Returns whatever couldn't be fixed
+func (g *Genome) Repair() []error {
+	seen := make(map[uint]bool)
+	connections := make([]*EdgeGene, 0, len(g.Connections))
+	for _, edgeGene := range g.Connections {
+		if g.Node(edgeGene.InNode) == nil || g.Node(edgeGene.OutNode) == nil {
+			log.Book(fmt.Sprintf("Repair: dropping dangling connection %s\n", edgeGene.String()), log.DEBUG, log.DEBUG_MUTATION)
+			continue
+		}
+
+		if seen[edgeGene.InnovationNumber] {
+			log.Book(fmt.Sprintf("Repair: dropping duplicate connection %s\n", edgeGene.String()), log.DEBUG, log.DEBUG_MUTATION)
+			continue
+		}
+
+		seen[edgeGene.InnovationNumber] = true
+		connections = append(connections, edgeGene)
+	}
+	g.Connections = connections
+
+	if !g.Recurrent {
+		for _, edgeGene := range g.Connections {
+			if edgeGene.Enabled && edgeGene.InNode == edgeGene.OutNode {
+				log.Book(fmt.Sprintf("Repair: disabling self-loop %s\n", edgeGene.String()), log.DEBUG, log.DEBUG_MUTATION)
+				edgeGene.Enabled = false
+			}
+		}
+
+		for _, edgeGene := range g.backEdges() {
+			log.Book(fmt.Sprintf("Repair: disabling back edge %s\n", edgeGene.String()), log.DEBUG, log.DEBUG_MUTATION)
+			edgeGene.Enabled = false
+		}
+	}
+
+	g.reconnect(!g.Recurrent)
+
+	return g.Validate()
+}
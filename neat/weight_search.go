@@ -0,0 +1,241 @@
+package neat
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/TylerLeite/neuro-q/log"
+	"github.com/TylerLeite/neuro-q/ma"
+)
+
+// Weight-only local search strategies for neat networks. Topology stays fixed, and the budget is
+// ma.Population.LocalSearchGenerations fitness evaluations. Use as ma.Population.LocalSearchStrategy. Like HillClimb,
+// candidates go through the population's surrogate (if it has one) before the real fitness function
+
+// Starting step size, relative to the genome's weight range
+const weightSearchSigmaScale = 0.125
+
+func initialSigma(g *Genome) float64 {
+	if g.MaxWeight > g.MinWeight {
+		return (g.MaxWeight - g.MinWeight) * weightSearchSigmaScale
+	}
+
+	return 0.5
+}
+
+// Keep a weight inside the genome's range, genomes without one are left alone
+func (g *Genome) clampWeight(w float64) float64 {
+	if g.MaxWeight <= g.MinWeight {
+		return w
+	}
+
+	return math.Min(math.Max(w, g.MinWeight), g.MaxWeight)
+}
+
+// Make a new organism with the same topology as n but different weights
+func (n *Network) withWeights(weights []float64) *Network {
+	dna := n.DNA.Copy().(*Genome)
+	dna.SetWeights(weights)
+	return n.NewFromGeneticCode(dna).(*Network)
+}
+
+func observe(p *ma.Population, o ma.Organism, fitness float64) {
+	if p.Surrogate != nil {
+		p.Surrogate.Observe(o, fitness)
+	}
+}
+
+// (1+1)-ES with the 1/5th success rule for step size adaptation
+func OnePlusOneES(p *ma.Population, o ma.Organism) ma.Organism {
+	n := o.(*Network)
+
+	parent := n.DNA.Weights()
+	if len(parent) == 0 {
+		return o
+	}
+
+	best := o
+	bestFitness := p.FitnessOf(o)
+	observe(p, o, bestFitness)
+	sigma := initialSigma(n.DNA)
+
+	child := make([]float64, len(parent))
+	for i := 0; i < p.LocalSearchGenerations; i += 1 {
+		if math.IsInf(bestFitness, 1) {
+			break
+		}
+
+		for j, w := range parent {
+			child[j] = n.DNA.clampWeight(w + sigma*rand.NormFloat64())
+		}
+
+		// Screened out candidates count as failures
+		candidate := n.withWeights(child)
+		candidateFitness, evaluated := p.EvaluateIfPromising(candidate, bestFitness)
+
+		// Success should happen about 1/5th of the time, grow the step size if it happens more and shrink it if less
+		if evaluated && candidateFitness > bestFitness {
+			best = candidate
+			bestFitness = candidateFitness
+			copy(parent, child)
+			sigma *= 1.5
+		} else {
+			sigma *= math.Pow(1.5, -0.25)
+		}
+	}
+
+	log.Book(fmt.Sprintf("(1+1)-ES finished with f=%.4g, sigma=%.4g\n", bestFitness, sigma), log.DEBUG, log.DEBUG_WEIGHT_SEARCH)
+
+	return best
+}
+
+// Separable CMA-ES (Ros & Hansen 2008). The covariance matrix is kept diagonal so it scales to large genomes
+// without an eigendecomposition
+func SepCMAES(p *ma.Population, o ma.Organism) ma.Organism {
+	n := o.(*Network)
+
+	mean := n.DNA.Weights()
+	N := float64(len(mean))
+	if N == 0 {
+		return o
+	}
+
+	best := o
+	bestFitness := p.FitnessOf(o)
+	observe(p, o, bestFitness)
+	sigma := initialSigma(n.DNA)
+
+	// Strategy parameters, see "The CMA Evolution Strategy: A Tutorial" for where these come from
+	lambda := 4 + int(3*math.Log(N))
+	mu := lambda / 2
+
+	weights := make([]float64, mu)
+	weightSum := 0.0
+	for i := range weights {
+		weights[i] = math.Log(float64(mu)+0.5) - math.Log(float64(i+1))
+		weightSum += weights[i]
+	}
+
+	muEff := 0.0
+	for i := range weights {
+		weights[i] /= weightSum
+		muEff += weights[i] * weights[i]
+	}
+	muEff = 1 / muEff
+
+	cSigma := (muEff + 2) / (N + muEff + 5)
+	dSigma := 1 + 2*math.Max(0, math.Sqrt((muEff-1)/(N+1))-1) + cSigma
+	cc := (4 + muEff/N) / (N + 4 + 2*muEff/N)
+	c1 := 2 / ((N+1.3)*(N+1.3) + muEff)
+	cMu := math.Min(1-c1, 2*(muEff-2+1/muEff)/((N+2)*(N+2)+muEff))
+
+	// Diagonal covariance can learn faster
+	c1 = math.Min(1, c1*(N+2)/3)
+	cMu = math.Min(1-c1, cMu*(N+2)/3)
+
+	chiN := math.Sqrt(N) * (1 - 1/(4*N) + 1/(21*N*N))
+
+	C := make([]float64, len(mean))
+	pSigma := make([]float64, len(mean))
+	pc := make([]float64, len(mean))
+	for i := range C {
+		C[i] = 1
+	}
+
+	type sample struct {
+		y         []float64
+		z         []float64
+		fitness   float64
+		evaluated bool
+		network   *Network
+	}
+
+	generations := p.LocalSearchGenerations / lambda
+	if generations < 1 && p.LocalSearchGenerations > 0 {
+		generations = 1
+	}
+
+	for g := 0; g < generations; g += 1 {
+		if math.IsInf(bestFitness, 1) {
+			break
+		}
+
+		samples := make([]sample, lambda)
+		for k := range samples {
+			z := make([]float64, len(mean))
+			y := make([]float64, len(mean))
+			x := make([]float64, len(mean))
+			for i := range mean {
+				z[i] = rand.NormFloat64()
+				y[i] = math.Sqrt(C[i]) * z[i]
+				x[i] = n.DNA.clampWeight(mean[i] + sigma*y[i])
+			}
+
+			// Screened out samples are ranked by their predicted fitness, it's only a guess but it's below the best
+			// anyway
+			candidate := n.withWeights(x)
+			fitness, evaluated := p.EvaluateIfPromising(candidate, bestFitness)
+			samples[k] = sample{
+				y:         y,
+				z:         z,
+				fitness:   fitness,
+				evaluated: evaluated,
+				network:   candidate,
+			}
+		}
+
+		// Maximizing fitness, so most fit first
+		sort.Slice(samples, func(i, j int) bool {
+			return samples[i].fitness > samples[j].fitness
+		})
+
+		for _, s := range samples {
+			if s.evaluated && s.fitness > bestFitness {
+				best = s.network
+				bestFitness = s.fitness
+			}
+		}
+
+		yW := make([]float64, len(mean))
+		zW := make([]float64, len(mean))
+		for k := 0; k < mu; k += 1 {
+			for i := range mean {
+				yW[i] += weights[k] * samples[k].y[i]
+				zW[i] += weights[k] * samples[k].z[i]
+			}
+		}
+
+		pSigmaNorm := 0.0
+		for i := range mean {
+			// Samples get clamped, so keep the mean in range too or it wanders off past the boundary
+			mean[i] = n.DNA.clampWeight(mean[i] + sigma*yW[i])
+			pSigma[i] = (1-cSigma)*pSigma[i] + math.Sqrt(cSigma*(2-cSigma)*muEff)*zW[i]
+			pSigmaNorm += pSigma[i] * pSigma[i]
+		}
+		pSigmaNorm = math.Sqrt(pSigmaNorm)
+
+		hSigma := 0.0
+		if pSigmaNorm/math.Sqrt(1-math.Pow(1-cSigma, float64(2*(g+1)))) < (1.4+2/(N+1))*chiN {
+			hSigma = 1
+		}
+
+		for i := range mean {
+			pc[i] = (1-cc)*pc[i] + hSigma*math.Sqrt(cc*(2-cc)*muEff)*yW[i]
+
+			rankMu := 0.0
+			for k := 0; k < mu; k += 1 {
+				rankMu += weights[k] * samples[k].y[i] * samples[k].y[i]
+			}
+
+			C[i] = (1-c1-cMu)*C[i] + c1*(pc[i]*pc[i]+(1-hSigma)*cc*(2-cc)*C[i]) + cMu*rankMu
+		}
+
+		sigma *= math.Exp((cSigma / dSigma) * (pSigmaNorm/chiN - 1))
+	}
+
+	log.Book(fmt.Sprintf("sep-CMA-ES finished with f=%.4g, sigma=%.4g\n", bestFitness, sigma), log.DEBUG, log.DEBUG_WEIGHT_SEARCH)
+
+	return best
+}